@@ -2,19 +2,103 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"os"
 
-	"github.com/tsukinoko-kun/ohmygosh"
+	"github.com/tsukinoko-kun/ohmygosh/compiler"
+	"github.com/tsukinoko-kun/ohmygosh/runtime"
 )
 
 func main() {
+	// shell scripts started by this shell run with this executable
+	runtime.ShellPath, _ = os.Executable()
+	if len(os.Args) > 1 {
+		runScript(os.Args[1], os.Args[2:])
+	}
+
 	reader := bufio.NewReader(os.Stdin)
+	// the IoProvider lives as long as the session, so redirections made with exec persist
+	iop := runtime.DefaultIoProvider()
+	defer iop.Close()
+	// job control is on in an interactive shell, background jobs get their own process group
+	_ = runtime.SetOption("monitor", true)
+	_ = runtime.SetOption("histexpand", true)
+	if err := runtime.LoadHistory(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "ohmygosh: %s\n", err)
+	}
 	for {
-		if wd, err := os.Getwd(); err == nil {
+		// jobs that finished in the meantime are reported before the prompt
+		runtime.NotifyJobs(os.Stderr)
+		if wd, err := runtime.WorkingDirectory(); err == nil {
 			print(wd + " ")
 		}
 		print("$ ")
-		text, _ := reader.ReadString('\n')
-		_, _ = ohmygosh.Execute(text)
+		text, err := reader.ReadString('\n')
+		// keep reading while the command is not complete (open quotes, here documents, trailing |, && or \)
+		for err == nil && compiler.Incomplete(text) {
+			print(ps2())
+			var line string
+			line, err = reader.ReadString('\n')
+			text += line
+		}
+		if text != "" && runtime.Option("histexpand") {
+			// history references are replaced before the line is parsed, the result is shown like bash does
+			expanded, changed, expandErr := runtime.ExpandHistory(text)
+			switch {
+			case expandErr != nil:
+				_, _ = fmt.Fprintf(os.Stderr, "ohmygosh: %s\n", expandErr)
+				text = ""
+			case changed:
+				_, _ = fmt.Fprint(os.Stderr, expanded)
+				text = expanded
+			}
+		}
+		if text != "" {
+			runtime.AddHistory(text)
+			// every command closes the streams it opened when it finishes,
+			// the IoProvider itself stays open because background jobs of this line may still use it
+			_, execErr := compiler.Execute(text, iop)
+			if errors.Is(execErr, compiler.ErrIncompleteInput) || errors.As(execErr, &compiler.CompilerError{}) {
+				// like in bash, the line is skipped with the status 2
+				_, _ = fmt.Fprintf(os.Stderr, "ohmygosh: %s\n", execErr)
+				runtime.SetStatus(runtime.ExitStatus(2))
+			}
+			if runtime.Option("errexit") && runtime.Errexit(execErr) {
+				runtime.Exit(runtime.Status())
+			}
+		}
+		if err != nil {
+			// end of input
+			runtime.Exit(runtime.Status())
+		}
+	}
+}
+
+// runScript runs the script file with the given positional parameters and exits with its status.
+func runScript(file string, args []string) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "ohmygosh: %s\n", err)
+		os.Exit(127)
+	}
+	runtime.SetScriptName(file)
+	runtime.SetPositionalParameters(args)
+	iop := runtime.DefaultIoProvider()
+	wg, err := compiler.Execute(string(content), iop)
+	wg.Wait()
+	iop.Close()
+	if errors.Is(err, compiler.ErrIncompleteInput) || errors.As(err, &compiler.CompilerError{}) {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+		runtime.Exit(2)
+	}
+	runtime.Exit(runtime.Status())
+}
+
+// ps2 returns the prompt that is shown while a command continues on the next line.
+func ps2() string {
+	if ps2, ok := runtime.Variable("PS2"); ok {
+		return ps2
 	}
+	return "> "
 }
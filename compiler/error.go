@@ -1,6 +1,36 @@
 package compiler
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrIncompleteInput is returned instead of a CompilerError if the input ends before the command is complete.
+// An interactive shell should read more input and try again (see Incomplete).
+var ErrIncompleteInput = errors.New("unexpected end of input")
+
+// ExpansionError is returned if an expansion can't be evaluated, like an arithmetic expansion that divides by 0.
+// Unlike a CompilerError it only fails the statement that contains the expansion.
+type ExpansionError struct {
+	Expression string
+	Err        error
+}
+
+func (e ExpansionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Expression, e.Err)
+}
+
+func (e ExpansionError) Unwrap() error {
+	return e.Err
+}
+
+// unsetParameterError is the error of an expansion of an unset parameter, with set -u or ${name:?word}.
+// Like in bash it ends the execution of the input, so a non-interactive shell exits.
+type unsetParameterError string
+
+func (e unsetParameterError) Error() string {
+	return string(e)
+}
 
 type CompilerErrorKind uint8
 
@@ -8,47 +8,181 @@ import (
 	"github.com/tsukinoko-kun/ohmygosh/runtime"
 )
 
-func Execute(text string, iop *runtime.IoProvider) (*sync.WaitGroup, error) {
-	tokens, err := LexicalAnalysis(text, iop)
-	if err != nil {
-		return nil, errors.Join(errors.New("failed to lexically analyze input"), err)
-	}
-
-	commands, err := Parse(text, tokens, iop)
-	if err != nil {
-		return nil, errors.Join(errors.New("failed to parse input"), err)
+func init() {
+	runtime.Interpret = func(text string, iop *runtime.IoProvider) error {
+		wg, err := Execute(text, iop)
+		wg.Wait()
+		var compilerErr CompilerError
+		if errors.As(err, &compilerErr) {
+			return runtime.SyntaxError{Err: compilerErr}
+		}
+		if errors.Is(err, ErrIncompleteInput) {
+			return runtime.SyntaxError{Err: ErrIncompleteInput}
+		}
+		return err
 	}
+	runtime.Incomplete = Incomplete
+}
 
+// Execute runs the given text statement by statement.
+// Each statement is analyzed and parsed right before it runs, so it sees the effects of the statements before it.
+// The same goes for the pipelines of an && or || chain, the ones that are skipped are only checked for their syntax.
+// Like in bash, a failing command doesn't stop the execution unless set -e is on.
+// The returned error is the result of the last command.
+func Execute(text string, iop *runtime.IoProvider) (*sync.WaitGroup, error) {
 	wg := &sync.WaitGroup{}
+	i := 0
+	var lastErr error
+	// continued is set if the next pipeline continues an && or || chain, skip is set if it doesn't run
+	continued, skip := false, false
 
-	for i, command := range commands {
-		if command.Background {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
+	for start := 0; start < len(text); {
+		lexIop := iop
+		if skip {
+			lexIop = nil
+		}
+		tokens, end, err := lexicalAnalysis(text, start, lexIop, lexicalEndPipeline)
+		expansionFailed := errors.As(err, new(ExpansionError))
+		if _, ok := chainOperator(tokens); ok && !continued && (err == nil || expansionFailed) {
+			// a chain that runs in the background is a job, it is analyzed at once like a single pipeline
+			rest, restEnd, restErr := lexicalAnalysis(text, end+1, nil, lexicalEndStatement)
+			switch {
+			case restErr != nil:
+				err = restErr
+			case len(rest) == 0 || rest[len(rest)-1].Kind != LexicalBackground:
+			case expansionFailed:
+				end = restEnd
+				tokens = append(tokens, rest...)
+			default:
+				var more []LexicalToken
+				more, end, err = lexicalAnalysis(text, end+1, iop, lexicalEndStatement)
+				tokens = append(tokens, more...)
+			}
+		}
+		op, chained := chainOperator(tokens)
+		if errors.As(err, new(ExpansionError)) {
+			// like a failing command, a failed expansion only fails its pipeline
+			start = end + 1
+			// a failed command substitution has reported its errors already, only its status is left
+			if !errors.As(err, new(runtime.ExitStatus)) {
+				_, _ = fmt.Fprintf(iop.DefaultErr, "%s: %s\n", runtime.ScriptName(), err)
+			}
+			runtime.SetStatus(err)
+			if chained {
+				// the status of a pipeline in front of && or || is tested
+				err = runtime.ErrexitExempt(err)
+			} else {
+				runtime.RunTrap("ERR")
+			}
+			// like in bash, an unset parameter ends the execution, a non-interactive shell exits then
+			if (runtime.Option("errexit") && !chained) || errors.As(err, new(unsetParameterError)) {
+				return wg, err
+			}
+			lastErr = err
+			continued, skip = chained, chained && skipsNext(op)
+			continue
+		}
+		if err != nil {
+			return wg, errors.Join(errors.New("failed to lexically analyze input"), err)
+		}
+		start = end + 1
+		if skip {
+			// a skipped pipeline doesn't change the status, which decides about the next one too
+			continued, skip = chained, chained && skipsNext(op)
+			continue
+		}
+		if chained {
+			tokens = tokens[:len(tokens)-1]
+		}
+
+		commands, err := Parse(text, tokens, iop)
+		if err != nil {
+			return wg, errors.Join(errors.New("failed to parse input"), err)
+		}
+
+		// a command started with & is a job, together with the commands of its pipeline
+		for k, command := range commands {
+			if !command.Background || command.PipeOut != nil {
+				continue
+			}
+			first := k
+			for first > 0 && commands[first-1].PipeOut != nil {
+				first--
+			}
+			runtime.NewJob(commands[first : k+1])
+		}
+
+		for _, command := range commands {
+			if command.Background {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					err := command.Execute(iop)
+					// the failure of a command in a pipeline is part of the status of the pipeline
+					if err != nil && command.PipeOut == nil {
+						err = errors.Join(fmt.Errorf("failed to execute command %d: %q", i, command.String()), err)
+						_, _ = fmt.Fprintln(iop.DefaultErr, err)
+					}
+					if command.PipeOut == nil {
+						command.Job.Finish(err)
+					}
+				}(i)
+				if command.Job != nil && command.PipeOut == nil && runtime.Option("monitor") {
+					// like bash with job control, the job number and process ID are shown when the job starts
+					_, _ = fmt.Fprintf(iop.DefaultErr, "[%d] %d\n", command.Job.ID, command.Job.Pid())
+				}
+				// the status of a command started in the background is 0
+				lastErr = nil
+				runtime.SetStatus(nil)
+			} else {
 				err := command.Execute(iop)
-				stdout := **command.Stdout
-				_ = stdout.Close()
-				stderr := **command.Stderr
-				_ = stderr.Close()
-				iop.Close()
+				runtime.SetStatus(err)
+				if runtime.IsReturn(err) {
+					// return ends the function or sourced file, the caller gets the status
+					return wg, err
+				}
+				if errors.As(err, new(unsetParameterError)) {
+					// an unset parameter in a sourced file or eval ends the execution of the caller too
+					return wg, err
+				}
 				if err != nil {
 					err = errors.Join(fmt.Errorf("failed to execute command %d: %q", i, command.String()), err)
-					_, _ = fmt.Fprintln(iop.DefaultErr, err)
+					if chained {
+						// the status of a pipeline in front of && or || is tested
+						err = runtime.ErrexitExempt(err)
+					}
+					if runtime.Errexit(err) {
+						runtime.RunTrap("ERR")
+						if runtime.Option("errexit") {
+							return wg, err
+						}
+					}
 				}
-			}()
-		} else {
-			err := command.Execute(iop)
-			iop.Close()
-			stdout := **command.Stdout
-			_ = stdout.Close()
-			stderr := **command.Stderr
-			_ = stderr.Close()
-			if err != nil {
-				return nil, errors.Join(fmt.Errorf("failed to execute command %d: %q", i, command.String()), err)
+				lastErr = err
 			}
+			i++
 		}
+		continued, skip = chained, chained && skipsNext(op)
+	}
+
+	return wg, lastErr
+}
+
+// chainOperator returns the && or || that the tokens of a pipeline end with, which chains it to the next pipeline.
+// It reports whether there is one.
+func chainOperator(tokens []LexicalToken) (LexicalTokenKind, bool) {
+	if len(tokens) == 0 {
+		return 0, false
 	}
+	op := tokens[len(tokens)-1].Kind
+	return op, op == LexicalAnd || op == LexicalOr
+}
 
-	return wg, nil
+// skipsNext reports whether the pipeline after the operator op of a chain is skipped.
+// Like in bash, && and || have the same precedence, op tests the status of the last pipeline that ran.
+func skipsNext(op LexicalTokenKind) bool {
+	if op == LexicalAnd {
+		return runtime.Status() != 0
+	}
+	return runtime.Status() == 0
 }
@@ -2,6 +2,10 @@ package compiler_test
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/tsukinoko-kun/ohmygosh/compiler"
@@ -39,6 +43,12 @@ func TestExecute(t *testing.T) {
 			"",
 			"hello world\n",
 		},
+		{
+			"type nonexistent_command |& cat",
+			"type: could not find nonexistent_command\n",
+			"",
+			"",
+		},
 		{
 			`cat <<xyz
 hello
@@ -48,6 +58,251 @@ xyz`,
 			"",
 			"",
 		},
+		{
+			"#!/bin/ohmygosh\n# comment\necho hi # note\necho a#b",
+			"hi\na#b\n",
+			"",
+			"",
+		},
+		{
+			"echo a \\\n  b |\n  cat &&\n  echo 'c\nd'",
+			"a b\nc\nd\n",
+			"",
+			"",
+		},
+		{
+			"! false && echo negated; ! echo a | cat || echo failed",
+			"negated\na\nfailed\n",
+			"",
+			"",
+		},
+		{
+			`files=(a "b c" d)
+files+=(e)
+printf "<%s>" "${files[@]}"; echo
+echo ${files[1]} ${#files[@]} ${!files[@]} ${files[-1]} $files "${files[*]}"
+unset 'files[1]'
+echo ${!files[@]} ${#files[@]}`,
+			"<a><b c><d><e>\nb c 4 0 1 2 3 e a a b c d e\n0 2 3 3\n",
+			"",
+			"",
+		},
+		{
+			`declare -A versions=([go]=1.22 [node]=20)
+versions[rust]=1.77
+echo ${versions[go]} ${#versions[@]}
+unset 'versions[node]'
+echo ${!versions[@]}`,
+			"1.22 3\ngo rust\n",
+			"",
+			"",
+		},
+		{
+			"greeting=hello; greeting+=' world'; echo $greeting ${#greeting}; name=greeting; echo ${!name}",
+			"hello world 11\nhello world\n",
+			"",
+			"",
+		},
+		{
+			"false; echo continued; false && echo skipped; echo ${UNSET_VARIABLE:-default} ${UNSET_VARIABLE-}",
+			"continued\ndefault \n",
+			"",
+			"",
+		},
+		{
+			"echo out >&2",
+			"",
+			"out\n",
+			"",
+		},
+		{
+			"echo swapped 3>&1 1>&2 2>&3",
+			"",
+			"swapped\n",
+			"",
+		},
+		{
+			"echo closed >&- || echo failed",
+			"failed\n",
+			"echo: write error: bad file descriptor\n",
+			"",
+		},
+		{
+			"exec 2>&1; echo err >&2",
+			"err\n",
+			"",
+			"",
+		},
+		{
+			"exec 3>&2; echo three >&3; exec 3>&-; echo closed >&3 || echo failed",
+			"failed\n",
+			"three\necho: write error: bad file descriptor\n",
+			"",
+		},
+		{
+			`cat <<a <<b | cat
+first
+a
+second
+b
+echo done`,
+			"second\ndone\n",
+			"",
+			"",
+		},
+		{
+			`cmd='EVALED=$((1 + 2)); echo "$EVALED"'
+eval "$cmd" | cat
+eval "echo out; echo err >&2" 2>&1
+eval "$(echo 'EVALED=4;' 'echo $EVALED')"`,
+			"3\nout\nerr\n4\n",
+			"",
+			"",
+		},
+		{
+			`eval 'echo "x'; echo $?
+eval 'echo a >'; echo $?
+eval "eval 'echo a >'"; echo $?`,
+			"2\n2\n2\n",
+			"eval: unexpected end of input\neval: parser error at line 1, column 8: unexpected end of input after redirect\neval: parser error at line 1, column 8: unexpected end of input after redirect\n",
+			"",
+		},
+		{
+			`read first rest
+read -r raw
+read escaped
+IFS=: read -a fields
+read -n 2 -d x short
+read
+echo "[$first] [$rest] [$raw] [$escaped] ${#fields[@]} [${fields[2]}] [$short] [$REPLY]"`,
+			"[one] [two  three] [a\\ b] [a bc] 4 [] [zy] [x]\n",
+			"",
+			"one two  three\na\\ b\na\\ b\\\nc\nx:y::z\nzyx\n",
+		},
+		{
+			`printf "%s=%d;" a 1 b 2 c
+printf "[%5s|%-4s|%.2s|%03d|%+i|%x|%#o|%u]" ab ab abc 7 7 255 8 -1
+printf "[%.2f|%e|%g|%c|%q]" 2.5 1234.5 0.0001 hello "a b"
+printf "[%q]" "it's" "" "~/x" "#c" 'a$b,c' "$(printf 'a\tb')"
+printf -v out "%*d" 4 42
+printf "[%s][%d]" "$out" "'A"`,
+			"a=1;b=2;c=0;[   ab|ab  |ab|007|+7|ff|010|18446744073709551615][2.50|1.234500e+03|0.0001|h|a\\ b]" +
+				"[it\\'s][''][\\~/x][\\#c][a\\$b\\,c][$'a\\tb'][  42][65]",
+			"",
+			"",
+		},
+		{
+			`[ -d . ] && echo dir
+test -f . || echo "not a file"
+[ 3 -lt 10 -a b \> a ] && echo and
+[ ! -e nonexistent -o -z x ] && echo or
+[ \( 1 -eq 2 \) -o \( x != y \) ] && echo parens
+[ -n ] && [ = = = ] && echo posix
+test; echo $?
+[ x -eq 1 ] 2>&1; echo $?
+[ x = x; echo $?`,
+			"dir\nnot a file\nand\nor\nparens\nposix\n1\n[: x: integer expression expected\n2\n2\n",
+			"[: missing `]'\n",
+			"",
+		},
+		{
+			`file=main.go pattern="*.go"
+[[ $file == *.go && $file != "*.go" ]] && echo glob
+[[ $file == $pattern ]] && echo unquoted
+[[ $file == "$pattern" ]] || echo quoted
+[[ -z $unset || ( 1+1 -eq 2 && a < b ) ]] && echo operators
+[[ $file =~ ^([a-z]+)\.(go|rs)$ ]]
+echo "${BASH_REMATCH[0]} ${BASH_REMATCH[1]} ${BASH_REMATCH[2]}"
+[[ -n $unset ||
+   x == y ]]; echo $?`,
+			"glob\nunquoted\nquoted\noperators\nmain.go main go\n1\n",
+			"",
+			"",
+		},
+		{
+			`seq 3
+seq -s , 2 3 11
+seq -w 9 11
+seq -s " " 0 0.1 0.5
+seq -f "%.2f" 1 0.5 2
+seq 3 -1 2
+seq 1 1000000000 | read first
+echo $first`,
+			"1\n2\n3\n2,5,8,11\n09\n10\n11\n0.0 0.1 0.2 0.3 0.4 0.5\n1.00\n1.50\n2.00\n3\n2\n1\n",
+			"",
+			"",
+		},
+		{
+			`parallel -k -j 2 echo x{}y ::: a b c
+parallel --keep-order echo {.} {/} ::: dir/a.txt "b c.go"
+parallel -k echo {1}-{2} ::: a b ::: 1 2
+parallel -k "echo {}; [ {} = 2 ]"; echo $?`,
+			"xay\nxby\nxcy\ndir/a a.txt\nb c b c.go\na-1\na-2\nb-1\nb-2\n1\n2\n3\n2\n",
+			"",
+			"1\n2\n3\n",
+		},
+		{
+			`wait
+sleep 0.05 & sleep 5 &
+jobs
+kill %2
+wait %2; echo $?
+wait -n; echo $?
+wait -n; echo $?
+kill %5; echo $?`,
+			"[1]-  Running                 sleep 0.05 &\n[2]+  Running                 sleep 5 &\n143\n0\n127\n1\n",
+			"failed to execute command 2: \"sleep \\\"5\\\"\"\nexit status 143\nkill: %5: no such job\n",
+			"",
+		},
+		{
+			`sleep 0.05 & wait $!; echo $?
+sleep 5 & kill $!; wait $!; echo $?
+x=1 & [ -n "$!" ] && echo pid`,
+			"0\n143\npid\n",
+			"failed to execute command 3: \"sleep \\\"5\\\"\"\nexit status 143\n",
+			"",
+		},
+		{
+			`OPTIND=1
+getopts abo: opt -ab -o out -x file; echo "$? $opt $OPTIND"
+getopts abo: opt -ab -o out -x file; echo "$? $opt $OPTIND"
+getopts abo: opt -ab -o out -x file; echo "$? $opt $OPTARG $OPTIND"
+getopts abo: opt -ab -o out -x file; echo "$? $opt $OPTIND"
+getopts abo: opt -ab -o out -x file; echo "$? $opt $OPTIND"
+OPTIND=1
+getopts :o: opt -o; echo "$? $opt $OPTARG"`,
+			"0 a 1\n0 b 2\n0 o out 4\n0 ? 5\n1 ? 5\n0 : o\n",
+			"ohmygosh: illegal option -- x\n",
+			"",
+		},
+		{
+			`echo $((1/0)); echo "after $?"
+x=$((7 % 0)) && echo never
+cat <<EOF
+$((2/0))
+EOF
+echo "$((6/3)) $(echo $((1/0)); echo inner)"`,
+			"after 1\n2 inner\n",
+			"ohmygosh: 1/0: division by 0\nohmygosh: 7 % 0: division by 0\nohmygosh: 2/0: division by 0\nohmygosh: 1/0: division by 0\n",
+			"",
+		},
+		{
+			`sub_y=1; sub_a=(1 2)
+echo "$(sub_y=2; sub_a[0]=9; export SUB_E=1; set -u; trap 'echo int' INT; set -- x; cd /; echo in)"
+echo "$sub_y ${sub_a[0]} [${SUB_E-unset}] $# $(trap -p INT)"
+set -o | grep nounset`,
+			"in\n1 1 [unset] 0 \nnounset        \toff\n",
+			"",
+			"",
+		},
+		{
+			`sub_x=$(false); echo "$? [${sub_x-unset}]"
+sub_x=$(echo a; sh -c "exit 3"); echo "$? [${sub_x-unset}]"
+echo "$(echo $((1/0)))"; echo $?`,
+			"1 [unset]\n3 [unset]\n1\n",
+			"ohmygosh: 1/0: division by 0\n",
+			"",
+		},
 	}
 
 	for i, c := range cases {
@@ -69,3 +324,473 @@ xyz`,
 		})
 	}
 }
+
+func TestNoClobber(t *testing.T) {
+	defer func() {
+		_ = runtime.SetOption("noclobber", false)
+	}()
+	file := filepath.Join(t.TempDir(), "file")
+	in := fmt.Sprintf(`echo first > %[1]q
+set -o noclobber
+echo second > %[1]q || echo refused
+cat %[1]q
+echo third >| %[1]q
+cat %[1]q
+echo 12345 >| %[1]q
+echo ab 1<> %[1]q
+cat %[1]q
+set +o noclobber
+echo fourth > %[1]q
+cat %[1]q`, file)
+
+	iop, stdout, _ := runtime.TestIoProvider("")
+	defer iop.Close()
+	wg, err := compiler.Execute(in, iop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "refused\nfirst\nthird\nab\n45\nfourth\n"
+	if stdout.String() != expected {
+		t.Errorf("stdout: %q, expected: %q", stdout.String(), expected)
+	}
+}
+
+func TestChain(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PWD", wd)
+	defer func() {
+		_ = os.Chdir(wd)
+		for _, name := range []string{"chain_x", "chain_y", "chain_v"} {
+			_ = runtime.UnsetVariable(name)
+		}
+	}()
+
+	// every pipeline of a chain sees the effects of the ones before it
+	file := runtime.Quote(filepath.Join(dir, "line"))
+	iop, stdout, stderr := runtime.TestIoProvider("")
+	defer iop.Close()
+	wg, err := compiler.Execute(`chain_x=5 && echo "x=$chain_x"
+cd `+runtime.Quote(dir)+` && echo "$PWD"
+echo line > `+file+` && read -r chain_v < `+file+` && echo "$chain_v"
+[[ foo1 =~ ^([a-z]+)([0-9]+)$ ]] && echo "${BASH_REMATCH[1]} ${BASH_REMATCH[2]}"
+false && chain_y=$((1/0)) || echo "y=${chain_y-unset}"
+true && echo pipe | tr a-z A-Z
+cd `+runtime.Quote(wd), iop)
+	wg.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+	expected := "x=5\n" + dir + "\nline\nfoo 1\ny=unset\nPIPE\n"
+	if stdout.String() != expected {
+		t.Errorf("stdout: %q, expected: %q", stdout.String(), expected)
+	}
+	if stderr.String() != "" {
+		t.Errorf("stderr: %q, expected nothing", stderr.String())
+	}
+}
+
+func TestTime(t *testing.T) {
+	iop, stdout, stderr := runtime.TestIoProvider("")
+	defer iop.Close()
+	wg, err := compiler.Execute("time echo a | cat 2>&1\ntime -p ! false", iop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "a\n" {
+		t.Errorf("stdout: %q, expected: %q", stdout.String(), "a\n")
+	}
+	expected := regexp.MustCompile(`^\nreal\t0m0\.\d{3}s\nuser\t0m0\.\d{3}s\nsys\t0m0\.\d{3}s\nreal 0\.\d\d\nuser 0\.\d\d\nsys 0\.\d\d\n$`)
+	if !expected.MatchString(stderr.String()) {
+		t.Errorf("stderr: %q, expected to match: %q", stderr.String(), expected)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	defer func() {
+		for _, name := range []string{"errexit", "nounset", "pipefail", "xtrace"} {
+			_ = runtime.SetOption(name, false)
+		}
+		_ = runtime.UnsetVariable("PS4")
+	}()
+	iop, stdout, stderr := runtime.TestIoProvider("")
+	defer iop.Close()
+	wg, err := compiler.Execute(`set -euo pipefail
+set +o
+false | true || echo pipefail
+! true
+false && echo skipped
+set -x
+echo "a b" ${UNSET_VARIABLE:-}
+PS4='>> '
+echo it\'s
+set +x
+false
+echo not reached`, iop)
+	wg.Wait()
+	if err == nil {
+		t.Error("expected set -e to return the error of false")
+	}
+	expected := `set -o errexit
+set +o histexpand
+set +o monitor
+set +o noclobber
+set -o nounset
+set -o pipefail
+set +o xtrace
+pipefail
+a b 
+it's
+`
+	if stdout.String() != expected {
+		t.Errorf("stdout: %q, expected: %q", stdout.String(), expected)
+	}
+	expected = `+ echo 'a b' ''
++ PS4='>> '
+>> echo 'it'\''s'
+>> set +x
+`
+	if stderr.String() != expected {
+		t.Errorf("stderr: %q, expected: %q", stderr.String(), expected)
+	}
+
+	_ = runtime.SetOption("errexit", false)
+	wg, err = compiler.Execute("echo $UNSET_VARIABLE; echo not reached", iop)
+	wg.Wait()
+	if err == nil || strings.Contains(stdout.String(), "not reached") {
+		t.Errorf("expected an unbound variable error, got %v", err)
+	}
+	if !strings.HasSuffix(stderr.String(), "ohmygosh: UNSET_VARIABLE: unbound variable\n") || runtime.Status() != 1 {
+		t.Errorf("stderr: %q, status: %d, expected the unbound variable error with status 1", stderr.String(), runtime.Status())
+	}
+}
+
+func TestTrap(t *testing.T) {
+	iop, stdout, _ := runtime.TestIoProvider("")
+	defer iop.Close()
+	wg, err := compiler.Execute(`trap 'echo exit $?' EXIT
+trap "echo failed" ERR
+trap '' USR2
+trap -p EXIT ERR
+trap - usr2
+false
+echo status $?
+false || true
+trap -p`, iop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.RunExitTrap()
+	expected := `trap -- 'echo exit $?' EXIT
+trap -- 'echo failed' ERR
+failed
+status 1
+trap -- 'echo exit $?' EXIT
+trap -- 'echo failed' ERR
+exit 0
+`
+	if stdout.String() != expected {
+		t.Errorf("stdout: %q, expected: %q", stdout.String(), expected)
+	}
+	runtime.ResetTrap("ERR")
+}
+
+func TestSource(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.sh")
+	if err := os.WriteFile(lib, []byte("echo sourced $# \"$@\"\nSOURCED=yes\nshift\necho $1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	iop, stdout, _ := runtime.TestIoProvider("")
+	defer iop.Close()
+	wg, err := compiler.Execute(`set -- x y z
+source lib.sh a b
+echo $SOURCED $# $@
+. lib.sh | cat
+source`, iop)
+	wg.Wait()
+	if err == nil {
+		t.Error("expected source without a file to fail")
+	}
+	expected := "sourced 2 a b\nb\nyes 3 x y z\nsourced 3 x y z\ny\n"
+	if stdout.String() != expected {
+		t.Errorf("stdout: %q, expected: %q", stdout.String(), expected)
+	}
+	runtime.SetPositionalParameters(nil)
+	_ = runtime.UnsetVariable("SOURCED")
+
+	// a syntax error is reported with the file and status 2
+	bad := filepath.Join(dir, "bad.sh")
+	if err := os.WriteFile(bad, []byte("echo before\necho \"x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	iop, stdout, stderr := runtime.TestIoProvider("")
+	defer iop.Close()
+	wg, _ = compiler.Execute("source bad.sh; echo $?", iop)
+	wg.Wait()
+	if stdout.String() != "before\n2\n" {
+		t.Errorf("stdout: %q, expected: %q", stdout.String(), "before\n2\n")
+	}
+	if expected := "source: " + bad + ": unexpected end of input\n"; stderr.String() != expected {
+		t.Errorf("stderr: %q, expected: %q", stderr.String(), expected)
+	}
+}
+
+func TestDeclareAttributes(t *testing.T) {
+	defer func() {
+		for _, name := range []string{"sum", "upper", "lower", "fixed", "other", "ref", "target", "list"} {
+			runtime.ResetVariable(name)
+		}
+	}()
+	iop, stdout, stderr := runtime.TestIoProvider("")
+	defer iop.Close()
+	wg, err := compiler.Execute(`declare -i sum=4*5; sum+=2; echo $sum
+declare -u upper=hello; declare -l lower=HeLLo; echo $upper $lower
+declare -r fixed=1; readonly other=2
+fixed=3; echo $? $fixed
+unset other; echo $?
+declare -n ref=target; ref=value; echo $target
+declare -p sum fixed ref target
+declare -a list=(a "b c"); declare -p list`, iop)
+	wg.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+	expected := "22\nHELLO hello\n1 1\n1\nvalue\ndeclare -i sum=\"22\"\ndeclare -r fixed=\"1\"\ndeclare -n ref=\"target\"\ndeclare -- target=\"value\"\ndeclare -a list=([0]=\"a\" [1]=\"b c\")\n"
+	if stdout.String() != expected {
+		t.Errorf("stdout: %q, expected: %q", stdout.String(), expected)
+	}
+	expectedErr := "fixed: readonly variable\nunset: other: cannot unset: readonly variable\n"
+	if stderr.String() != expectedErr {
+		t.Errorf("stderr: %q, expected: %q", stderr.String(), expectedErr)
+	}
+}
+
+func TestReturnAndLocal(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "scoped.sh")
+	if err := os.WriteFile(lib, []byte("local name=inner\nlocal -i n=2+3\necho $name $n\nglobal=set\nreturn 7\necho unreachable\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	iop, stdout, stderr := runtime.TestIoProvider("")
+	defer iop.Close()
+	wg, err := compiler.Execute(`name=outer
+source `+runtime.Quote(lib)+`; echo $? $name $global ${n-unset}
+local name=x; echo $?
+return; echo $?`, iop)
+	wg.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+	expected := "inner 5\n7 outer set unset\n1\n1\n"
+	if stdout.String() != expected {
+		t.Errorf("stdout: %q, expected: %q", stdout.String(), expected)
+	}
+	expectedErr := "local: can only be used in a function or sourced script\nreturn: can only `return' from a function or sourced script\n"
+	if stderr.String() != expectedErr {
+		t.Errorf("stderr: %q, expected: %q", stderr.String(), expectedErr)
+	}
+	_ = runtime.UnsetVariable("name")
+	_ = runtime.UnsetVariable("global")
+}
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(file, []byte("#100\nold\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HISTFILE", file)
+	t.Setenv("HISTCONTROL", "ignoreboth")
+	if err := runtime.LoadHistory(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		iop, _, _ := runtime.TestIoProvider("")
+		defer iop.Close()
+		_, _ = compiler.Execute("history -c", iop)
+	}()
+	for _, line := range []string{"echo a\n", "echo a\n", " echo secret\n", "echo b &&\necho c\n", "history -w\n"} {
+		runtime.AddHistory(line)
+	}
+
+	iop, stdout, stderr := runtime.TestIoProvider("")
+	defer iop.Close()
+	wg, err := compiler.Execute("history -w\nhistory -d 2; history; history 1; history -d 9", iop)
+	wg.Wait()
+	if err == nil {
+		t.Error("expected history -d with a position out of range to fail")
+	}
+	expected := "    1  old\n    2  echo b &&\necho c\n    3  history -w\n    3  history -w\n"
+	if stdout.String() != expected {
+		t.Errorf("stdout: %q, expected: %q", stdout.String(), expected)
+	}
+	expectedErr := "history: 9: history position out of range\n"
+	if stderr.String() != expectedErr {
+		t.Errorf("stderr: %q, expected: %q", stderr.String(), expectedErr)
+	}
+
+	// another session appends to the file in the meantime, its entries are kept
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("#200\nother session\n")
+	_ = f.Close()
+	runtime.AddHistory("echo new\n")
+	if err := runtime.SaveHistory(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := regexp.MustCompile(`(?m)^#\d+\n`).ReplaceAllString(string(content), "")
+	expected = "old\necho a\necho b &&\necho c\nhistory -w\nother session\necho new\n"
+	if lines != expected {
+		t.Errorf("history file: %q, expected: %q", lines, expected)
+	}
+
+	stdout.Reset()
+	wg, _ = compiler.Execute("HISTTIMEFORMAT='[%s] '; history", iop)
+	wg.Wait()
+	if !strings.HasPrefix(stdout.String(), "    1  [100] old\n") {
+		t.Errorf("stdout: %q, expected the time of the first entry", stdout.String())
+	}
+	_ = runtime.UnsetVariable("HISTTIMEFORMAT")
+}
+
+func TestHistoryExpansion(t *testing.T) {
+	iop, _, _ := runtime.TestIoProvider("")
+	defer iop.Close()
+	clearHistory := func() {
+		wg, _ := compiler.Execute("history -c", iop)
+		wg.Wait()
+	}
+	clearHistory()
+	defer clearHistory()
+	for _, line := range []string{"echo hello world\n", "ls -l /tmp | wc -l\n", "git commit -m 'first commit'\n"} {
+		runtime.AddHistory(line)
+	}
+
+	cases := []struct {
+		in       string
+		expected string
+		err      string
+	}{
+		{in: "sudo !!\n", expected: "sudo git commit -m 'first commit'\n"},
+		{in: "echo !$", expected: "echo 'first commit'"},
+		{in: "echo !^ !*", expected: "echo commit commit -m 'first commit'"},
+		{in: "!1", expected: "echo hello world"},
+		{in: "!-2:0-2", expected: "ls -l /tmp"},
+		{in: "!ls:$", expected: "-l"},
+		{in: "!?hello?:2 !ec", expected: "world echo hello world"},
+		{in: "^first^second", expected: "git commit -m 'second commit'"},
+		{in: "echo '!!' \\!! $! ${!name} != ! x", expected: "echo '!!' \\!! $! ${!name} != ! x"},
+		{in: `echo "!!"`, expected: `echo "git commit -m 'first commit'"`},
+		{in: "!nothing", err: "!nothing: event not found"},
+		{in: "!!:9", err: "!!:9: bad word specifier"},
+		{in: "^none^x", err: "^none^x: substitution failed"},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			expanded, changed, err := runtime.ExpandHistory(c.in)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("error: %v, expected: %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expanded != c.expected {
+				t.Errorf("expanded: %q, expected: %q", expanded, c.expected)
+			}
+			if changed != (c.in != c.expected) {
+				t.Errorf("changed: %v", changed)
+			}
+		})
+	}
+}
+
+func TestDirectoryStack(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"real/sub", "projects/app"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "real"), filepath.Join(dir, "link")); err != nil {
+		t.Skip("symbolic links are not supported:", err)
+	}
+	t.Setenv("HOME", dir)
+	t.Setenv("PWD", wd)
+	t.Setenv("OLDPWD", "")
+	defer func() {
+		_ = os.Chdir(wd)
+		_ = runtime.UnsetVariable("CDPATH")
+	}()
+
+	iop, stdout, stderr := runtime.TestIoProvider("")
+	defer iop.Close()
+	wg, err := compiler.Execute(`cd ~/link/sub
+cd ..; pwd; pwd -P; echo $PWD
+cd -P .; pwd
+cd -
+CDPATH=`+runtime.Quote(filepath.Join(dir, "projects"))+`
+cd app
+pushd ~/real
+pushd ~
+dirs -v
+pushd +2
+popd
+dirs -l
+popd
+popd
+dirs -c
+cd `+runtime.Quote(wd), iop)
+	wg.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+	expected := strings.ReplaceAll(`DIR/link
+DIR/real
+DIR/link
+DIR/real
+DIR/link
+DIR/projects/app
+~/real ~/projects/app
+~ ~/real ~/projects/app
+ 0  ~
+ 1  ~/real
+ 2  ~/projects/app
+~/projects/app ~ ~/real
+~ ~/real
+DIR DIR/real
+~/real
+`, "DIR", dir)
+	if stdout.String() != expected {
+		t.Errorf("stdout: %q, expected: %q", stdout.String(), expected)
+	}
+	expectedErr := "popd: directory stack empty\n"
+	if stderr.String() != expectedErr {
+		t.Errorf("stderr: %q, expected: %q", stderr.String(), expectedErr)
+	}
+}
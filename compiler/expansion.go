@@ -0,0 +1,382 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tsukinoko-kun/ohmygosh/runtime"
+)
+
+// expandDollar expands the parameter, command substitution or arithmetic expansion that starts at text[i] (a '$').
+// It returns the expanded value and the index of the last character that belongs to the expansion.
+// If iop is nil, command substitutions and arithmetic expansions are skipped instead of evaluated.
+func expandDollar(text string, i int, iop *runtime.IoProvider) (string, int, error) {
+	texLen := len(text)
+	if i+1 >= texLen {
+		return "$", i, nil
+	}
+	switch c := text[i+1]; {
+	case c == '(' && i+2 < texLen && text[i+2] == '(':
+		// arithmetic expansion
+		depth := 0
+		for j := i + 3; j < texLen; j++ {
+			switch text[j] {
+			case '(':
+				depth++
+			case ')':
+				if depth > 0 {
+					depth--
+					continue
+				}
+				if j+1 < texLen && text[j+1] == ')' {
+					if iop == nil {
+						return "", j + 1, nil
+					}
+					expr, err := expandString(text[i+3:j], iop)
+					if err != nil {
+						return "", j + 1, err
+					}
+					v, err := runtime.Arithmetic(expr)
+					if err != nil {
+						return "", j + 1, ExpansionError{expr, err}
+					}
+					return strconv.FormatInt(v, 10), j + 1, nil
+				}
+				return "", j, errors.New("arithmetic expansion not closed")
+			}
+		}
+		return "", texLen - 1, ErrIncompleteInput
+
+	case c == '(':
+		// subshell
+		var subshell strings.Builder
+		braceCount := 1
+		j := i + 2
+		for ; j < texLen; j++ {
+			c := text[j]
+			if c == '(' {
+				braceCount++
+			} else if c == ')' {
+				braceCount--
+				if braceCount == 0 {
+					break
+				}
+			}
+			subshell.WriteByte(c)
+		}
+		if j >= texLen {
+			return "", texLen - 1, ErrIncompleteInput
+		}
+		if iop == nil {
+			return "", j, nil
+		}
+		iop, sb := runtime.SubshellIoProvider(iop)
+		defer iop.Close()
+		// the commands of the substitution don't change the state of the shell that runs them
+		restore := runtime.EnterSubshell()
+		wg, err := Execute(subshell.String(), iop)
+		if wg != nil {
+			wg.Wait()
+		}
+		restore()
+		if errors.Is(err, ErrIncompleteInput) || errors.As(err, new(CompilerError)) {
+			return "", j, fmt.Errorf("failed to execute subshell: %v", err)
+		}
+		if err != nil {
+			// the commands reported their errors themselves, the expansion fails with their status
+			return "", j, ExpansionError{"$(" + subshell.String() + ")", runtime.ExitStatus(runtime.Status())}
+		}
+		return strings.TrimSpace(sb.String()), j, nil
+
+	case c == '{':
+		words, end, err := expandBrace(text, i, iop)
+		return strings.Join(words, " "), end, err
+
+	case isNameChar(c) && (c < '0' || c > '9'):
+		// variable
+		j := i + 1
+		for j+1 < texLen && isNameChar(text[j+1]) {
+			j++
+		}
+		value, ok := runtime.Variable(text[i+1 : j+1])
+		if !ok && iop != nil && runtime.Option("nounset") {
+			return "", j, ExpansionError{text[i+1 : j+1], unsetParameterError("unbound variable")}
+		}
+		return value, j, nil
+
+	case c == '?' || c == '#' || c == '@' || c == '*' || c == '!' || (c >= '0' && c <= '9'):
+		values, _, _ := specialParameter(text[i+1 : i+2])
+		return strings.Join(values, " "), i + 1, nil
+
+	default:
+		return "$", i, nil
+	}
+}
+
+// expandDollarWords is like expandDollar, but $@, ${name[@]} and ${!name[@]} expand to one word per element.
+func expandDollarWords(text string, i int, iop *runtime.IoProvider) ([]string, int, error) {
+	if i+1 < len(text) && text[i+1] == '{' {
+		return expandBrace(text, i, iop)
+	}
+	if i+1 < len(text) && text[i+1] == '@' {
+		// $@ expands to one word per positional parameter
+		return runtime.PositionalParameters(), i + 1, nil
+	}
+	value, end, err := expandDollar(text, i, iop)
+	return []string{value}, end, err
+}
+
+// expandBrace expands the parameter expansion ${...} that starts at text[i].
+// Supported forms are ${name}, ${name[key]}, ${name[@]}, ${name[*]}, ${#name}, ${#name[@]}, ${!name[@]} and ${!name}.
+// name and name[key] can be followed by one of the operators -, =, + and ? (optionally prefixed with :) and a word.
+func expandBrace(text string, i int, iop *runtime.IoProvider) ([]string, int, error) {
+	end := matchingBrace(text, i+2)
+	if end == -1 {
+		return nil, len(text) - 1, ErrIncompleteInput
+	}
+	badSubstitution := fmt.Errorf("%s: bad substitution", text[i:end+1])
+	expr := text[i+2 : end]
+
+	// prefix is '#' for the length or '!' for the keys or indirection
+	var prefix byte
+	if len(expr) > 1 && (expr[0] == '#' || expr[0] == '!') {
+		prefix = expr[0]
+		expr = expr[1:]
+	}
+	j := 0
+	for j < len(expr) && isNameChar(expr[j]) {
+		j++
+	}
+	name, rest := expr[:j], expr[j:]
+	if name == "" && rest != "" && strings.IndexByte("#@*?", rest[0]) != -1 {
+		name, rest = rest[:1], rest[1:]
+	}
+	special := name != "" && (name[0] < 'a' || name[0] > 'z') && (name[0] < 'A' || name[0] > 'Z') && name[0] != '_'
+	if special {
+		if _, _, ok := specialParameter(name); !ok || strings.HasPrefix(rest, "[") || prefix == '!' {
+			return nil, end, badSubstitution
+		}
+	} else if !runtime.IsName(name) {
+		return nil, end, badSubstitution
+	}
+	key, hasKey := "", false
+	if strings.HasPrefix(rest, "[") {
+		k := strings.IndexByte(rest, ']')
+		if k == -1 {
+			return nil, end, badSubstitution
+		}
+		key, hasKey, rest = rest[1:k], true, rest[k+1:]
+	}
+	op, word := "", ""
+	if rest != "" {
+		if prefix != 0 {
+			return nil, end, badSubstitution
+		}
+		op = rest[:1]
+		if op == ":" && len(rest) > 1 {
+			op = rest[:2]
+		}
+		if strings.IndexByte("-=+?", op[len(op)-1]) == -1 {
+			return nil, end, badSubstitution
+		}
+		word = rest[len(op):]
+	}
+	if iop == nil {
+		// only the syntax is checked, ${name:=word} must not assign and ${name?word} must not fail
+		return []string{""}, end, nil
+	}
+
+	var values []string
+	set := true
+	all := hasKey && (key == "@" || key == "*")
+	switch {
+	case special:
+		values, set, _ = specialParameter(name)
+		if name == "@" || name == "*" {
+			all, key = true, name
+		}
+	case prefix == '!' && all:
+		values = runtime.VariableKeys(name)
+	case prefix == '!' && !hasKey:
+		// indirection
+		ref, _ := runtime.Variable(name)
+		if !runtime.IsName(ref) {
+			return nil, end, fmt.Errorf("%s: invalid indirect expansion", ref)
+		}
+		value, _ := runtime.Variable(ref)
+		return []string{value}, end, nil
+	case prefix == '!':
+		return nil, end, badSubstitution
+	case all:
+		values = runtime.VariableValues(name)
+		set = len(values) != 0
+	case hasKey:
+		var err error
+		if key, err = expandString(key, iop); err != nil {
+			return nil, end, err
+		}
+		value, ok, err := runtime.VariableElement(name, key)
+		if err != nil {
+			return nil, end, fmt.Errorf("%s: %v", text[i:end+1], err)
+		}
+		values, set = []string{value}, ok
+	default:
+		value, ok := runtime.Variable(name)
+		values, set = []string{value}, ok
+	}
+
+	if op != "" {
+		// with a colon, an empty value counts as unset
+		if strings.HasPrefix(op, ":") && set && strings.Join(values, "") == "" {
+			set = false
+		}
+		useWord := !set
+		if op[len(op)-1] == '+' {
+			useWord = set
+		}
+		if !useWord {
+			if op[len(op)-1] == '+' {
+				return []string{""}, end, nil
+			}
+			return values, end, nil
+		}
+		word, err := expandWord(word, iop)
+		if err != nil {
+			return nil, end, err
+		}
+		switch op[len(op)-1] {
+		case '=':
+			if all {
+				return nil, end, fmt.Errorf("%s: cannot assign in this way", text[i:end+1])
+			}
+			if err := runtime.Assign(runtime.Assignment{Name: name, Key: key, Subscript: hasKey, Value: word}); err != nil {
+				return nil, end, err
+			}
+		case '?':
+			if word == "" {
+				word = "parameter null or not set"
+			}
+			return nil, end, ExpansionError{name, unsetParameterError(word)}
+		}
+		return []string{word}, end, nil
+	}
+
+	if !set && !all && runtime.Option("nounset") {
+		if hasKey {
+			return nil, end, ExpansionError{name + "[" + key + "]", unsetParameterError("unbound variable")}
+		}
+		return nil, end, ExpansionError{name, unsetParameterError("unbound variable")}
+	}
+	if prefix == '#' {
+		if all {
+			return []string{strconv.Itoa(len(values))}, end, nil
+		}
+		return []string{strconv.Itoa(utf8.RuneCountInString(values[0]))}, end, nil
+	}
+	if all && key == "*" {
+		// ${name[*]} is a single word
+		return []string{strings.Join(values, " ")}, end, nil
+	}
+	return values, end, nil
+}
+
+// specialParameter returns the value of $0, $1, ..., $#, $@, $* or $? and reports whether name is one of them.
+// set is false for positional parameters that don't exist.
+func specialParameter(name string) (values []string, set bool, ok bool) {
+	params := runtime.PositionalParameters()
+	switch name {
+	case "#":
+		return []string{strconv.Itoa(len(params))}, true, true
+	case "@", "*":
+		return params, len(params) != 0, true
+	case "?":
+		return []string{strconv.Itoa(runtime.Status())}, true, true
+	case "!":
+		pid := runtime.LastJobPid()
+		return []string{pid}, pid != "", true
+	}
+	n, err := strconv.Atoi(name)
+	if err != nil || n < 0 {
+		return nil, false, false
+	}
+	if n == 0 {
+		return []string{runtime.ScriptName()}, true, true
+	}
+	if n > len(params) {
+		return []string{""}, false, true
+	}
+	return []string{params[n-1]}, true, true
+}
+
+// matchingBrace returns the index of the '}' that closes a ${ whose content starts at text[start], or -1.
+func matchingBrace(text string, start int) int {
+	depth := 0
+	for j := start; j < len(text); j++ {
+		switch {
+		case text[j] == '\\':
+			j++
+		case text[j] == '$' && j+1 < len(text) && text[j+1] == '{':
+			depth++
+			j++
+		case text[j] == '}':
+			if depth == 0 {
+				return j
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// expandWord expands the word of a parameter expansion operator like ${name:-word}.
+// A word in single quotes is taken literally, double quotes around it are removed.
+func expandWord(word string, iop *runtime.IoProvider) (string, error) {
+	if len(word) >= 2 && word[0] == '\'' && word[len(word)-1] == '\'' {
+		return word[1 : len(word)-1], nil
+	}
+	if len(word) >= 2 && word[0] == '"' && word[len(word)-1] == '"' {
+		word = word[1 : len(word)-1]
+	}
+	return expandString(word, iop)
+}
+
+// expandString performs parameter expansion, command substitution and arithmetic expansion on s.
+// Quotes have no special meaning, a backslash only escapes '$', '`', '\' and newline.
+// This is how the body of a here document with an unquoted delimiter gets expanded.
+func expandString(s string, iop *runtime.IoProvider) (string, error) {
+	sb := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i+1 < len(s) {
+				switch s[i+1] {
+				case '$', '`', '\\':
+					sb.WriteByte(s[i+1])
+					i++
+					continue
+				case '\n':
+					i++
+					continue
+				}
+			}
+			sb.WriteByte(c)
+		case '$':
+			value, end, err := expandDollar(s, i, iop)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(value)
+			i = end
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -1,8 +1,9 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"regexp"
 	"strings"
 
 	"github.com/tsukinoko-kun/ohmygosh/runtime"
@@ -21,6 +22,8 @@ const (
 	LexicalBackground
 	// |
 	LexicalPipeStdout
+	// |&
+	LexicalPipeStdoutAndStderr
 	// >
 	LexicalFileStdout
 	// >>
@@ -39,12 +42,36 @@ const (
 	LexicalStdoutToStderr
 	// <
 	LexicalRedirectStdin
+	// n> (Content is n)
+	LexicalFileOutput
+	// n>> (Content is n)
+	LexicalFileAppendOutput
+	// n< (Content is n)
+	LexicalFileInput
+	// n>&m or n>&- (Content is n, the next token is m or -)
+	LexicalDuplicateOutput
+	// n<&m or n<&- (Content is n, the next token is m or -)
+	LexicalDuplicateInput
+	// n>| (Content is n)
+	LexicalFileClobber
+	// n<> (Content is n)
+	LexicalFileReadWrite
 	// <<
 	LexicalHereDocument
 	// &&
 	LexicalAnd
 	// ||
 	LexicalOr
+	// name=( or name+=( (Content is name= or name+=, the elements follow as identifiers)
+	LexicalArrayStart
+	// ) at the end of an array assignment
+	LexicalArrayEnd
+	// [[ at the start of a conditional expression
+	LexicalConditionStart
+	// ]] at the end of a conditional expression
+	LexicalConditionEnd
+	// unquoted operator inside [[ ]] like &&, (, < or == (Content is the operator)
+	LexicalConditionOperator
 )
 
 type (
@@ -66,6 +93,17 @@ type (
 
 	lexicalQuotation uint8
 	LexicalTokenKind uint8
+
+	// lexicalHereDocument is a here document whose body has not been read yet.
+	lexicalHereDocument struct {
+		// token is the index of the LexicalHereDocument token that receives the body
+		token     int
+		delimiter string
+		// stripTabs is set for <<- and removes leading tabs from every line
+		stripTabs bool
+		// quoted is set if any part of the delimiter is quoted, this disables expansion of the body
+		quoted bool
+	}
 )
 
 func (t LexicalToken) String() string {
@@ -138,23 +176,149 @@ const (
 	lexicalQuotationDouble
 )
 
+// lexicalEnd tells lexicalAnalysis where to stop.
+type lexicalEnd uint8
+
+const (
+	// lexicalEndText analyzes the whole text
+	lexicalEndText lexicalEnd = iota
+	// lexicalEndStatement stops after the first complete statement (terminated by ;, & or a line break)
+	lexicalEndStatement
+	// lexicalEndPipeline stops after the first complete statement too, or after the && or || that chains
+	// the first pipeline to the next one. Then the last token is the operator.
+	lexicalEndPipeline
+)
+
 // LexicalAnalysis performs lexical analysis on the given text and returns a slice of tokens.
 // If an error gets returned it will be of type CompilerError.
 func LexicalAnalysis(text string, iop *runtime.IoProvider) ([]LexicalToken, error) {
+	tokens, _, err := lexicalAnalysis(text, 0, iop, lexicalEndText)
+	return tokens, err
+}
+
+// Incomplete reports whether text ends in the middle of a command and more input is needed to complete it.
+// This is the case for unclosed quotes, here documents and substitutions, for a trailing |, && or ||
+// and for a backslash at the end of the text.
+// No expansions are evaluated, so it is safe to call before the text gets executed.
+func Incomplete(text string) bool {
+	_, _, err := lexicalAnalysis(text, 0, nil, lexicalEndText)
+	return errors.Is(err, ErrIncompleteInput)
+}
+
+// lexicalAnalysis performs lexical analysis on text starting at text[start].
+// until tells where to stop, the rest of the text is left for the next call.
+// Expansions happen during lexical analysis, so statements have to be analyzed right before they get executed.
+// The same goes for the pipelines of an && or || chain, each one sees the effects of the ones before it.
+// It returns the tokens and the index of the last character that was analyzed.
+// If the text ends in the middle of a command, ErrIncompleteInput is returned instead of a CompilerError.
+// If an expansion fails, an ExpansionError is returned together with the end of the statement
+// and the tokens, the ones after the failed expansion are only good for their kinds.
+// If iop is nil, only the syntax is checked: command substitutions, arithmetic expansions
+// and the operators of parameter expansions like ${name:=word} are not evaluated.
+func lexicalAnalysis(text string, start int, iop *runtime.IoProvider, until lexicalEnd) ([]LexicalToken, int, error) {
 	texLen := len(text)
 	tokens := make([]LexicalToken, 0)
 	quotation := lexicalQuotationNone
 	tb := newLexicalTokenBuilder()
+	// here documents whose body starts after the current line
+	hereDocs := make([]lexicalHereDocument, 0)
+	// inArray is set between the parentheses of an array assignment
+	inArray := false
+	// inCondition is set between [[ and ]]
+	inCondition := false
+	// expansionErr is the first expansion that failed, the rest of the statement is only checked for its syntax
+	var expansionErr error
+	// chained is set if the analysis stopped after an && or ||
+	chained := false
+	failed := func(err error) bool {
+		if !errors.As(err, new(ExpansionError)) {
+			return false
+		}
+		if expansionErr == nil {
+			expansionErr = err
+		}
+		iop = nil
+		return true
+	}
+
+	// flush appends the word that ends at text[end] to the tokens.
+	// Unquoted words are checked for the keywords and operators of [[ ]].
+	flush := func(end int) {
+		if !tb.IsPresent() {
+			return
+		}
+		token := tb.Build()
+		unquoted := token.Index+len(token.Content) == end && text[token.Index:end] == token.Content
+		switch {
+		case !unquoted:
+		case !inCondition && token.Content == "[[" && atCommandStart(tokens):
+			token.Kind = LexicalConditionStart
+			inCondition = true
+		case inCondition && token.Content == "]]":
+			token.Kind = LexicalConditionEnd
+			inCondition = false
+		case inCondition && runtime.IsConditionOperator(token.Content):
+			token.Kind = LexicalConditionOperator
+		}
+		tokens = append(tokens, token)
+	}
+	// closesCondition reports whether the word that ends at text[end] is the ]] that ends the conditional expression
+	closesCondition := func(end int) bool {
+		return tb.IsPresent() && tb.Content.String() == "]]" && text[tb.Index:end] == "]]"
+	}
+	// operator appends an operator of [[ ]] that is made of special characters like && or (
+	operator := func(op string, i int) {
+		flush(i)
+		tokens = append(tokens, LexicalToken{Kind: LexicalConditionOperator, Content: op, Index: i})
+	}
+
+	i := start
+	for ; i < texLen; i++ {
+		if until != lexicalEndText && len(tokens) != 0 && len(hereDocs) == 0 {
+			if k := tokens[len(tokens)-1].Kind; k == LexicalStop || k == LexicalBackground {
+				i--
+				break
+			}
+		}
+		if _, ok := chainOperator(tokens); ok && until == lexicalEndPipeline && len(hereDocs) == 0 && !inArray && beginsCommand(text[i]) {
+			// the next pipeline starts here, line breaks and comments after the operator belong to this one
+			chained = true
+			i--
+			break
+		}
+		// the right side of == and != in [[ ]] is a pattern, the right side of =~ a regular expression,
+		// quoted parts of them match literally
+		pattern := byte(0)
+		if inCondition {
+			pattern = conditionPattern(tokens)
+		}
+		quoted := quotation != lexicalQuotationNone || text[i] == '\\'
+		written := tb.Content.Len()
 
-	for i := 0; i < texLen; i++ {
 		switch c := text[i]; c {
 
 		case '\n':
 			if quotation != lexicalQuotationNone {
-				return nil, newLexicalError(i, text, "quotation not closed at the end of the line")
+				tb.WriteChar(c, i)
+				break
+			}
+			flush(i)
+			if inArray || inCondition {
+				// the elements of an array and conditional expressions can span several lines
+				break
+			}
+			if len(hereDocs) != 0 {
+				// the bodies of all here documents on this line follow it
+				end, err := readHereDocuments(text, i+1, tokens, hereDocs, iop)
+				if err != nil && !failed(err) {
+					return nil, 0, err
+				}
+				hereDocs = hereDocs[:0]
+				i = end
 			}
-			if tb.IsPresent() {
-				tokens = append(tokens, tb.Build())
+			if continuesOnNextLine(tokens) {
+				// the command continues on the next line
+				break
 			}
 			tokens = append(tokens, LexicalToken{Kind: LexicalStop, Index: i})
 
@@ -166,53 +330,45 @@ func LexicalAnalysis(text string, iop *runtime.IoProvider) ([]LexicalToken, erro
 
 		case ' ', '\t', '\v', '\f', 20:
 			if quotation == lexicalQuotationNone {
-				if tb.IsPresent() {
-					tokens = append(tokens, tb.Build())
-				}
+				flush(i)
 			} else {
 				tb.WriteChar(c, i)
 			}
 
-		case '$':
-			if i+1 < texLen && text[i+1] == '(' {
-				// subshell
-				tb.SetIndexIfEmpty(i)
-				i += 2
-				var subshell strings.Builder
-				braceCount := 1
-				for i < texLen {
-					c := text[i]
-					if c == '(' {
-						braceCount++
-					} else if c == ')' {
-						braceCount--
-						if braceCount == 0 {
-							break
-						}
-					}
-					subshell.WriteByte(c)
-					i++
-				}
-				iop, sb := runtime.SubshellIoProvider(iop)
-				defer iop.Close()
-				if wg, err := Execute(subshell.String(), iop); func() bool { wg.Wait(); return err != nil }() {
-					return nil, newLexicalError(i, text, fmt.Sprintf("failed to execute subshell: %v", err))
+		case '#':
+			if quotation == lexicalQuotationNone && !tb.IsPresent() {
+				// a comment starts at the beginning of a word and lasts until the end of the line
+				// this also skips the shebang line of a script
+				if end := strings.IndexByte(text[i:], '\n'); end != -1 {
+					i += end - 1
+				} else {
+					i = texLen
 				}
-				tb.WriteString(strings.TrimSpace(sb.String()), i)
-			} else {
-				// variable
-				tb.SetIndexIfEmpty(i)
-				varName := strings.Builder{}
-				for i += 1; i < texLen; i++ {
-					c := text[i]
-					if c == ' ' || c == ';' || c == '\t' || c == '\v' || c == '\f' || c == '\n' || c == '\r' || c == '.' || c == ',' || c == '/' || c == '>' || c == '<' || c == '&' || c == '|' {
-						i--
-						break
-					}
-					varName.WriteByte(c)
+				break
+			}
+			tb.WriteChar(c, i)
+
+		case '$':
+			if quotation == lexicalQuotationSingle {
+				tb.WriteChar(c, i)
+				break
+			}
+			tb.SetIndexIfEmpty(i)
+			words, end, err := expandDollarWords(text, i, iop)
+			if errors.Is(err, ErrIncompleteInput) {
+				return nil, 0, err
+			}
+			if err != nil && !failed(err) {
+				return nil, 0, newLexicalError(i, text, err.Error())
+			}
+			for j, word := range words {
+				if j != 0 {
+					// ${name[@]} expands to one word per element
+					tokens = append(tokens, tb.Build())
 				}
-				tb.WriteString(os.Getenv(varName.String()), i)
+				tb.WriteString(word, i)
 			}
+			i = end
 
 		case '"':
 			tb.SetIndexIfEmpty(i)
@@ -224,7 +380,7 @@ func LexicalAnalysis(text string, iop *runtime.IoProvider) ([]LexicalToken, erro
 			case lexicalQuotationSingle:
 				tb.WriteChar(c, i)
 			default:
-				return nil, newLexicalError(i, text, fmt.Sprintf("invalid quotation state: %d", quotation))
+				return nil, 0, newLexicalError(i, text, fmt.Sprintf("invalid quotation state: %d", quotation))
 			}
 
 		case '\'':
@@ -237,14 +393,25 @@ func LexicalAnalysis(text string, iop *runtime.IoProvider) ([]LexicalToken, erro
 			case lexicalQuotationDouble:
 				tb.WriteChar(c, i)
 			default:
-				return nil, newLexicalError(i, text, fmt.Sprintf("invalid quotation state: %d", quotation))
+				return nil, 0, newLexicalError(i, text, fmt.Sprintf("invalid quotation state: %d", quotation))
 			}
 
 		case '\\':
 			if quotation == lexicalQuotationNone {
 				if i == texLen-1 {
-					return nil, newLexicalError(i, text, "iscape character at the end of the text")
+					return nil, 0, ErrIncompleteInput
+				}
+				if j := lineContinuation(text, i); j != -1 {
+					if j == texLen-1 {
+						// the command continues on the line that was not read yet
+						return nil, 0, ErrIncompleteInput
+					}
+					i = j
+					break
 				}
+				// the next character loses its special meaning
+				i++
+				tb.WriteChar(text[i], i-1)
 			} else {
 				if i+1 < texLen {
 					switch c = text[i+1]; c {
@@ -254,8 +421,13 @@ func LexicalAnalysis(text string, iop *runtime.IoProvider) ([]LexicalToken, erro
 						tb.WriteString("\b", i)
 					case '$':
 						tb.WriteString("$", i)
-					case 'n', '\n':
+					case 'n':
 						tb.WriteString("\n", i)
+					case '\n':
+						if quotation == lexicalQuotationSingle {
+							tb.WriteString("\n", i)
+						}
+						// inside double quotes, a backslash-newline continues the line
 					case 'r', '\r':
 						tb.WriteString("\r", i)
 					case 't':
@@ -287,25 +459,27 @@ func LexicalAnalysis(text string, iop *runtime.IoProvider) ([]LexicalToken, erro
 					}
 					i++
 				} else {
-					return nil, newLexicalError(i, text, "escape character at the end of the text")
+					// the quotation is not closed either
+					return nil, 0, ErrIncompleteInput
 				}
 			}
 
 		case ';':
 			if quotation == lexicalQuotationNone {
-				if tb.IsPresent() {
-					tokens = append(tokens, tb.Build())
-				}
+				flush(i)
 				tokens = append(tokens, LexicalToken{Kind: LexicalStop, Index: i})
 			} else {
 				tb.WriteChar(c, i)
 			}
 
 		case '&':
+			if quotation == lexicalQuotationNone && inCondition && !closesCondition(i) && i+1 < texLen && text[i+1] == '&' {
+				operator("&&", i)
+				i++
+				break
+			}
 			if quotation == lexicalQuotationNone {
-				if tb.IsPresent() {
-					tokens = append(tokens, tb.Build())
-				}
+				flush(i)
 				if i+1 < texLen && text[i+1] == '&' {
 					// &&
 					tokens = append(tokens, LexicalToken{Kind: LexicalAnd, Index: i})
@@ -333,14 +507,30 @@ func LexicalAnalysis(text string, iop *runtime.IoProvider) ([]LexicalToken, erro
 			}
 
 		case '|':
-			if quotation == lexicalQuotationNone {
-				if tb.IsPresent() {
-					tokens = append(tokens, tb.Build())
+			if quotation == lexicalQuotationNone && inCondition && !closesCondition(i) {
+				switch {
+				case pattern == 'r':
+					// alternation in a regular expression
+					tb.WriteChar(c, i)
+				case i+1 < texLen && text[i+1] == '|':
+					operator("||", i)
+					i++
+				default:
+					return nil, 0, newLexicalError(i, text, "unexpected | in conditional expression")
 				}
+				break
+			}
+			if quotation == lexicalQuotationNone {
+				flush(i)
 				if i+1 < texLen && text[i+1] == '|' {
 					tokens = append(tokens, LexicalToken{Kind: LexicalOr, Index: i})
 					i++
 					break
+				} else if i+1 < texLen && text[i+1] == '&' {
+					// |&
+					tokens = append(tokens, LexicalToken{Kind: LexicalPipeStdoutAndStderr, Index: i})
+					i++
+					break
 				} else {
 					// |
 					tokens = append(tokens, LexicalToken{Kind: LexicalPipeStdout, Index: i})
@@ -351,125 +541,91 @@ func LexicalAnalysis(text string, iop *runtime.IoProvider) ([]LexicalToken, erro
 			}
 
 		case '>':
+			if quotation == lexicalQuotationNone && inCondition && !closesCondition(i) {
+				operator(">", i)
+				break
+			}
 			if quotation == lexicalQuotationNone {
-				if tb.IsPresent() {
-					tokens = append(tokens, tb.Build())
-				}
-				if i+1 < texLen && text[i+1] == '>' {
-					// >>
-					tokens = append(tokens, LexicalToken{Kind: LexicalFileAppendStdout, Index: i})
-					i++
-					break
-				} else {
-					// >
-					tokens = append(tokens, LexicalToken{Kind: LexicalFileStdout, Index: i})
-					break
-				}
+				flush(i)
+				redirection, end := lexRedirection(text, i, "", i)
+				tokens = append(tokens, redirection...)
+				i = end
+				break
 			}
 			tb.WriteChar(c, i)
 
 		case '<':
+			if quotation == lexicalQuotationNone && inCondition && !closesCondition(i) {
+				operator("<", i)
+				break
+			}
 			if quotation == lexicalQuotationNone {
-				if tb.IsPresent() {
-					tokens = append(tokens, tb.Build())
-				}
+				flush(i)
 				if i+1 < texLen && text[i+1] == '<' {
 					// <<
-					tb.SetIndexIfEmpty(i)
-					tb.SetKind(LexicalHereDocument)
-					// get the here document name
-					docNameB := strings.Builder{}
-					for i += 2; i < texLen; i++ {
-						c := text[i]
-						if c == '\n' {
-							break
-						}
-						docNameB.WriteByte(c)
+					hereDoc := lexicalHereDocument{token: len(tokens)}
+					tokens = append(tokens, LexicalToken{Kind: LexicalHereDocument, Index: i})
+					i += 2
+					if i < texLen && text[i] == '-' {
+						// <<-
+						hereDoc.stripTabs = true
+						i++
 					}
-					docName := strings.TrimSpace(docNameB.String())
-					// get the here document content
-					err := func() error {
-						var lineContentBuilder strings.Builder
-						for i += 1; i < texLen; i++ {
-							switch c := text[i]; c {
-							case '\n':
-								lineContent := lineContentBuilder.String()
-								if strings.TrimSpace(lineContent) == docName {
-									i--
-									return nil
-								} else {
-									lineContentBuilder.Reset()
-									tb.WriteString(lineContent, i)
-									tb.WriteRune('\n', i)
-								}
-							case '\r':
-								continue
-							default:
-								lineContentBuilder.WriteByte(c)
-							}
-						}
-						lineContent := lineContentBuilder.String()
-						if strings.TrimSpace(lineContent) == docName {
-							return nil
-						}
-						return newLexicalError(tb.Index, text, "here document not closed")
-					}()
-
+					end, err := hereDoc.readDelimiter(text, i)
 					if err != nil {
-						return nil, err
+						return nil, 0, err
 					}
-
-					t := tb.Build()
-					t.Content = strings.Trim(t.Content, "\n") + "\n"
-					tokens = append(tokens, t)
+					hereDocs = append(hereDocs, hereDoc)
+					i = end
 					break
 				} else {
-					// <
-					tokens = append(tokens, LexicalToken{Kind: LexicalRedirectStdin, Index: i})
+					redirection, end := lexRedirection(text, i, "", i)
+					tokens = append(tokens, redirection...)
+					i = end
 					break
 				}
 			}
 			tb.WriteChar(c, i)
 
-		case '1':
-			if tb.IsPresent() {
-				tb.WriteChar(c, i)
+		case '(', ')':
+			if quotation == lexicalQuotationNone && inCondition && !closesCondition(i) {
+				if pattern == 'r' && (c == '(' || tb.IsPresent()) {
+					// a group in a regular expression
+					tb.WriteChar(c, i)
+				} else {
+					operator(string(c), i)
+				}
 				break
 			}
-			if i+1 < texLen && text[i+1] == '>' {
-				if i+2 < texLen && text[i+2] == '&' {
-					if i+3 < texLen && text[i+3] == '2' {
-						// 1>&2
-						tokens = append(tokens, LexicalToken{Kind: LexicalStdoutToStderr, Index: i})
-						i += 3
-						break
-					}
-				}
+			if c == '(' && quotation == lexicalQuotationNone && !inArray && tb.IsPresent() && isArrayAssignment(text[tb.Index:i], tb.Content.String()) {
+				tokens = append(tokens, LexicalToken{Kind: LexicalArrayStart, Content: tb.Content.String(), Index: tb.Index})
+				tb.Reset()
+				inArray = true
+				break
 			}
-			tb.WriteChar(c, i)
-
-		case '2':
-			if tb.IsPresent() {
+			if c == '(' {
 				tb.WriteChar(c, i)
 				break
 			}
-			if i+1 < texLen && text[i+1] == '>' {
-				if i+2 < texLen && text[i+2] == '&' {
-					if i+3 < texLen && text[i+3] == '1' {
-						// 2>&1
-						tokens = append(tokens, LexicalToken{Kind: LexicalStderrToStdout, Index: i})
-						i += 3
-						break
-					}
-				} else if i+2 < texLen && text[i+2] == '>' {
-					// 2>>
-					tokens = append(tokens, LexicalToken{Kind: LexicalFileAppendStderr, Index: i})
-					i += 2
-					break
-				} else {
-					// 2>
-					tokens = append(tokens, LexicalToken{Kind: LexicalFileStderr, Index: i})
-					i++
+			if quotation == lexicalQuotationNone && inArray {
+				flush(i)
+				tokens = append(tokens, LexicalToken{Kind: LexicalArrayEnd, Index: i})
+				inArray = false
+				break
+			}
+			tb.WriteChar(c, i)
+
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			if quotation == lexicalQuotationNone && !tb.IsPresent() && !inCondition {
+				// a word of digits directly followed by a redirection operator is a file descriptor number
+				j := i + 1
+				for j < texLen && text[j] >= '0' && text[j] <= '9' {
+					j++
+				}
+				if j < texLen && (text[j] == '>' || (text[j] == '<' && (j+1 == texLen || text[j+1] != '<'))) {
+					redirection, end := lexRedirection(text, j, text[i:j], i)
+					tokens = append(tokens, redirection...)
+					i = end
 					break
 				}
 			}
@@ -478,16 +634,318 @@ func LexicalAnalysis(text string, iop *runtime.IoProvider) ([]LexicalToken, erro
 		default:
 			tb.WriteChar(c, i)
 		}
+
+		if pattern != 0 && quoted && tb.Content.Len() > written {
+			// quoted characters of a pattern or regular expression match literally
+			content := tb.Content.String()
+			tb.Content.Reset()
+			tb.Content.WriteString(content[:written])
+			if pattern == 'r' {
+				tb.Content.WriteString(regexp.QuoteMeta(content[written:]))
+			} else {
+				tb.Content.WriteString(runtime.QuotePattern(content[written:]))
+			}
+		}
 	}
 	if quotation != lexicalQuotationNone {
-		return nil, newLexicalError(len(text)-1, text, "quotation not closed")
+		return nil, 0, ErrIncompleteInput
+	}
+	flush(i)
+	if inArray || inCondition {
+		return nil, 0, ErrIncompleteInput
+	}
+	if len(hereDocs) != 0 || (!chained && continuesOnNextLine(tokens)) {
+		return nil, 0, ErrIncompleteInput
 	}
-	if tb.IsPresent() {
-		tokens = append(tokens, tb.Build())
+	if expansionErr != nil {
+		return tokens, min(i, texLen-1), expansionErr
 	}
 	// trim trailing LexicalStop tokens
 	for len(tokens) > 0 && tokens[len(tokens)-1].Kind == LexicalStop {
 		tokens = tokens[:len(tokens)-1]
 	}
-	return tokens, nil
+	return tokens, min(i, texLen-1), nil
+}
+
+// isArrayAssignment reports whether the word in front of a '(' starts an array assignment (name=( or name+=().
+// raw is the text of the word and word its content, they only match if no part of it was quoted or expanded.
+func isArrayAssignment(raw string, word string) bool {
+	if raw != word {
+		return false
+	}
+	name, ok := strings.CutSuffix(word, "=")
+	if !ok {
+		return false
+	}
+	name = strings.TrimSuffix(name, "+")
+	return runtime.IsName(name)
+}
+
+// atCommandStart reports whether the next word is the first word of a command, where keywords like [[ are recognized.
+func atCommandStart(tokens []LexicalToken) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	switch last.Kind {
+	case LexicalStop, LexicalBackground, LexicalPipeStdout, LexicalPipeStdoutAndStderr, LexicalAnd, LexicalOr:
+		return true
+	case LexicalIdentifier:
+		// after the keywords ! and time [-p]
+		return last.Content == "!" || last.Content == "time" || (last.Content == "-p" && len(tokens) > 1 && tokens[len(tokens)-2].Content == "time")
+	default:
+		return false
+	}
+}
+
+// conditionPattern returns 'g' if the next word of a conditional expression is a pattern (after == or !=),
+// 'r' if it is a regular expression (after =~) and 0 otherwise.
+func conditionPattern(tokens []LexicalToken) byte {
+	if len(tokens) == 0 || tokens[len(tokens)-1].Kind != LexicalConditionOperator {
+		return 0
+	}
+	switch tokens[len(tokens)-1].Content {
+	case "==", "=", "!=":
+		return 'g'
+	case "=~":
+		return 'r'
+	default:
+		return 0
+	}
+}
+
+// beginsCommand reports whether c can be the first character of a command, whitespace and comments can't.
+func beginsCommand(c byte) bool {
+	switch c {
+	case ' ', '\t', '\v', '\f', 20, '\r', '\n', '#':
+		return false
+	default:
+		return true
+	}
+}
+
+// lineContinuation returns the index of the line break if the backslash at text[i] is directly followed by one, otherwise -1.
+func lineContinuation(text string, i int) int {
+	switch {
+	case i+1 < len(text) && text[i+1] == '\n':
+		return i + 1
+	case i+2 < len(text) && text[i+1] == '\r' && text[i+2] == '\n':
+		return i + 2
+	default:
+		return -1
+	}
+}
+
+// continuesOnNextLine reports whether the last token is an operator that needs a command after it (|, |&, && or ||).
+// A line break after such an operator does not end the statement.
+func continuesOnNextLine(tokens []LexicalToken) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	switch tokens[len(tokens)-1].Kind {
+	case LexicalPipeStdout, LexicalPipeStdoutAndStderr, LexicalAnd, LexicalOr:
+		return true
+	default:
+		return false
+	}
+}
+
+// lexRedirection lexes the redirection operator at text[i], which is '>' or '<'.
+// fd is the file descriptor number in front of the operator or empty if there is none, index is the start of the redirection.
+// It returns the tokens and the index of the last character that belongs to the redirection.
+func lexRedirection(text string, i int, fd string, index int) ([]LexicalToken, int) {
+	texLen := len(text)
+	if text[i] == '>' {
+		if i+1 < texLen && text[i+1] == '|' {
+			// n>|
+			if fd == "" {
+				fd = "1"
+			}
+			return []LexicalToken{{Kind: LexicalFileClobber, Content: fd, Index: index}}, i + 1
+		}
+		if i+1 < texLen && text[i+1] == '>' {
+			switch fd {
+			case "", "1":
+				// >>
+				return []LexicalToken{{Kind: LexicalFileAppendStdout, Index: index}}, i + 1
+			case "2":
+				// 2>>
+				return []LexicalToken{{Kind: LexicalFileAppendStderr, Index: index}}, i + 1
+			default:
+				// n>>
+				return []LexicalToken{{Kind: LexicalFileAppendOutput, Content: fd, Index: index}}, i + 1
+			}
+		}
+		if i+1 < texLen && text[i+1] == '&' {
+			target, end := lexDuplicationTarget(text, i+2)
+			switch {
+			case fd == "2" && target == "1":
+				// 2>&1
+				return []LexicalToken{{Kind: LexicalStderrToStdout, Index: index}}, end
+			case fd == "1" && target == "2":
+				// 1>&2
+				return []LexicalToken{{Kind: LexicalStdoutToStderr, Index: index}}, end
+			case fd == "" && target == "":
+				// >&file is the same as &>file
+				return []LexicalToken{{Kind: LexicalFileStdoutAndStderr, Index: index}}, i + 1
+			}
+			if fd == "" {
+				fd = "1"
+			}
+			if target == "" {
+				// the target is the next word, the parser rejects it if it's not a file descriptor
+				return []LexicalToken{{Kind: LexicalDuplicateOutput, Content: fd, Index: index}}, i + 1
+			}
+			return []LexicalToken{
+				{Kind: LexicalDuplicateOutput, Content: fd, Index: index},
+				{Kind: LexicalIdentifier, Content: target, Index: i + 2},
+			}, end
+		}
+		switch fd {
+		case "", "1":
+			// >
+			return []LexicalToken{{Kind: LexicalFileStdout, Index: index}}, i
+		case "2":
+			// 2>
+			return []LexicalToken{{Kind: LexicalFileStderr, Index: index}}, i
+		default:
+			// n>
+			return []LexicalToken{{Kind: LexicalFileOutput, Content: fd, Index: index}}, i
+		}
+	}
+
+	if i+1 < texLen && text[i+1] == '>' {
+		// n<>
+		if fd == "" {
+			fd = "0"
+		}
+		return []LexicalToken{{Kind: LexicalFileReadWrite, Content: fd, Index: index}}, i + 1
+	}
+	if i+1 < texLen && text[i+1] == '&' {
+		if fd == "" {
+			fd = "0"
+		}
+		target, end := lexDuplicationTarget(text, i+2)
+		if target == "" {
+			return []LexicalToken{{Kind: LexicalDuplicateInput, Content: fd, Index: index}}, i + 1
+		}
+		return []LexicalToken{
+			{Kind: LexicalDuplicateInput, Content: fd, Index: index},
+			{Kind: LexicalIdentifier, Content: target, Index: i + 2},
+		}, end
+	}
+	switch fd {
+	case "", "0":
+		// <
+		return []LexicalToken{{Kind: LexicalRedirectStdin, Index: index}}, i
+	default:
+		// n<
+		return []LexicalToken{{Kind: LexicalFileInput, Content: fd, Index: index}}, i
+	}
+}
+
+// lexDuplicationTarget reads the file descriptor number or '-' after >& or <& starting at text[i].
+// It returns an empty target if there is none, otherwise the index of its last character.
+func lexDuplicationTarget(text string, i int) (string, int) {
+	if i < len(text) && text[i] == '-' {
+		return "-", i
+	}
+	j := i
+	for j < len(text) && text[j] >= '0' && text[j] <= '9' {
+		j++
+	}
+	return text[i:j], j - 1
+}
+
+// readDelimiter reads the delimiter word of a here document starting at text[i].
+// It returns the index of the last character of the delimiter.
+func (h *lexicalHereDocument) readDelimiter(text string, i int) (int, error) {
+	texLen := len(text)
+	for i < texLen && (text[i] == ' ' || text[i] == '\t') {
+		i++
+	}
+	start := i
+	quotation := lexicalQuotationNone
+	delimiter := strings.Builder{}
+loop:
+	for ; i < texLen; i++ {
+		c := text[i]
+		switch {
+		case quotation == lexicalQuotationSingle && c == '\'':
+			quotation = lexicalQuotationNone
+		case quotation == lexicalQuotationDouble && c == '"':
+			quotation = lexicalQuotationNone
+		case quotation != lexicalQuotationNone:
+			delimiter.WriteByte(c)
+		case c == '\'':
+			h.quoted = true
+			quotation = lexicalQuotationSingle
+		case c == '"':
+			h.quoted = true
+			quotation = lexicalQuotationDouble
+		case c == '\\' && i+1 < texLen:
+			h.quoted = true
+			i++
+			delimiter.WriteByte(text[i])
+		case strings.IndexByte(" \t\r\n;&|<>()", c) != -1:
+			break loop
+		default:
+			delimiter.WriteByte(c)
+		}
+	}
+	if quotation != lexicalQuotationNone {
+		return 0, newLexicalError(start, text, "quotation not closed in here document delimiter")
+	}
+	if i == start {
+		return 0, newLexicalError(start, text, "here document delimiter missing")
+	}
+	h.delimiter = delimiter.String()
+	return i - 1, nil
+}
+
+// readHereDocuments reads the bodies of the given here documents, which start at text[start], into their tokens.
+// It returns the index of the line break after the last delimiter, also together with an ExpansionError.
+func readHereDocuments(text string, start int, tokens []LexicalToken, hereDocs []lexicalHereDocument, iop *runtime.IoProvider) (int, error) {
+	texLen := len(text)
+	end := start
+	var expansionErr error
+	for _, hereDoc := range hereDocs {
+		token := &tokens[hereDoc.token]
+		body := strings.Builder{}
+		closed := false
+		for end < texLen {
+			lineEnd := strings.IndexByte(text[end:], '\n')
+			if lineEnd == -1 {
+				lineEnd = texLen
+			} else {
+				lineEnd += end
+			}
+			line := strings.ReplaceAll(text[end:lineEnd], "\r", "")
+			if hereDoc.stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			end = lineEnd + 1
+			if strings.TrimSpace(line) == hereDoc.delimiter {
+				closed = true
+				break
+			}
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+		if !closed {
+			return 0, ErrIncompleteInput
+		}
+		content := body.String()
+		if !hereDoc.quoted {
+			var err error
+			if content, err = expandString(content, iop); errors.As(err, new(ExpansionError)) {
+				// the end of the here documents is still needed to go on after the statement
+				expansionErr, iop = err, nil
+			} else if err != nil {
+				return 0, newLexicalError(token.Index, text, fmt.Sprintf("failed to expand here document: %v", err))
+			}
+		}
+		token.Content = content
+	}
+	return end - 1, expansionErr
 }
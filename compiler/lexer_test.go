@@ -56,6 +56,14 @@ func TestLexicalAnalysis(t *testing.T) {
 				{Kind: compiler.LexicalIdentifier, Content: "command2", Index: 11},
 			},
 		},
+		{
+			"command1 |& command2",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "command1", Index: 0},
+				{Kind: compiler.LexicalPipeStdoutAndStderr, Index: 9},
+				{Kind: compiler.LexicalIdentifier, Content: "command2", Index: 12},
+			},
+		},
 		{
 			"command1 & command2",
 			[]compiler.LexicalToken{
@@ -123,6 +131,85 @@ func TestLexicalAnalysis(t *testing.T) {
 				{Kind: compiler.LexicalIdentifier, Content: "command3", Index: 27},
 			},
 		},
+		{
+			"command1 3> file 4>>file 1>out 0<in",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "command1", Index: 0},
+				{Kind: compiler.LexicalFileOutput, Content: "3", Index: 9},
+				{Kind: compiler.LexicalIdentifier, Content: "file", Index: 12},
+				{Kind: compiler.LexicalFileAppendOutput, Content: "4", Index: 17},
+				{Kind: compiler.LexicalIdentifier, Content: "file", Index: 20},
+				{Kind: compiler.LexicalFileStdout, Index: 25},
+				{Kind: compiler.LexicalIdentifier, Content: "out", Index: 27},
+				{Kind: compiler.LexicalRedirectStdin, Index: 31},
+				{Kind: compiler.LexicalIdentifier, Content: "in", Index: 33},
+			},
+		},
+		{
+			"command1 >&2 3>&1 4<&0 2>&- 2>&1>file",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "command1", Index: 0},
+				{Kind: compiler.LexicalDuplicateOutput, Content: "1", Index: 9},
+				{Kind: compiler.LexicalIdentifier, Content: "2", Index: 11},
+				{Kind: compiler.LexicalDuplicateOutput, Content: "3", Index: 13},
+				{Kind: compiler.LexicalIdentifier, Content: "1", Index: 16},
+				{Kind: compiler.LexicalDuplicateInput, Content: "4", Index: 18},
+				{Kind: compiler.LexicalIdentifier, Content: "0", Index: 21},
+				{Kind: compiler.LexicalDuplicateOutput, Content: "2", Index: 23},
+				{Kind: compiler.LexicalIdentifier, Content: "-", Index: 26},
+				{Kind: compiler.LexicalStderrToStdout, Index: 28},
+				{Kind: compiler.LexicalFileStdout, Index: 32},
+				{Kind: compiler.LexicalIdentifier, Content: "file", Index: 33},
+			},
+		},
+		{
+			"echo 12 a2>b \"2>&1\"",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "echo", Index: 0},
+				{Kind: compiler.LexicalIdentifier, Content: "12", Index: 5},
+				{Kind: compiler.LexicalIdentifier, Content: "a2", Index: 8},
+				{Kind: compiler.LexicalFileStdout, Index: 10},
+				{Kind: compiler.LexicalIdentifier, Content: "b", Index: 11},
+				{Kind: compiler.LexicalIdentifier, Content: "2>&1", Index: 13},
+			},
+		},
+		{
+			"command1 >| file 2>|file <> file 3<>file",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "command1", Index: 0},
+				{Kind: compiler.LexicalFileClobber, Content: "1", Index: 9},
+				{Kind: compiler.LexicalIdentifier, Content: "file", Index: 12},
+				{Kind: compiler.LexicalFileClobber, Content: "2", Index: 17},
+				{Kind: compiler.LexicalIdentifier, Content: "file", Index: 20},
+				{Kind: compiler.LexicalFileReadWrite, Content: "0", Index: 25},
+				{Kind: compiler.LexicalIdentifier, Content: "file", Index: 28},
+				{Kind: compiler.LexicalFileReadWrite, Content: "3", Index: 33},
+				{Kind: compiler.LexicalIdentifier, Content: "file", Index: 36},
+			},
+		},
+		{
+			"#!/usr/bin/env ohmygosh\necho a#b '#c' \"#d\" # comment ; echo no\necho #\n#\necho x;#y",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalStop, Index: 23},
+				{Kind: compiler.LexicalIdentifier, Content: "echo", Index: 24},
+				{Kind: compiler.LexicalIdentifier, Content: "a#b", Index: 29},
+				{Kind: compiler.LexicalIdentifier, Content: "#c", Index: 33},
+				{Kind: compiler.LexicalIdentifier, Content: "#d", Index: 38},
+				{Kind: compiler.LexicalStop, Index: 62},
+				{Kind: compiler.LexicalIdentifier, Content: "echo", Index: 63},
+				{Kind: compiler.LexicalStop, Index: 69},
+				{Kind: compiler.LexicalStop, Index: 71},
+				{Kind: compiler.LexicalIdentifier, Content: "echo", Index: 72},
+				{Kind: compiler.LexicalIdentifier, Content: "x", Index: 77},
+			},
+		},
+		{
+			"cat <<x # comment\n# not a comment\nx",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "cat", Index: 0},
+				{Kind: compiler.LexicalHereDocument, Content: "# not a comment\n", Index: 4},
+			},
+		},
 		{
 			"cat<<x\nfoo\nbar\nx",
 			[]compiler.LexicalToken{
@@ -159,6 +246,69 @@ func TestLexicalAnalysis(t *testing.T) {
 				{Kind: compiler.LexicalIdentifier, Content: "/", Index: 26},
 			},
 		},
+		{
+			"cat <<x\n\nfoo\n\nx",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "cat", Index: 0},
+				{Kind: compiler.LexicalHereDocument, Content: "\nfoo\n\n", Index: 4},
+			},
+		},
+		{
+			"cat <<x\nx",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "cat", Index: 0},
+				{Kind: compiler.LexicalHereDocument, Content: "", Index: 4},
+			},
+		},
+		{
+			"cat <<-x\n\tfoo\n\t\tbar\n\tx",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "cat", Index: 0},
+				{Kind: compiler.LexicalHereDocument, Content: "foo\nbar\n", Index: 4},
+			},
+		},
+		{
+			"cat <<x\n$TEST $((1 + 2 * 3))\n\\$TEST\nx",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "cat", Index: 0},
+				{Kind: compiler.LexicalHereDocument, Content: "test_value 7\n$TEST\n", Index: 4},
+			},
+		},
+		{
+			"cat <<'x'\n$TEST $((1 + 2 * 3))\nx",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "cat", Index: 0},
+				{Kind: compiler.LexicalHereDocument, Content: "$TEST $((1 + 2 * 3))\n", Index: 4},
+			},
+		},
+		{
+			"cat <<\"x\"\n$TEST\nx",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "cat", Index: 0},
+				{Kind: compiler.LexicalHereDocument, Content: "$TEST\n", Index: 4},
+			},
+		},
+		{
+			"cat <<a <<b | cat\nfoo\na\nbar\nb\necho",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "cat", Index: 0},
+				{Kind: compiler.LexicalHereDocument, Content: "foo\n", Index: 4},
+				{Kind: compiler.LexicalHereDocument, Content: "bar\n", Index: 8},
+				{Kind: compiler.LexicalPipeStdout, Index: 12},
+				{Kind: compiler.LexicalIdentifier, Content: "cat", Index: 14},
+				{Kind: compiler.LexicalStop, Index: 29},
+				{Kind: compiler.LexicalIdentifier, Content: "echo", Index: 30},
+			},
+		},
+		{
+			"echo '$TEST' \"${TEST}\" $((2 ** 4))",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "echo", Index: 0},
+				{Kind: compiler.LexicalIdentifier, Content: "$TEST", Index: 5},
+				{Kind: compiler.LexicalIdentifier, Content: "test_value", Index: 13},
+				{Kind: compiler.LexicalIdentifier, Content: "16", Index: 23},
+			},
+		},
 		{
 			"echo \"Hello World\"",
 			[]compiler.LexicalToken{
@@ -239,6 +389,55 @@ func TestLexicalAnalysis(t *testing.T) {
 				{Kind: compiler.LexicalBackground, Index: 44},
 			},
 		},
+		{
+			"echo a\\\nb \"c\\\nd\" 'e\nf' |\n\ncat &&\necho",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "echo", Index: 0},
+				{Kind: compiler.LexicalIdentifier, Content: "ab", Index: 5},
+				{Kind: compiler.LexicalIdentifier, Content: "cd", Index: 10},
+				{Kind: compiler.LexicalIdentifier, Content: "e\nf", Index: 17},
+				{Kind: compiler.LexicalPipeStdout, Index: 23},
+				{Kind: compiler.LexicalIdentifier, Content: "cat", Index: 26},
+				{Kind: compiler.LexicalAnd, Index: 30},
+				{Kind: compiler.LexicalIdentifier, Content: "echo", Index: 33},
+			},
+		},
+		{
+			"arr=(a 'b c'\n d) x+=(1) \"y=(\"",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalArrayStart, Content: "arr=", Index: 0},
+				{Kind: compiler.LexicalIdentifier, Content: "a", Index: 5},
+				{Kind: compiler.LexicalIdentifier, Content: "b c", Index: 7},
+				{Kind: compiler.LexicalIdentifier, Content: "d", Index: 14},
+				{Kind: compiler.LexicalArrayEnd, Index: 15},
+				{Kind: compiler.LexicalArrayStart, Content: "x+=", Index: 17},
+				{Kind: compiler.LexicalIdentifier, Content: "1", Index: 21},
+				{Kind: compiler.LexicalArrayEnd, Index: 22},
+				{Kind: compiler.LexicalIdentifier, Content: "y=(", Index: 24},
+			},
+		},
+		{
+			"[[ $TEST == \"t*\"* && (a<b) ]]>f; echo [[ x",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalConditionStart, Content: "[[", Index: 0},
+				{Kind: compiler.LexicalIdentifier, Content: "test_value", Index: 3},
+				{Kind: compiler.LexicalConditionOperator, Content: "==", Index: 9},
+				{Kind: compiler.LexicalIdentifier, Content: "t\\**", Index: 12},
+				{Kind: compiler.LexicalConditionOperator, Content: "&&", Index: 18},
+				{Kind: compiler.LexicalConditionOperator, Content: "(", Index: 21},
+				{Kind: compiler.LexicalIdentifier, Content: "a", Index: 22},
+				{Kind: compiler.LexicalConditionOperator, Content: "<", Index: 23},
+				{Kind: compiler.LexicalIdentifier, Content: "b", Index: 24},
+				{Kind: compiler.LexicalConditionOperator, Content: ")", Index: 25},
+				{Kind: compiler.LexicalConditionEnd, Content: "]]", Index: 27},
+				{Kind: compiler.LexicalFileStdout, Index: 29},
+				{Kind: compiler.LexicalIdentifier, Content: "f", Index: 30},
+				{Kind: compiler.LexicalStop, Index: 31},
+				{Kind: compiler.LexicalIdentifier, Content: "echo", Index: 33},
+				{Kind: compiler.LexicalIdentifier, Content: "[[", Index: 38},
+				{Kind: compiler.LexicalIdentifier, Content: "x", Index: 41},
+			},
+		},
 	}
 
 	for i, c := range cases {
@@ -280,6 +479,55 @@ func TestLexicalAnalysis(t *testing.T) {
 	}
 }
 
+func TestIncomplete(t *testing.T) {
+	cases := []struct {
+		in   string
+		want bool
+	}{
+		{"echo a", false},
+		{"echo a\n", false},
+		{"echo 'a", true},
+		{"echo \"a\nb", true},
+		{"echo \"a\nb\"", false},
+		{"echo a \\", true},
+		{"echo a \\\nb", false},
+		{"echo a \\\n", true},
+		{"echo a \\\r\n", true},
+		{"echo a |", true},
+		{"echo a |\n", true},
+		{"echo a |\ncat", false},
+		{"echo a |&", true},
+		{"true &&\n", true},
+		{"false ||", true},
+		{"echo a &", false},
+		{"cat <<EOF\na", true},
+		{"cat <<EOF\na\nEOF", false},
+		{"echo $(echo a", true},
+		{"echo $(exit 1)", false},
+		{"echo $((1 +", true},
+		{"echo ${A", true},
+		{"echo '$(' # '", false},
+		{"arr=(a\nb", true},
+		{"arr=(a\nb)", false},
+		{"[[ a &&\n", true},
+		{"[[ a &&\nb ]]", false},
+		{"echo ${INCOMPLETE_UNSET?missing} \"", true},
+		{"echo ${INCOMPLETE_ASSIGNED:=value}", false},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("%d %q", i, c.in), func(t *testing.T) {
+			if got := compiler.Incomplete(c.in); got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+	// checking the input must not change the state of the shell
+	if value, ok := runtime.Variable("INCOMPLETE_ASSIGNED"); ok {
+		t.Errorf("Incomplete assigned %q", value)
+	}
+}
+
 func compareString(a string, b string) bool {
 	ba := []byte(a)
 	bb := []byte(b)
@@ -3,6 +3,7 @@ package compiler_test
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -109,7 +110,7 @@ func TestParse(t *testing.T) {
 				if a.Executable != "command1" {
 					return nil
 				}
-				if *a.Stderr != *a.Stdout {
+				if a.Stderr() != a.Stdout() {
 					return errors.New("stderr and stdout should be the same for command1")
 				}
 				return nil
@@ -138,13 +139,171 @@ func TestParse(t *testing.T) {
 				},
 			},
 			func(a *runtime.Command, b *runtime.Command) error {
-				if *a.Stderr == *a.Stdout {
+				if a.Stderr() == a.Stdout() {
 					return errors.New("stderr and stdout should not be the same for command1")
 				}
 				return nil
 			},
 		},
 
+		{
+			"command1 >/dev/null 2>&1",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "command1", Index: 0},
+				{Kind: compiler.LexicalFileStdout, Index: 9},
+				{Kind: compiler.LexicalIdentifier, Content: "/dev/null", Index: 10},
+				{Kind: compiler.LexicalStderrToStdout, Index: 20},
+			},
+			[]runtime.Command{
+				{
+					Executable: "command1",
+					Arguments:  []string{},
+					Background: false,
+				},
+			},
+			func(a *runtime.Command, b *runtime.Command) error {
+				if a.Stderr() != a.Stdout() {
+					return errors.New("stderr should be redirected to /dev/null too")
+				}
+				return nil
+			},
+		},
+
+		{
+			"command1 2>&1 >/dev/null",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "command1", Index: 0},
+				{Kind: compiler.LexicalStderrToStdout, Index: 9},
+				{Kind: compiler.LexicalFileStdout, Index: 14},
+				{Kind: compiler.LexicalIdentifier, Content: "/dev/null", Index: 15},
+			},
+			[]runtime.Command{
+				{
+					Executable: "command1",
+					Arguments:  []string{},
+					Background: false,
+				},
+			},
+			func(a *runtime.Command, b *runtime.Command) error {
+				if a.Stderr() == a.Stdout() {
+					return errors.New("stderr should still be the original stdout")
+				}
+				return nil
+			},
+		},
+
+		{
+			"command1 arg1 |& command2",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "command1", Index: 0},
+				{Kind: compiler.LexicalIdentifier, Content: "arg1", Index: 9},
+				{Kind: compiler.LexicalPipeStdoutAndStderr, Index: 14},
+				{Kind: compiler.LexicalIdentifier, Content: "command2", Index: 17},
+			},
+			[]runtime.Command{
+				{
+					Executable: "command1",
+					Arguments:  []string{"arg1"},
+					Background: true,
+				},
+				{
+					Executable: "command2",
+					Arguments:  []string{},
+					Background: false,
+				},
+			},
+			func(a *runtime.Command, b *runtime.Command) error {
+				if a.Executable != "command1" {
+					return nil
+				}
+				if a.Stderr() != a.Stdout() {
+					return errors.New("stderr and stdout should both be the pipe for command1")
+				}
+				return nil
+			},
+		},
+
+		{
+			"! grep -q a | time cat || time -p ! true",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "!", Index: 0},
+				{Kind: compiler.LexicalIdentifier, Content: "grep", Index: 2},
+				{Kind: compiler.LexicalIdentifier, Content: "-q", Index: 7},
+				{Kind: compiler.LexicalIdentifier, Content: "a", Index: 10},
+				{Kind: compiler.LexicalPipeStdout, Index: 12},
+				{Kind: compiler.LexicalIdentifier, Content: "time", Index: 14},
+				{Kind: compiler.LexicalIdentifier, Content: "cat", Index: 19},
+				{Kind: compiler.LexicalOr, Index: 23},
+				{Kind: compiler.LexicalIdentifier, Content: "time", Index: 26},
+				{Kind: compiler.LexicalIdentifier, Content: "-p", Index: 31},
+				{Kind: compiler.LexicalIdentifier, Content: "!", Index: 34},
+				{Kind: compiler.LexicalIdentifier, Content: "true", Index: 36},
+			},
+			[]runtime.Command{
+				{
+					Executable: "grep",
+					Arguments:  []string{"-q", "a"},
+					Background: true,
+				},
+				{
+					Executable: "time",
+					Arguments:  []string{"cat"},
+					Negate:     true,
+					Or: &runtime.Command{
+						Executable: "true",
+						Negate:     true,
+					},
+				},
+			},
+			func(a *runtime.Command, b *runtime.Command) error {
+				if a.Pipeline != nil && a.Pipeline.Time {
+					return errors.New("time is only a keyword at the start of a pipeline")
+				}
+				if a.Or != nil && (a.Or.Pipeline == nil || !a.Or.Pipeline.Posix) {
+					return errors.New("the pipeline after || should be timed with -p")
+				}
+				return nil
+			},
+		},
+
+		{
+			"A=1 \"B=2\"",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "A=1", Index: 0},
+				{Kind: compiler.LexicalIdentifier, Content: "B=2", Index: 4},
+			},
+			[]runtime.Command{
+				{
+					Executable: "B=2",
+					Arguments:  []string{},
+					Assignments: []runtime.Assignment{
+						{Name: "A", Value: "1"},
+					},
+				},
+			},
+			nil,
+		},
+
+		{
+			"declare -a arr=(x y)",
+			[]compiler.LexicalToken{
+				{Kind: compiler.LexicalIdentifier, Content: "declare", Index: 0},
+				{Kind: compiler.LexicalIdentifier, Content: "-a", Index: 8},
+				{Kind: compiler.LexicalArrayStart, Content: "arr=", Index: 11},
+				{Kind: compiler.LexicalIdentifier, Content: "x", Index: 16},
+				{Kind: compiler.LexicalIdentifier, Content: "y", Index: 18},
+				{Kind: compiler.LexicalArrayEnd, Index: 19},
+			},
+			[]runtime.Command{
+				{
+					Executable: "declare",
+					Arguments:  []string{"-a", "arr="},
+					Arrays:     map[int][]string{1: {"x", "y"}},
+				},
+			},
+			nil,
+		},
+
 		{
 			"meep||echo ok&&echo meep",
 			[]compiler.LexicalToken{
@@ -278,6 +437,17 @@ func cmdEq(got *runtime.Command, expected *runtime.Command, recursion int) error
 	if got.Background != expected.Background {
 		return fmt.Errorf("background: got: %t, want: %t", got.Background, expected.Background)
 	}
+	if !reflect.DeepEqual(got.Assignments, expected.Assignments) {
+		return fmt.Errorf("assignments: got: %v, want: %v", got.Assignments, expected.Assignments)
+	}
+	if len(got.Arrays) != 0 || len(expected.Arrays) != 0 {
+		if !reflect.DeepEqual(got.Arrays, expected.Arrays) {
+			return fmt.Errorf("arrays: got: %v, want: %v", got.Arrays, expected.Arrays)
+		}
+	}
+	if got.Negate != expected.Negate {
+		return fmt.Errorf("negate: got: %t, want: %t", got.Negate, expected.Negate)
+	}
 	if expected.Or == nil {
 		if got.Or != nil {
 			return fmt.Errorf("or: should be nil, got: %v", got.Or)
@@ -1,7 +1,7 @@
 package compiler
 
 import (
-	"io"
+	"strconv"
 	"strings"
 
 	"github.com/tsukinoko-kun/ohmygosh/iohelper"
@@ -12,185 +12,287 @@ func Parse(text string, tokens []LexicalToken, iop *runtime.IoProvider) ([]*runt
 	commands := make([]*runtime.Command, 0)
 	command := runtime.NewCommand(iop)
 	chainMode := false
+	// negate is set by the ! keyword in front of the current pipeline
+	negate := false
+	// pipeline is created by the time keyword or the first pipe of the current pipeline
+	var pipeline *runtime.Pipeline
+	// endCommand is called when the current command is complete, pipelineEnd is set if it is the last command of its pipeline
+	endCommand := func(pipelineEnd bool) {
+		if !pipelineEnd && pipeline == nil {
+			pipeline = runtime.NewPipeline()
+		}
+		if pipeline != nil {
+			pipeline.Add(command)
+		}
+		if pipelineEnd {
+			command.Negate = negate
+			negate = false
+			pipeline = nil
+		}
+	}
 	done := func() {
 		if !chainMode {
 			commands = append(commands, command)
 		}
 		command = runtime.NewCommand(iop)
 	}
+	// target returns the file name or file descriptor after the redirection at tokens[i]
+	target := func(i int) (LexicalToken, error) {
+		if i+1 >= len(tokens) {
+			return LexicalToken{}, newParserError(tokens[i].Index, text, "unexpected end of input after redirect")
+		}
+		if tokens[i+1].Kind != LexicalIdentifier {
+			return LexicalToken{}, newParserError(tokens[i].Index, text, "expected identifier after redirect")
+		}
+		return tokens[i+1], nil
+	}
+	// redirect opens the file descriptor n of the command with open, which gets the target of the redirection.
+	// Like in bash, a failed redirection fails the command, not the whole input.
+	// It reports whether the file descriptor was opened.
+	redirect := func(n int, targetToken LexicalToken, open func(name string) (*runtime.FileDescriptor, error)) bool {
+		fd, err := open(targetToken.Content)
+		if err != nil {
+			command.RedirectionErr = err
+			return false
+		}
+		command.Fds.Open(n, fd)
+		return true
+	}
+	// fdNumber parses the file descriptor number of a redirection token like n> or the target of n>&m
+	fdNumber := func(token LexicalToken) (int, error) {
+		n, err := strconv.Atoi(token.Content)
+		if err != nil || n < 0 {
+			return 0, newParserError(token.Index, text, "invalid file descriptor "+strconv.Quote(token.Content))
+		}
+		return n, nil
+	}
 	for i := 0; i < len(tokens); i++ {
 		switch token := tokens[i]; token.Kind {
 
 		case LexicalIdentifier:
+			if command.Executable == "" && isAssignmentWord(text, token) {
+				if a, ok := runtime.ParseAssignment(token.Content); ok {
+					command.Assignments = append(command.Assignments, a)
+					continue
+				}
+			}
+			if command.Executable == "" && command.PipeIn == nil && len(command.Fds) == 0 && len(command.Assignments) == 0 {
+				// keywords are only recognized at the start of a pipeline
+				switch {
+				case token.Content == "!" && !negate:
+					negate = true
+					continue
+				case token.Content == "time" && pipeline == nil && !negate:
+					pipeline = runtime.NewPipeline()
+					pipeline.Time = true
+					if i+1 < len(tokens) && tokens[i+1].Kind == LexicalIdentifier && tokens[i+1].Content == "-p" {
+						pipeline.Posix = true
+						i++
+					}
+					continue
+				}
+			}
 			if command.Executable == "" {
 				command.Executable = token.Content
 			} else {
 				command.Arguments = append(command.Arguments, token.Content)
 			}
 
+		case LexicalArrayStart:
+			elements := make([]string, 0)
+			for i++; i < len(tokens) && tokens[i].Kind != LexicalArrayEnd; i++ {
+				if tokens[i].Kind != LexicalIdentifier {
+					return nil, newParserError(tokens[i].Index, text, "syntax error in array assignment")
+				}
+				elements = append(elements, tokens[i].Content)
+			}
+			if i == len(tokens) {
+				return nil, newParserError(token.Index, text, "array assignment not closed")
+			}
+			if command.Executable == "" {
+				a, _ := runtime.ParseAssignment(token.Content)
+				a.Array = elements
+				command.Assignments = append(command.Assignments, a)
+			} else {
+				// an argument of a builtin like declare
+				if command.Arrays == nil {
+					command.Arrays = map[int][]string{}
+				}
+				command.Arrays[len(command.Arguments)] = elements
+				command.Arguments = append(command.Arguments, token.Content)
+			}
+
+		case LexicalConditionStart:
+			// [[ runs like a builtin, the words up to ]] are its arguments
+			command.Executable = token.Content
+			command.Operators = map[int]bool{}
+			for i++; i < len(tokens) && tokens[i].Kind != LexicalConditionEnd; i++ {
+				switch tokens[i].Kind {
+				case LexicalConditionOperator:
+					command.Operators[len(command.Arguments)] = true
+				case LexicalIdentifier:
+				default:
+					return nil, newParserError(tokens[i].Index, text, "syntax error in conditional expression")
+				}
+				command.Arguments = append(command.Arguments, tokens[i].Content)
+			}
+			if i == len(tokens) {
+				return nil, newParserError(token.Index, text, "conditional expression not closed")
+			}
+
 		case LexicalStop:
+			endCommand(true)
 			done()
 			chainMode = false
 
 		case LexicalBackground:
 			command.Background = true
+			endCommand(true)
 			done()
 
-		case LexicalPipeStdout:
+		case LexicalPipeStdout, LexicalPipeStdoutAndStderr:
 			if i+1 < len(tokens) {
-				var w io.WriteCloser
-				var r io.Reader
-				w, r = iohelper.NewPipe()
+				w, r := iohelper.NewPipe()
 				command.Background = true
-				*command.Stdout = &w
+				command.PipeOut = w
+				if token.Kind == LexicalPipeStdoutAndStderr {
+					// |& is a shorthand for 2>&1 |
+					command.Fds.Dup(2, 1)
+				}
+				endCommand(false)
 				done()
-				*command.Stdin = &r
+				command.PipeIn = r
 			} else {
 				return nil, newParserError(token.Index, text, "unexpected end of input after pipe")
 			}
 
-		case LexicalFileStdout:
-			if i+1 < len(tokens) {
-				targetToken := tokens[i+1]
-				if targetToken.Kind == LexicalIdentifier {
-					if w, err := iohelper.NewFileWriter(iop.Closer, targetToken.Content); err != nil {
-						return nil, newParserError(targetToken.Index, text, err.Error())
-					} else {
-						*command.Stdout = &w
-						i++
-					}
-				} else {
-					return nil, newParserError(token.Index, text, "expected identifier after redirect")
-				}
-			} else {
-				return nil, newParserError(token.Index, text, "unexpected end of input after redirect")
+		case LexicalFileStdout, LexicalFileStderr, LexicalFileStdoutAndStderr, LexicalFileOutput:
+			targetToken, err := target(i)
+			if err != nil {
+				return nil, err
 			}
-
-		case LexicalFileAppendStdout:
-			if i+1 < len(tokens) {
-				targetToken := tokens[i+1]
-				if targetToken.Kind == LexicalIdentifier {
-					if w, err := iohelper.NewFileAppendWriter(iop.Closer, targetToken.Content); err != nil {
-						return nil, newParserError(targetToken.Index, text, err.Error())
-					} else {
-						wc := iohelper.WrapWriteFakeCloser(w)
-						*command.Stdout = &wc
-						i++
-						done()
-					}
-				} else {
-					return nil, newParserError(token.Index, text, "expected identifier after redirect")
+			n := 1
+			switch token.Kind {
+			case LexicalFileStderr:
+				n = 2
+			case LexicalFileOutput:
+				if n, err = fdNumber(token); err != nil {
+					return nil, err
 				}
-			} else {
-				return nil, newParserError(token.Index, text, "unexpected end of input after redirect")
 			}
-
-		case LexicalFileStderr:
-			if i+1 < len(tokens) {
-				targetToken := tokens[i+1]
-				if targetToken.Kind == LexicalIdentifier {
-					if w, err := iohelper.NewFileWriter(iop.Closer, targetToken.Content); err != nil {
-						return nil, newParserError(targetToken.Index, text, err.Error())
-					} else {
-						*command.Stderr = &w
-						i++
-						done()
-					}
-				} else {
-					return nil, newParserError(token.Index, text, "expected identifier after redirect")
-				}
-			} else {
-				return nil, newParserError(token.Index, text, "unexpected end of input after redirect")
+			newFileWriter := iohelper.NewFileWriter
+			if runtime.Option("noclobber") {
+				newFileWriter = iohelper.NewNoClobberFileWriter
 			}
+			opened := redirect(n, targetToken, func(name string) (*runtime.FileDescriptor, error) {
+				w, err := newFileWriter(iop.Closer, name)
+				return &runtime.FileDescriptor{Writer: w}, err
+			})
+			if opened && token.Kind == LexicalFileStdoutAndStderr {
+				command.Fds.Dup(2, 1)
+			}
+			i++
 
-		case LexicalFileAppendStderr:
-			if i+1 < len(tokens) {
-				targetToken := tokens[i+1]
-				if targetToken.Kind == LexicalIdentifier {
-					if w, err := iohelper.NewFileAppendWriter(iop.Closer, targetToken.Content); err != nil {
-						return nil, newParserError(targetToken.Index, text, err.Error())
-					} else {
-						wc := iohelper.WrapWriteFakeCloser(w)
-						*command.Stderr = &wc
-						i++
-						done()
-					}
-				} else {
-					return nil, newParserError(token.Index, text, "expected identifier after redirect")
+		case LexicalFileAppendStdout, LexicalFileAppendStderr, LexicalFileAppendStdoutAndStderr, LexicalFileAppendOutput:
+			targetToken, err := target(i)
+			if err != nil {
+				return nil, err
+			}
+			n := 1
+			switch token.Kind {
+			case LexicalFileAppendStderr:
+				n = 2
+			case LexicalFileAppendOutput:
+				if n, err = fdNumber(token); err != nil {
+					return nil, err
 				}
-			} else {
-				return nil, newParserError(token.Index, text, "unexpected end of input after redirect")
 			}
+			opened := redirect(n, targetToken, func(name string) (*runtime.FileDescriptor, error) {
+				w, err := iohelper.NewFileAppendWriter(iop.Closer, name)
+				return &runtime.FileDescriptor{Writer: w}, err
+			})
+			if opened && token.Kind == LexicalFileAppendStdoutAndStderr {
+				command.Fds.Dup(2, 1)
+			}
+			i++
 
-		case LexicalFileStdoutAndStderr:
-			if i+1 < len(tokens) {
-				targetToken := tokens[i+1]
-				if targetToken.Kind == LexicalIdentifier {
-					if w, err := iohelper.NewFileWriter(iop.Closer, targetToken.Content); err != nil {
-						return nil, newParserError(targetToken.Index, text, err.Error())
-					} else {
-						*command.Stdout = &w
-						*command.Stderr = &w
-						i++
-						done()
-					}
-				} else {
-					return nil, newParserError(token.Index, text, "expected identifier after redirect")
-				}
-			} else {
-				return nil, newParserError(token.Index, text, "unexpected end of input after redirect")
+		case LexicalFileClobber:
+			targetToken, err := target(i)
+			if err != nil {
+				return nil, err
 			}
+			n, err := fdNumber(token)
+			if err != nil {
+				return nil, err
+			}
+			redirect(n, targetToken, func(name string) (*runtime.FileDescriptor, error) {
+				w, err := iohelper.NewFileWriter(iop.Closer, name)
+				return &runtime.FileDescriptor{Writer: w}, err
+			})
+			i++
 
-		case LexicalFileAppendStdoutAndStderr:
-			if i+1 < len(tokens) {
-				targetToken := tokens[i+1]
-				if targetToken.Kind == LexicalIdentifier {
-					if w, err := iohelper.NewFileAppendWriter(iop.Closer, targetToken.Content); err != nil {
-						return nil, newParserError(targetToken.Index, text, err.Error())
-					} else {
-						wc := iohelper.WrapWriteFakeCloser(w)
-						*command.Stdout = &wc
-						*command.Stderr = &wc
-						i++
-						done()
-					}
-				} else {
-					return nil, newParserError(token.Index, text, "expected identifier after redirect")
-				}
-			} else {
-				return nil, newParserError(token.Index, text, "unexpected end of input after redirect")
+		case LexicalFileReadWrite:
+			targetToken, err := target(i)
+			if err != nil {
+				return nil, err
+			}
+			n, err := fdNumber(token)
+			if err != nil {
+				return nil, err
 			}
+			redirect(n, targetToken, func(name string) (*runtime.FileDescriptor, error) {
+				f, err := iohelper.NewFileReadWriter(iop.Closer, name)
+				return &runtime.FileDescriptor{Reader: f, Writer: f}, err
+			})
+			i++
 
 		case LexicalStderrToStdout:
-			command.Stderr = command.Stdout
+			command.Fds.Dup(2, 1)
 
 		case LexicalStdoutToStderr:
-			command.Stdout = command.Stderr
+			command.Fds.Dup(1, 2)
 
-		case LexicalRedirectStdin:
-			if i+1 < len(tokens) {
-				targetToken := tokens[i+1]
-				if targetToken.Kind == LexicalIdentifier {
-					if r, err := iohelper.NewFileReader(iop.Closer, targetToken.Content); err != nil {
-						return nil, newParserError(targetToken.Index, text, err.Error())
-					} else {
-						*command.Stdin = &r
-						i++
-					}
-				} else {
-					return nil, newParserError(token.Index, text, "expected identifier after redirect")
-				}
+		case LexicalDuplicateOutput, LexicalDuplicateInput:
+			n, err := fdNumber(token)
+			if err != nil {
+				return nil, err
+			}
+			targetToken, err := target(i)
+			if err != nil {
+				return nil, err
+			}
+			if targetToken.Content == "-" {
+				command.Fds.Close(n)
+			} else if m, err := fdNumber(targetToken); err != nil {
+				return nil, newParserError(targetToken.Index, text, "ambiguous redirect")
 			} else {
-				return nil, newParserError(token.Index, text, "unexpected end of input after redirect")
+				command.Fds.Dup(n, m)
+			}
+			i++
+
+		case LexicalRedirectStdin, LexicalFileInput:
+			targetToken, err := target(i)
+			if err != nil {
+				return nil, err
+			}
+			n := 0
+			if token.Kind == LexicalFileInput {
+				if n, err = fdNumber(token); err != nil {
+					return nil, err
+				}
 			}
+			redirect(n, targetToken, func(name string) (*runtime.FileDescriptor, error) {
+				r, err := iohelper.NewFileReader(iop.Closer, name)
+				return &runtime.FileDescriptor{Reader: r}, err
+			})
+			i++
 
 		case LexicalHereDocument:
-			var r io.Reader = strings.NewReader(token.Content)
-			*command.Stdin = &r
-			done()
+			command.Fds.Open(0, &runtime.FileDescriptor{Reader: strings.NewReader(token.Content)})
 
 		case LexicalAnd:
 			if i+1 < len(tokens) {
+				endCommand(true)
 				if chainMode {
 					// this is NOT the first command in the chain
 					command.And = runtime.NewCommand(iop)
@@ -208,6 +310,7 @@ func Parse(text string, tokens []LexicalToken, iop *runtime.IoProvider) ([]*runt
 
 		case LexicalOr:
 			if i+1 < len(tokens) {
+				endCommand(true)
 				if chainMode {
 					// this is NOT the first command in the chain
 					command.Or = runtime.NewCommand(iop)
@@ -224,8 +327,18 @@ func Parse(text string, tokens []LexicalToken, iop *runtime.IoProvider) ([]*runt
 			}
 		}
 	}
-	if !chainMode && command.Executable != "" {
+	endCommand(true)
+	if !chainMode && (command.Executable != "" || len(command.Assignments) != 0) {
 		commands = append(commands, command)
 	}
 	return commands, nil
 }
+
+// isAssignmentWord reports whether the identifier starts with an unquoted name followed by =, += or [.
+func isAssignmentWord(text string, token LexicalToken) bool {
+	j := token.Index
+	for j < len(text) && isNameChar(text[j]) {
+		j++
+	}
+	return runtime.IsName(text[token.Index:j]) && j < len(text) && (text[j] == '=' || text[j] == '+' || text[j] == '[')
+}
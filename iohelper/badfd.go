@@ -0,0 +1,26 @@
+package iohelper
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	ErrBadFileDescriptor = errors.New("bad file descriptor")
+	// BadFileDescriptor stands in for a closed file descriptor, every read and write fails.
+	BadFileDescriptor io.ReadWriteCloser = badFileDescriptor{}
+)
+
+type badFileDescriptor struct{}
+
+func (badFileDescriptor) Read(_ []byte) (int, error) {
+	return 0, ErrBadFileDescriptor
+}
+
+func (badFileDescriptor) Write(_ []byte) (int, error) {
+	return 0, ErrBadFileDescriptor
+}
+
+func (badFileDescriptor) Close() error {
+	return nil
+}
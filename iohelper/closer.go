@@ -37,6 +37,8 @@ func (c *Closer) Close() {
 		}(v)
 	}
 	wg.Wait()
+	c.closable = c.closable[:0]
+	c.closableE = c.closableE[:0]
 }
 
 func (c *Closer) Add(v closable) {
@@ -51,6 +53,33 @@ func (c *Closer) AddE(v closableE) {
 	c.closableE = append(c.closableE, &v)
 }
 
+// RemoveE removes v from the closer, so it stays open when the closer gets closed.
+func (c *Closer) RemoveE(v closableE) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, w := range c.closableE {
+		if *w == v {
+			c.closableE = append(c.closableE[:i], c.closableE[i+1:]...)
+			return
+		}
+	}
+}
+
+// CloseE closes v and removes it from the closer if it was added using AddE.
+// It reports whether v was closed, streams the closer doesn't own stay open.
+func (c *Closer) CloseE(v closableE) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, w := range c.closableE {
+		if *w == v {
+			c.closableE = append(c.closableE[:i], c.closableE[i+1:]...)
+			_ = v.Close()
+			return true
+		}
+	}
+	return false
+}
+
 func NewCloser() *Closer {
 	return &Closer{
 		closable:  make([]*closable, 0),
@@ -21,6 +21,11 @@ func (ww *wrappedWriterFakeCloser) Close() error {
 	return nil
 }
 
+// Unwrap returns the wrapped writer.
+func (ww *wrappedWriterFakeCloser) Unwrap() io.Writer {
+	return ww.w
+}
+
 func WrapReadFakeCloser(r io.Reader) io.ReadCloser {
 	wr := &wrappedReaderFakeCloser{r}
 	return wr
@@ -20,11 +20,11 @@ func NewFileWriter(c *Closer, p string) (io.WriteCloser, error) {
 	case "/dev/null":
 		return Discard, nil
 	case "/dev/stdout":
-		return os.Stdout, nil
+		return WrapWriteFakeCloser(os.Stdout), nil
 	case "/dev/stderr":
-		return os.Stderr, nil
+		return WrapWriteFakeCloser(os.Stderr), nil
 	case "/dev/stdin":
-		return os.Stdin, nil
+		return WrapWriteFakeCloser(os.Stdin), nil
 	}
 	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -40,17 +40,36 @@ func NewFileWriter(c *Closer, p string) (io.WriteCloser, error) {
 	return f, nil
 }
 
-func NewFileAppendWriter(c *Closer, p string) (io.Writer, error) {
+// NewNoClobberFileWriter works like NewFileWriter but fails if p is an existing regular file.
+// This is how > behaves when the noclobber option is set.
+func NewNoClobberFileWriter(c *Closer, p string) (io.WriteCloser, error) {
+	p = filepath.Clean(p)
+	if fi, err := os.Stat(p); err == nil {
+		if fi.Mode().IsRegular() {
+			return nil, fmt.Errorf("cannot overwrite existing file %q", p)
+		}
+		// devices, pipes and the like don't get clobbered
+		return NewFileWriter(c, p)
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("could not open file %q", p), err)
+	}
+	c.AddE(f)
+	return f, nil
+}
+
+func NewFileAppendWriter(c *Closer, p string) (io.WriteCloser, error) {
 	p = filepath.Clean(p)
 	switch p {
 	case "/dev/null":
-		return io.Discard, nil
+		return Discard, nil
 	case "/dev/stdout":
-		return os.Stdout, nil
+		return WrapWriteFakeCloser(os.Stdout), nil
 	case "/dev/stderr":
-		return os.Stderr, nil
+		return WrapWriteFakeCloser(os.Stderr), nil
 	case "/dev/stdin":
-		return os.Stdin, nil
+		return WrapWriteFakeCloser(os.Stdin), nil
 	}
 	f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
@@ -83,3 +102,14 @@ func NewFileReader(c *Closer, p string) (io.Reader, error) {
 	c.AddE(f)
 	return f, nil
 }
+
+// NewFileReadWriter opens p for reading and writing without truncating it, the file gets created if it doesn't exist.
+func NewFileReadWriter(c *Closer, p string) (*os.File, error) {
+	p = filepath.Clean(p)
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("could not open file %q", p), err)
+	}
+	c.AddE(f)
+	return f, nil
+}
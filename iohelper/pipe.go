@@ -2,47 +2,74 @@ package iohelper
 
 import (
 	"io"
-	"sync/atomic"
+	"sync"
 )
 
 type pipe struct {
 	buffer chan []byte
-	open   atomic.Bool
+	done   chan struct{}
+	once   sync.Once
+	// wMutex serializes writers (e.g. stdout and stderr of the same command) so chunks never interleave.
+	wMutex sync.Mutex
+	// rest holds data of the last chunk that did not fit into the read buffer.
+	rest []byte
 }
 
 func NewPipe() (io.WriteCloser, io.ReadCloser) {
 	p := &pipe{
 		buffer: make(chan []byte, 1),
-		open:   atomic.Bool{},
+		done:   make(chan struct{}),
 	}
-	p.open.Store(true)
 	return p, p
 }
 
 func (p *pipe) Write(b []byte) (int, error) {
-	if !p.open.Load() {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	p.wMutex.Lock()
+	defer p.wMutex.Unlock()
+	// the caller is allowed to reuse b after Write returns
+	data := make([]byte, len(b))
+	copy(data, b)
+	select {
+	case <-p.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	select {
+	case p.buffer <- data:
+		return len(b), nil
+	case <-p.done:
 		return 0, io.ErrClosedPipe
 	}
-	p.buffer <- b
-	return len(b), nil
 }
 
 func (p *pipe) Read(b []byte) (int, error) {
-	if !p.open.Load() {
-		return 0, io.EOF
+	if len(p.rest) != 0 {
+		n := copy(b, p.rest)
+		p.rest = p.rest[n:]
+		return n, nil
 	}
-	data, ok := <-p.buffer
-	if !ok {
-		return 0, io.EOF
+	var data []byte
+	select {
+	case data = <-p.buffer:
+	case <-p.done:
+		// deliver what was written before the pipe got closed
+		select {
+		case data = <-p.buffer:
+		default:
+			return 0, io.EOF
+		}
 	}
 	n := copy(b, data)
+	p.rest = data[n:]
 	return n, nil
 }
 
 func (p *pipe) Close() error {
-	if !p.open.Swap(false) {
-		return nil
-	}
-	close(p.buffer)
+	p.once.Do(func() {
+		close(p.done)
+	})
 	return nil
 }
@@ -1,7 +1,10 @@
 package iohelper_test
 
 import (
+	"bufio"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/tsukinoko-kun/ohmygosh/iohelper"
@@ -89,4 +92,63 @@ func TestPipe(t *testing.T) {
 			t.Errorf("unexpected data read: %v", string(buf))
 		}
 	})
+	t.Run("concurrent writers", func(t *testing.T) {
+		t.Parallel()
+
+		w, r := iohelper.NewPipe()
+		defer r.Close()
+
+		go func() {
+			wg := sync.WaitGroup{}
+			for _, prefix := range []string{"out", "err"} {
+				wg.Add(1)
+				go func(prefix string) {
+					defer wg.Done()
+					for i := 0; i < 100; i++ {
+						_, _ = fmt.Fprintf(w, "%s %d\n", prefix, i)
+					}
+				}(prefix)
+			}
+			wg.Wait()
+			w.Close()
+		}()
+
+		lines := 0
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "out ") && !strings.HasPrefix(line, "err ") {
+				t.Errorf("unexpected line: %q", line)
+			}
+			lines++
+		}
+		if lines != 200 {
+			t.Errorf("unexpected number of lines read: %v", lines)
+		}
+	})
+
+	t.Run("small read buffer", func(t *testing.T) {
+		t.Parallel()
+
+		w, r := iohelper.NewPipe()
+		defer r.Close()
+
+		go func() {
+			_, _ = fmt.Fprint(w, "hello world")
+			w.Close()
+		}()
+
+		sb := strings.Builder{}
+		buf := make([]byte, 3)
+		for {
+			n, err := r.Read(buf)
+			sb.Write(buf[:n])
+			if err != nil {
+				break
+			}
+		}
+		if sb.String() != "hello world" {
+			t.Errorf("unexpected data read: %v", sb.String())
+		}
+	})
 }
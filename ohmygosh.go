@@ -10,5 +10,8 @@ import (
 func Execute(text string) (*sync.WaitGroup, error) {
 	io := runtime.DefaultIoProvider()
 	defer io.Close()
-	return compiler.Execute(text, io)
+	wg, err := compiler.Execute(text, io)
+	// the text is a whole script, it ends here
+	runtime.RunExitTrap()
+	return wg, err
 }
@@ -0,0 +1,320 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Arithmetic evaluates a shell arithmetic expression like the one inside of $((...)).
+// All calculations are done using 64 bit signed integers.
+func Arithmetic(expr string) (int64, error) {
+	return arithmetic(expr, 0)
+}
+
+func arithmetic(expr string, depth int) (int64, error) {
+	if depth > 32 {
+		return 0, errors.New("expression recursion level exceeded")
+	}
+	if strings.TrimSpace(expr) == "" {
+		return 0, nil
+	}
+	p := arithmeticParser{text: expr, depth: depth}
+	v, err := p.parseComma()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.i < len(p.text) {
+		return 0, fmt.Errorf("syntax error in expression (error token is %q)", p.text[p.i:])
+	}
+	return v, nil
+}
+
+type arithmeticParser struct {
+	text  string
+	i     int
+	depth int
+}
+
+func (p *arithmeticParser) skipSpace() {
+	for p.i < len(p.text) {
+		switch p.text[p.i] {
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+			p.i++
+		default:
+			return
+		}
+	}
+}
+
+// accept consumes op if it is next in the expression.
+// Operators that are a prefix of a longer operator (like < and <<) are only accepted if the longer one doesn't match.
+func (p *arithmeticParser) accept(op string, notFollowedBy ...string) bool {
+	p.skipSpace()
+	if !strings.HasPrefix(p.text[p.i:], op) {
+		return false
+	}
+	for _, longer := range notFollowedBy {
+		if strings.HasPrefix(p.text[p.i:], longer) {
+			return false
+		}
+	}
+	p.i += len(op)
+	return true
+}
+
+func (p *arithmeticParser) parseComma() (int64, error) {
+	v, err := p.parseTernary()
+	if err != nil {
+		return 0, err
+	}
+	for p.accept(",") {
+		if v, err = p.parseTernary(); err != nil {
+			return 0, err
+		}
+	}
+	return v, nil
+}
+
+func (p *arithmeticParser) parseTernary() (int64, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return 0, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+	a, err := p.parseComma()
+	if err != nil {
+		return 0, err
+	}
+	if !p.accept(":") {
+		return 0, errors.New("expected ':' in conditional expression")
+	}
+	b, err := p.parseTernary()
+	if err != nil {
+		return 0, err
+	}
+	if cond != 0 {
+		return a, nil
+	}
+	return b, nil
+}
+
+type arithmeticOperator struct {
+	op            string
+	notFollowedBy []string
+	fn            func(a, b int64) (int64, error)
+}
+
+// arithmeticPrecedence lists the binary operators from the lowest to the highest precedence.
+var arithmeticPrecedence = [][]arithmeticOperator{
+	{{"||", nil, func(a, b int64) (int64, error) { return boolToInt(a != 0 || b != 0), nil }}},
+	{{"&&", nil, func(a, b int64) (int64, error) { return boolToInt(a != 0 && b != 0), nil }}},
+	{{"|", []string{"||"}, func(a, b int64) (int64, error) { return a | b, nil }}},
+	{{"^", nil, func(a, b int64) (int64, error) { return a ^ b, nil }}},
+	{{"&", []string{"&&"}, func(a, b int64) (int64, error) { return a & b, nil }}},
+	{
+		{"==", nil, func(a, b int64) (int64, error) { return boolToInt(a == b), nil }},
+		{"!=", nil, func(a, b int64) (int64, error) { return boolToInt(a != b), nil }},
+	},
+	{
+		{"<=", nil, func(a, b int64) (int64, error) { return boolToInt(a <= b), nil }},
+		{">=", nil, func(a, b int64) (int64, error) { return boolToInt(a >= b), nil }},
+		{"<", []string{"<<"}, func(a, b int64) (int64, error) { return boolToInt(a < b), nil }},
+		{">", []string{">>"}, func(a, b int64) (int64, error) { return boolToInt(a > b), nil }},
+	},
+	{
+		{"<<", nil, func(a, b int64) (int64, error) { return a << uint64(b), nil }},
+		{">>", nil, func(a, b int64) (int64, error) { return a >> uint64(b), nil }},
+	},
+	{
+		{"+", nil, func(a, b int64) (int64, error) { return a + b, nil }},
+		{"-", nil, func(a, b int64) (int64, error) { return a - b, nil }},
+	},
+	{
+		{"*", []string{"**"}, func(a, b int64) (int64, error) { return a * b, nil }},
+		{"/", nil, func(a, b int64) (int64, error) {
+			if b == 0 {
+				return 0, errors.New("division by 0")
+			}
+			return a / b, nil
+		}},
+		{"%", nil, func(a, b int64) (int64, error) {
+			if b == 0 {
+				return 0, errors.New("division by 0")
+			}
+			return a % b, nil
+		}},
+	},
+}
+
+func (p *arithmeticParser) parseBinary(level int) (int64, error) {
+	if level >= len(arithmeticPrecedence) {
+		return p.parsePower()
+	}
+	a, err := p.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+outer:
+	for {
+		for _, op := range arithmeticPrecedence[level] {
+			if p.accept(op.op, op.notFollowedBy...) {
+				b, err := p.parseBinary(level + 1)
+				if err != nil {
+					return 0, err
+				}
+				if a, err = op.fn(a, b); err != nil {
+					return 0, err
+				}
+				continue outer
+			}
+		}
+		return a, nil
+	}
+}
+
+func (p *arithmeticParser) parsePower() (int64, error) {
+	base, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	if !p.accept("**") {
+		return base, nil
+	}
+	exp, err := p.parsePower()
+	if err != nil {
+		return 0, err
+	}
+	if exp < 0 {
+		return 0, errors.New("exponent less than 0")
+	}
+	result := int64(1)
+	for ; exp > 0; exp-- {
+		result *= base
+	}
+	return result, nil
+}
+
+func (p *arithmeticParser) parseUnary() (int64, error) {
+	switch {
+	case p.accept("-"):
+		v, err := p.parseUnary()
+		return -v, err
+	case p.accept("+"):
+		return p.parseUnary()
+	case p.accept("!", "!="):
+		v, err := p.parseUnary()
+		return boolToInt(v == 0), err
+	case p.accept("~"):
+		v, err := p.parseUnary()
+		return ^v, err
+	}
+	return p.parsePrimary()
+}
+
+func (p *arithmeticParser) parsePrimary() (int64, error) {
+	p.skipSpace()
+	if p.i >= len(p.text) {
+		return 0, errors.New("syntax error: operand expected")
+	}
+	if p.accept("(") {
+		v, err := p.parseComma()
+		if err != nil {
+			return 0, err
+		}
+		if !p.accept(")") {
+			return 0, errors.New("missing ')' in expression")
+		}
+		return v, nil
+	}
+	start := p.i
+	c := p.text[p.i]
+	switch {
+	case c >= '0' && c <= '9':
+		for p.i < len(p.text) && (isNameChar(p.text[p.i]) || p.text[p.i] == '#') {
+			p.i++
+		}
+		return parseArithmeticNumber(p.text[start:p.i])
+	case isNameStart(c):
+		for p.i < len(p.text) && isNameChar(p.text[p.i]) {
+			p.i++
+		}
+		name := p.text[start:p.i]
+		value, _ := Variable(name)
+		if p.i < len(p.text) && p.text[p.i] == '[' {
+			// array element
+			end := strings.IndexByte(p.text[p.i:], ']')
+			if end == -1 {
+				return 0, errors.New("missing ']' in array subscript")
+			}
+			end += p.i
+			var err error
+			if value, _, err = VariableElement(name, p.text[p.i+1:end]); err != nil {
+				return 0, err
+			}
+			p.i = end + 1
+		}
+		v, err := arithmetic(value, p.depth+1)
+		if err != nil {
+			return 0, errors.Join(fmt.Errorf("invalid value of variable %q", p.text[start:p.i]), err)
+		}
+		return v, nil
+	default:
+		return 0, fmt.Errorf("syntax error: operand expected (error token is %q)", p.text[p.i:])
+	}
+}
+
+// parseArithmeticNumber parses decimal, octal (0 prefix), hexadecimal (0x prefix) and base#value numbers.
+func parseArithmeticNumber(s string) (int64, error) {
+	var v int64
+	var err error
+	if base, digits, ok := strings.Cut(s, "#"); ok {
+		b, berr := strconv.Atoi(base)
+		if berr != nil || b < 2 || b > 36 {
+			return 0, fmt.Errorf("invalid arithmetic base (error token is %q)", s)
+		}
+		v, err = strconv.ParseInt(digits, b, 64)
+	} else if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		v, err = strconv.ParseInt(s[2:], 16, 64)
+	} else if len(s) > 1 && s[0] == '0' {
+		v, err = strconv.ParseInt(s[1:], 8, 64)
+	} else {
+		v, err = strconv.ParseInt(s, 10, 64)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("value too great for base (error token is %q)", s)
+	}
+	return v, nil
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// IsName reports whether s is a valid shell variable name.
+func IsName(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
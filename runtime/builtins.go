@@ -2,22 +2,61 @@ package runtime
 
 var BuiltinCommands map[string]func(*Command, *IoProvider) error
 
+// Interpret executes shell code and waits for it, builtins like trap use it to run code.
+// If the code has a syntax error, a SyntaxError is returned.
+// It is set by the compiler package, which depends on this package.
+var Interpret func(text string, iop *IoProvider) error
+
+// Incomplete reports whether text ends in the middle of a command, the history uses it to read multi-line entries.
+// It is set by the compiler package.
+var Incomplete func(text string) bool
+
 func init() {
 	BuiltinCommands = map[string]func(*Command, *IoProvider) error{
-		"cd":     execute_cd,
-		"exit":   execute_exit,
-		"echo":   execute_echo,
-		"cat":    execute_cat,
-		"export": execute_export,
-		"unset":  execute_unset,
-		"whoami": execute_whoami,
-		"pwd":    execute_pwd,
-		"which":  execute_which,
-		"type":   execute_type,
-		"sudo":   execute_sudo,
-		"yes":    execute_yes,
-		"true":   execute_true,
-		"false":  execute_false,
-		"sleep":  execute_sleep,
+		"cd":       execute_cd,
+		"pushd":    execute_pushd,
+		"popd":     execute_popd,
+		"dirs":     execute_dirs,
+		"exit":     execute_exit,
+		"exec":     execute_exec,
+		"echo":     execute_echo,
+		"printf":   execute_printf,
+		"cat":      execute_cat,
+		"export":   execute_export,
+		"unset":    execute_unset,
+		"declare":  execute_declare,
+		"typeset":  execute_declare,
+		"local":    execute_local,
+		"readonly": execute_readonly,
+		"return":   execute_return,
+		"source":   execute_source,
+		".":        execute_source,
+		"eval":     execute_eval,
+		"read":     execute_read,
+		"shift":    execute_shift,
+		"getopts":  execute_getopts,
+		"set":      execute_set,
+		"whoami":   execute_whoami,
+		"pwd":      execute_pwd,
+		"which":    execute_which,
+		"type":     execute_type,
+		"sudo":     execute_sudo,
+		"yes":      execute_yes,
+		"true":     execute_true,
+		"false":    execute_false,
+		"sleep":    execute_sleep,
+		"seq":      execute_seq,
+		"parallel": execute_parallel,
+		"trap":     execute_trap,
+		"history":  execute_history,
+		"jobs":     execute_jobs,
+		"fg":       execute_fg,
+		"bg":       execute_bg,
+		"wait":     execute_wait,
+		"kill":     execute_kill,
+		"disown":   execute_disown,
+		"test":     execute_test,
+		"[[":       execute_condition,
+		"[":        execute_test,
 	}
 }
@@ -1,31 +1,360 @@
 package runtime
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"os"
+	"os/exec"
 	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/tsukinoko-kun/ohmygosh/iohelper"
 )
 
 func NewCommand(iop *IoProvider) *Command {
-	stdout := &iop.DefaultOut
-	stderr := &iop.DefaultErr
-	stdin := &iop.DefaultIn
-	return &Command{Stdout: &stdout, Stderr: &stderr, Stdin: &stdin}
+	return &Command{Fds: FdTable{}, iop: iop}
 }
 
 type (
 	Command struct {
-		Executable string
-		Arguments  []string
+		// Assignments are the variable assignments in front of the executable.
+		// Without an executable they assign shell variables, otherwise they only apply to the command.
+		Assignments []Assignment
+		Executable  string
+		Arguments   []string
+		// Arrays holds the elements of array assignments among the arguments (declare -a name=(...)),
+		// keyed by the index of the argument, the argument itself is "name=" or "name+=".
+		Arrays map[int][]string
+		// Operators marks the arguments of [[ ]] that are unquoted operators like &&, ( or ==,
+		// keyed by the index of the argument.
+		Operators  map[int]bool
 		Background bool
-		Stdout     **io.WriteCloser
-		Stderr     **io.WriteCloser
-		Stdin      **io.Reader
-		And        *Command
-		Or         *Command
+		// PipeIn and PipeOut connect the command to the previous and the next command of a pipeline.
+		// Redirections in Fds take precedence over them.
+		PipeIn  io.Reader
+		PipeOut io.WriteCloser
+		Fds     FdTable
+		// RedirectionErr is set if one of the redirections could not be opened, the command fails without running.
+		RedirectionErr error
+		// Negate inverts the exit status (! pipeline), it is set on the last command of the pipeline.
+		Negate bool
+		// Pipeline is set on all commands of a pipeline with more than one command or the time keyword.
+		Pipeline *Pipeline
+		// Job is set on the commands of a pipeline that was started in the background with &.
+		Job *Job
+		And *Command
+		Or  *Command
+		iop *IoProvider
+		// processState is the state of the process started by Execute_default, if there was one.
+		processState *os.ProcessState
+		// pipelineIndex is the position of the command in its Pipeline
+		pipelineIndex int
+	}
+
+	// errexitExempt wraps the failure of a command whose status gets tested,
+	// like the left side of && or a negated pipeline. Such failures don't trigger set -e.
+	errexitExempt struct {
+		error
+	}
+
+	// ExitStatus is the error for a non-zero exit status that was not caused by a failing process,
+	// like the status of a negated pipeline.
+	ExitStatus int
+
+	// SyntaxError is returned by Interpret if the text can't be analyzed or parsed.
+	SyntaxError struct {
+		Err error
 	}
 )
 
+func (e SyntaxError) Error() string {
+	return e.Err.Error()
+}
+
+func (e SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+func (e ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (e errexitExempt) Unwrap() error {
+	return e.error
+}
+
+// Errexit reports whether the failure err ends the shell if set -e is on.
+func Errexit(err error) bool {
+	var exempt errexitExempt
+	return err != nil && !errors.As(err, &exempt)
+}
+
+// ErrexitExempt marks the failure err as tested, like the one of the left side of &&, so it doesn't trigger set -e.
+func ErrexitExempt(err error) error {
+	if err == nil {
+		return nil
+	}
+	return errexitExempt{err}
+}
+
+// Fd returns the file descriptor n of the command or nil if it is not open.
+func (c *Command) Fd(n int) *FileDescriptor {
+	if fd, ok := c.Fds[n]; ok {
+		if fd.inherit {
+			return c.inheritedFd(fd.fd)
+		}
+		if fd.isClosed() {
+			return nil
+		}
+		return fd
+	}
+	return c.inheritedFd(n)
+}
+
+func (c *Command) inheritedFd(n int) *FileDescriptor {
+	switch {
+	case n == 0 && c.PipeIn != nil:
+		return &FileDescriptor{Reader: c.PipeIn}
+	case n == 1 && c.PipeOut != nil:
+		return &FileDescriptor{Writer: c.PipeOut}
+	case c.iop != nil:
+		return c.iop.Fd(n)
+	default:
+		return nil
+	}
+}
+
+func (c *Command) writer(n int) io.WriteCloser {
+	if fd := c.Fd(n); fd != nil && fd.Writer != nil {
+		return fd.Writer
+	}
+	return iohelper.BadFileDescriptor
+}
+
+func (c *Command) Stdout() io.WriteCloser {
+	return c.writer(1)
+}
+
+func (c *Command) Stderr() io.WriteCloser {
+	return c.writer(2)
+}
+
+func (c *Command) Stdin() io.Reader {
+	if fd := c.Fd(0); fd != nil && fd.Reader != nil {
+		return fd.Reader
+	}
+	return iohelper.BadFileDescriptor
+}
+
+// closeFds closes the streams this command opened when it finishes, so readers on the other end of a pipe get EOF
+// and files don't stay open until the end of the input. Inherited streams stay open.
+func (c *Command) closeFds() {
+	if c.PipeOut != nil {
+		_ = c.PipeOut.Close()
+	}
+	if closer, ok := c.PipeIn.(io.Closer); ok {
+		// the previous command of the pipeline fails to write from now on, like a process gets SIGPIPE
+		_ = closer.Close()
+	}
+	for _, fd := range c.Fds {
+		if fd.Writer != nil && (c.iop == nil || !c.iop.Closer.CloseE(fd.Writer)) {
+			_ = fd.Writer.Close()
+		}
+		// readers are only closed if the command opened them, /dev/stdin is the shell's own stdin
+		if closer, ok := fd.Reader.(io.Closer); ok && c.iop != nil {
+			c.iop.Closer.CloseE(closer)
+		}
+	}
+}
+
+// skip closes the streams of a command that doesn't run, like the right side of && after a failure,
+// and of the commands that are chained to it.
+func (c *Command) skip() {
+	c.closeFds()
+	if c.And != nil {
+		c.And.skip()
+	}
+	if c.Or != nil {
+		c.Or.skip()
+	}
+}
+
+// isBrokenPipe reports whether err is the failure of a write to a pipe that has no reader anymore.
+// Like a process that gets killed by SIGPIPE, builtins stop writing without an error message then.
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, io.ErrClosedPipe) || errors.Is(err, syscall.EPIPE)
+}
+
+// brokenPipeStatus is the exit status of a command that stopped because of a broken pipe (128 + SIGPIPE).
+const brokenPipeStatus = ExitStatus(141)
+
+// assign performs the assignments of a command without executable.
+func (c *Command) assign() error {
+	for _, a := range c.Assignments {
+		if err := Assign(a); err != nil {
+			_, _ = fmt.Fprintf(c.Stderr(), "%s: %s\n", a.Name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// assignTemporarily performs the assignments in front of a builtin and returns a function that undoes them.
+func (c *Command) assignTemporarily() func() {
+	if len(c.Assignments) == 0 {
+		return func() {}
+	}
+	type oldValue struct {
+		name  string
+		value string
+		ok    bool
+	}
+	old := make([]oldValue, 0, len(c.Assignments))
+	for _, a := range c.Assignments {
+		value, ok := Variable(a.Name)
+		old = append(old, oldValue{a.Name, value, ok})
+		_ = Assign(a)
+	}
+	return func() {
+		for i := len(old) - 1; i >= 0; i-- {
+			if old[i].ok {
+				_ = Assign(Assignment{Name: old[i].name, Value: old[i].value})
+			} else {
+				_ = UnsetVariable(old[i].name)
+			}
+		}
+	}
+}
+
+// environ returns the environment of an external program, including the assignments in front of it.
+func (c *Command) environ() []string {
+	env := os.Environ()
+	for _, a := range c.Assignments {
+		if a.Array != nil || a.Subscript {
+			continue
+		}
+		value := a.Value
+		if a.Append {
+			old, _ := Variable(a.Name)
+			value = old + value
+		}
+		env = append(env, a.Name+"="+value)
+	}
+	return env
+}
+
+// ioProvider returns the IoProvider for shell code that a builtin like source runs on behalf of the command.
+// Without redirections and pipes this is iop itself, so exec in that code affects the shell.
+func (c *Command) ioProvider(iop *IoProvider) *IoProvider {
+	if len(c.Fds) == 0 && c.PipeIn == nil && c.PipeOut == nil {
+		return iop
+	}
+	child := &IoProvider{
+		DefaultOut: c.Stdout(),
+		DefaultErr: c.Stderr(),
+		DefaultIn:  c.Stdin(),
+		Fds:        maps.Clone(iop.Fds),
+		Closer:     iop.Closer,
+	}
+	for n := range c.Fds {
+		if n >= 3 {
+			child.setFd(n, c.Fd(n))
+		}
+	}
+	return child
+}
+
+// trace prints the command with its expanded arguments for set -x, prefixed with PS4.
+func (c *Command) trace(w io.Writer) {
+	ps4, ok := Variable("PS4")
+	if !ok {
+		ps4 = "+ "
+	}
+	words := make([]string, 0, len(c.Assignments)+len(c.Arguments)+1)
+	for _, a := range c.Assignments {
+		op := "="
+		if a.Append {
+			op = "+="
+		}
+		if a.Array != nil {
+			elements := make([]string, len(a.Array))
+			for i, element := range a.Array {
+				elements[i] = Quote(element)
+			}
+			words = append(words, a.Name+op+"("+strings.Join(elements, " ")+")")
+		} else {
+			words = append(words, a.Name+op+Quote(a.Value))
+		}
+	}
+	if c.Executable != "" {
+		words = append(words, Quote(c.Executable))
+	}
+	for _, arg := range c.Arguments {
+		words = append(words, Quote(arg))
+	}
+	_, _ = fmt.Fprintf(w, "%s%s\n", ps4, strings.Join(words, " "))
+}
+
+// Quote quotes s for the shell if it contains special characters, like bash does in the output of set -x.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if strings.IndexFunc(s, func(r rune) bool {
+		return !(r == '_' || r == '-' || r == '.' || r == '/' || r == ':' || r == ',' || r == '=' || r == '+' || r == '@' || r == '%' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'))
+	}) == -1 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// status is the exit status of the last command ($?)
+var status atomic.Int32
+
+// Status returns the exit status of the last foreground command ($?).
+func Status() int {
+	return int(status.Load())
+}
+
+// SetStatus sets the exit status of the last foreground command to the status of the result err.
+func SetStatus(err error) {
+	setStatus(exitCode(err))
+}
+
+func setStatus(code int) {
+	status.Store(int32(code))
+}
+
+// exitCode returns the exit status a shell reports for the result of a command.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var r returnStatus
+	if errors.As(err, &r) {
+		return int(r)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			// the process was killed by a signal
+			return 128 + int(ws.Signal())
+		}
+		if exitErr.ExitCode() > 0 {
+			return exitErr.ExitCode()
+		}
+	}
+	var exitStatus ExitStatus
+	if errors.As(err, &exitStatus) && exitStatus > 0 {
+		return int(exitStatus)
+	}
+	return 1
+}
+
 func (c *Command) String() string {
 	str := strings.Builder{}
 	str.WriteString(c.Executable)
@@ -47,16 +376,53 @@ func (c *Command) String() string {
 func (c *Command) Execute(iop *IoProvider) error {
 	var err error
 
-	if fn, builtin := BuiltinCommands[strings.ToLower(c.Executable)]; builtin {
+	if c.Pipeline != nil {
+		c.Pipeline.begin()
+	}
+	if Option("xtrace") {
+		c.trace(iop.DefaultErr)
+	}
+	if c.RedirectionErr != nil {
+		c.Job.begin()
+		_, _ = fmt.Fprintf(c.Stderr(), "%s: %s\n", c.Executable, c.RedirectionErr)
+		err = c.RedirectionErr
+	} else if c.Executable == "" {
+		// a job of assignments has no process, $! must not wait for one
+		c.Job.begin()
+		err = c.assign()
+	} else if fn, builtin := BuiltinCommands[strings.ToLower(c.Executable)]; builtin {
+		restore := c.assignTemporarily()
+		c.Job.begin()
 		err = fn(c, iop)
+		restore()
 	} else {
 		err = Execute_default(c, iop)
 	}
+	c.closeFds()
+
+	if c.Pipeline != nil {
+		c.Pipeline.done(c.pipelineIndex, c.processState, err)
+		if c.PipeOut == nil {
+			// the last command determines the result of the whole pipeline
+			err = c.Pipeline.finish(iop.DefaultErr, err)
+		}
+	}
+	if c.Negate {
+		if err == nil {
+			err = errexitExempt{ExitStatus(1)}
+		} else {
+			err = nil
+		}
+	}
 
 	if err != nil {
 		// failed
 		if c.Or != nil {
 			return c.Or.Execute(iop)
+		} else if c.And != nil {
+			c.And.skip()
+			// the status of the left side of && is tested
+			return errexitExempt{err}
 		} else {
 			return err
 		}
@@ -65,6 +431,9 @@ func (c *Command) Execute(iop *IoProvider) error {
 		if c.And != nil {
 			return c.And.Execute(iop)
 		}
+		if c.Or != nil {
+			c.Or.skip()
+		}
 	}
 
 	return nil
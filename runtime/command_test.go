@@ -0,0 +1,83 @@
+package runtime
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"testing"
+
+	"github.com/tsukinoko-kun/ohmygosh/iohelper"
+)
+
+func TestCommandClosesItsStreams(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("content\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		// first is the command in front of && or ||, it decides whether the second one runs
+		first   string
+		and, or bool
+	}{
+		{name: "true && true <file", first: "true", and: true},
+		{name: "false && true <file", first: "false", and: true},
+		{name: "true || true <file", first: "true", or: true},
+		{name: "false || true <file", first: "false", or: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			iop, _, _ := TestIoProvider("")
+			defer iop.Close()
+			r, err := iohelper.NewFileReader(iop.Closer, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := iohelper.NewFileWriter(iop.Closer, filepath.Join(dir, "out"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			second := NewCommand(iop)
+			second.Executable = "true"
+			second.Fds.Open(0, &FileDescriptor{Reader: r})
+			second.Fds.Open(1, &FileDescriptor{Writer: w})
+			first := NewCommand(iop)
+			first.Executable = c.first
+			if c.and {
+				first.And = second
+			} else {
+				first.Or = second
+			}
+			_ = first.Execute(iop)
+
+			// the streams of the second command are closed, whether it ran or not
+			if _, err := r.Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+				t.Errorf("read after the command: %v, expected the file to be closed", err)
+			}
+			if _, err := w.Write([]byte("x")); !errors.Is(err, os.ErrClosed) {
+				t.Errorf("write after the command: %v, expected the file to be closed", err)
+			}
+		})
+	}
+
+	t.Run("stdin stays open", func(t *testing.T) {
+		if goruntime.GOOS == "windows" {
+			t.Skip("/dev/stdin is a Unix path")
+		}
+		iop, _, _ := TestIoProvider("")
+		defer iop.Close()
+		r, err := iohelper.NewFileReader(iop.Closer, "/dev/stdin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := NewCommand(iop)
+		cmd.Executable = "true"
+		cmd.Fds.Open(0, &FileDescriptor{Reader: r})
+		_ = cmd.Execute(iop)
+		if _, err := os.Stdin.Stat(); err != nil {
+			t.Errorf("stdin was closed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,274 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// IsConditionOperator reports whether the unquoted word is an operator of [[ ]].
+func IsConditionOperator(word string) bool {
+	switch word {
+	case "!", "==", "=~", "-v", "-o":
+		return true
+	}
+	return isUnaryTestOperator(word) || isBinaryTestOperator(word)
+}
+
+// QuotePattern escapes the characters of s that have a special meaning in a pattern, so it matches literally.
+func QuotePattern(s string) string {
+	sb := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(`\*?[]`, s[i]) != -1 {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// patternRegexp translates a pattern with *, ? and [...] into a regular expression that matches the whole string.
+func patternRegexp(pattern string) (*regexp.Regexp, error) {
+	sb := strings.Builder{}
+	sb.WriteString(`(?s)^`)
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '*':
+			sb.WriteString(`.*`)
+		case '?':
+			sb.WriteString(`.`)
+		case '[':
+			end := bracketEnd(pattern, i)
+			if end == -1 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			set := pattern[i+1 : end]
+			sb.WriteByte('[')
+			if set[0] == '!' || set[0] == '^' {
+				sb.WriteByte('^')
+				set = set[1:]
+			}
+			for j := 0; j < len(set); j++ {
+				switch {
+				case strings.HasPrefix(set[j:], "[:"):
+					// character classes like [:alpha:] are the same in regular expressions
+					k := strings.Index(set[j:], ":]") + j + 2
+					sb.WriteString(set[j:k])
+					j = k - 1
+				case set[j] == '\\' && j+1 < len(set):
+					j++
+					sb.WriteString(regexp.QuoteMeta(set[j : j+1]))
+				case set[j] == '[' || set[j] == '\\':
+					sb.WriteByte('\\')
+					sb.WriteByte(set[j])
+				default:
+					sb.WriteByte(set[j])
+				}
+			}
+			sb.WriteByte(']')
+			i = end
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteByte('$')
+	return regexp.Compile(sb.String())
+}
+
+// bracketEnd returns the index of the ] that closes the bracket expression starting at pattern[i] or -1.
+func bracketEnd(pattern string, i int) int {
+	j := i + 1
+	if j < len(pattern) && (pattern[j] == '!' || pattern[j] == '^') {
+		j++
+	}
+	if j < len(pattern) && pattern[j] == ']' {
+		// ] right after [ is part of the set
+		j++
+	}
+	for ; j < len(pattern); j++ {
+		switch {
+		case pattern[j] == '\\':
+			j++
+		case strings.HasPrefix(pattern[j:], "[:"):
+			k := strings.Index(pattern[j:], ":]")
+			if k == -1 {
+				return -1
+			}
+			j += k + 1
+		case pattern[j] == ']':
+			return j
+		}
+	}
+	return -1
+}
+
+// MatchPattern reports whether s matches the pattern, unlike in file names * and ? also match /.
+func MatchPattern(pattern, s string) bool {
+	re, err := patternRegexp(pattern)
+	return err == nil && re.MatchString(s)
+}
+
+// conditionParser evaluates the arguments of [[ ]].
+// The operators &&, || and ! only skip evaluation, so a failing operand on the unused side doesn't cause an error.
+type conditionParser struct {
+	c *Command
+	i int
+}
+
+// operator reports whether the next argument is the unquoted operator op.
+func (p *conditionParser) operator(op string) bool {
+	return p.i < len(p.c.Arguments) && p.c.Operators[p.i] && p.c.Arguments[p.i] == op
+}
+
+func (p *conditionParser) or(eval bool) (bool, error) {
+	ok, err := p.and(eval)
+	for err == nil && p.operator("||") {
+		p.i++
+		var right bool
+		right, err = p.and(eval && !ok)
+		ok = ok || right
+	}
+	return ok, err
+}
+
+func (p *conditionParser) and(eval bool) (bool, error) {
+	ok, err := p.not(eval)
+	for err == nil && p.operator("&&") {
+		p.i++
+		var right bool
+		right, err = p.not(eval && ok)
+		ok = ok && right
+	}
+	return ok, err
+}
+
+func (p *conditionParser) not(eval bool) (bool, error) {
+	if p.operator("!") {
+		p.i++
+		ok, err := p.not(eval)
+		return !ok, err
+	}
+	return p.primary(eval)
+}
+
+func (p *conditionParser) primary(eval bool) (bool, error) {
+	args := p.c.Arguments
+	if p.i >= len(args) {
+		return false, errors.New("unexpected end of conditional expression")
+	}
+	if p.operator("(") {
+		p.i++
+		ok, err := p.or(eval)
+		if err != nil {
+			return false, err
+		}
+		if !p.operator(")") {
+			return false, errors.New("`)' expected")
+		}
+		p.i++
+		return ok, nil
+	}
+	if p.i+2 < len(args) && p.c.Operators[p.i+1] && isConditionBinaryOperator(args[p.i+1]) {
+		left, op, right := args[p.i], args[p.i+1], args[p.i+2]
+		p.i += 3
+		if !eval {
+			return false, nil
+		}
+		return conditionBinary(left, op, right)
+	}
+	if p.i+1 < len(args) && p.c.Operators[p.i] && (isUnaryTestOperator(args[p.i]) || args[p.i] == "-v" || args[p.i] == "-o") {
+		op, operand := args[p.i], args[p.i+1]
+		p.i += 2
+		if !eval {
+			return false, nil
+		}
+		switch op {
+		case "-v":
+			_, ok := Variable(operand)
+			return ok, nil
+		case "-o":
+			return Option(operand), nil
+		}
+		return unaryTest(op, operand)
+	}
+	if p.c.Operators[p.i] && args[p.i] != "" && strings.IndexByte("&|()<>", args[p.i][0]) != -1 {
+		return false, fmt.Errorf("syntax error near `%s'", args[p.i])
+	}
+	// a single word is true if it's not empty
+	p.i++
+	return args[p.i-1] != "", nil
+}
+
+func isConditionBinaryOperator(op string) bool {
+	return op == "=~" || isBinaryTestOperator(op)
+}
+
+// conditionBinary evaluates a comparison of [[ ]].
+// The right side of ==, = and != is a pattern and the right side of =~ a regular expression,
+// the operands of integer comparisons are arithmetic expressions.
+func conditionBinary(left, op, right string) (bool, error) {
+	switch op {
+	case "==", "=":
+		return MatchPattern(right, left), nil
+	case "!=":
+		return !MatchPattern(right, left), nil
+	case "=~":
+		re, err := regexp.Compile(right)
+		if err != nil {
+			return false, fmt.Errorf("%s: invalid regular expression: %w", right, err)
+		}
+		match := re.FindStringSubmatch(left)
+		// BASH_REMATCH holds the match and the submatches of the last =~
+		if err := SetArray("BASH_REMATCH", match); err != nil {
+			return false, err
+		}
+		return match != nil, nil
+	case "-eq", "-ne", "-lt", "-le", "-gt", "-ge":
+		a, err := Arithmetic(left)
+		if err != nil {
+			return false, err
+		}
+		b, err := Arithmetic(right)
+		if err != nil {
+			return false, err
+		}
+		switch op {
+		case "-eq":
+			return a == b, nil
+		case "-ne":
+			return a != b, nil
+		case "-lt":
+			return a < b, nil
+		case "-le":
+			return a <= b, nil
+		case "-gt":
+			return a > b, nil
+		default:
+			return a >= b, nil
+		}
+	}
+	return binaryTest(left, op, right)
+}
+
+func execute_condition(c *Command, _ *IoProvider) error {
+	p := conditionParser{c: c}
+	ok, err := p.or(true)
+	if err == nil && p.i < len(c.Arguments) {
+		err = fmt.Errorf("syntax error near `%s'", c.Arguments[p.i])
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(c.Stderr(), "[[: %s\n", err)
+		return errors.Join(fmt.Errorf("[[: %w", err), ExitStatus(2))
+	}
+	if !ok {
+		return ExitStatus(1)
+	}
+	return nil
+}
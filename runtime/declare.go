@@ -0,0 +1,268 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// declareFlags maps the options of declare to the attributes they set (-) or remove (+).
+var declareFlags = map[rune]variableAttributes{
+	'i': attributeInteger,
+	'l': attributeLower,
+	'n': attributeNameref,
+	'r': attributeReadonly,
+	'u': attributeUpper,
+}
+
+// declareOptions are the options of declare, local and readonly.
+type declareOptions struct {
+	set, unset           variableAttributes
+	indexed, associative bool
+	export, unexport     bool
+	print                bool
+}
+
+func execute_declare(c *Command, _ *IoProvider) error {
+	return declare(c, declareOptions{}, false)
+}
+
+func execute_local(c *Command, _ *IoProvider) error {
+	return declare(c, declareOptions{}, true)
+}
+
+func execute_readonly(c *Command, _ *IoProvider) error {
+	return declare(c, declareOptions{set: attributeReadonly}, false)
+}
+
+// declare sets the attributes and values of variables for declare, typeset, local and readonly.
+// Without names it prints the variables that have the given attributes.
+func declare(c *Command, o declareOptions, local bool) error {
+	name := c.Executable
+	fail := func(format string, a ...any) error {
+		msg := name + ": " + fmt.Sprintf(format, a...)
+		_, _ = fmt.Fprintln(c.Stderr(), msg)
+		return errors.New(msg)
+	}
+
+	// options are the arguments that start with - or + in front of the names
+	first := 0
+	for ; first < len(c.Arguments); first++ {
+		arg := c.Arguments[first]
+		if arg == "--" {
+			first++
+			break
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			break
+		}
+		for _, flag := range arg[1:] {
+			switch {
+			case declareFlags[flag] != 0 && arg[0] == '-':
+				o.set |= declareFlags[flag]
+			case declareFlags[flag] != 0 && flag != 'r':
+				o.unset |= declareFlags[flag]
+			case flag == 'a':
+				o.indexed = true
+			case flag == 'A':
+				o.associative = true
+			case flag == 'x':
+				o.export = arg[0] == '-'
+				o.unexport = arg[0] == '+'
+			case flag == 'p' && !local:
+				o.print = true
+			case flag == 'g' && !local:
+				// variables are global unless they are made local
+			default:
+				_, _ = fmt.Fprintf(c.Stderr(), "%s: %c%c: invalid option\n", name, arg[0], flag)
+				return errors.Join(fmt.Errorf("%s: %c%c: invalid option", name, arg[0], flag), ExitStatus(2))
+			}
+		}
+	}
+	args := c.Arguments[first:]
+
+	if len(args) == 0 && !local {
+		// print the variables that have all of the given attributes
+		filter := o.set
+		lines := strings.Builder{}
+		for _, n := range variableNames() {
+			attrs := attributesOf(n)
+			_, exported := os.LookupEnv(n)
+			switch {
+			case attrs&filter != filter,
+				o.export && !exported,
+				o.indexed && kindOf(n) != variableIndexed,
+				o.associative && kindOf(n) != variableAssociative:
+				continue
+			}
+			if line, ok := declaration(n); ok {
+				lines.WriteString(line + "\n")
+			}
+		}
+		_, err := c.Stdout().Write([]byte(lines.String()))
+		return err
+	}
+
+	var status error
+	for i, arg := range args {
+		index := first + i
+		if o.print {
+			line, ok := declaration(arg)
+			if !ok {
+				status = fail("%s: not found", arg)
+				continue
+			}
+			_, _ = fmt.Fprintln(c.Stdout(), line)
+			continue
+		}
+
+		a, isAssignment := ParseAssignment(arg)
+		if !isAssignment {
+			a = Assignment{Name: arg}
+		}
+		if !IsName(a.Name) {
+			status = fail("`%s': not a valid identifier", arg)
+			continue
+		}
+		if local {
+			if err := makeLocal(a.Name); err != nil {
+				status = fail("%s", err)
+				continue
+			}
+		}
+		if elements, ok := c.Arrays[index]; ok {
+			a.Array = elements
+			isAssignment = true
+		}
+
+		// the attributes of a name reference itself are -n and -r, the others apply to the referenced variable
+		target := a.Name
+		if o.set&attributeNameref != 0 || o.unset&attributeNameref != 0 {
+			if isAssignment && !IsName(a.Value) {
+				status = fail("`%s': invalid variable name for name reference", a.Value)
+				continue
+			}
+			if attributesOf(a.Name)&attributeReadonly != 0 {
+				status = fail("%s: %s", a.Name, ErrReadonly)
+				continue
+			}
+			setAttributes(a.Name, 0, attributeNameref)
+		} else {
+			target = resolveName(a.Name)
+		}
+		if attributesOf(target)&attributeReadonly != 0 && (isAssignment || o.set&^attributeReadonly != 0 || o.unset != 0) {
+			status = fail("%s: %s", target, ErrReadonly)
+			continue
+		}
+
+		if o.indexed || o.associative {
+			if err := DeclareArray(target, o.associative); err != nil {
+				status = fail("%s", err)
+				continue
+			}
+		}
+		// readonly is set after the assignment, so declare -r name=value works
+		setAttributes(target, o.set&^(attributeReadonly|attributeNameref), o.unset)
+		if isAssignment {
+			if err := Assign(a); err != nil {
+				status = fail("%s: %s", a.Name, err)
+				continue
+			}
+		}
+		setAttributes(a.Name, o.set&(attributeReadonly|attributeNameref), 0)
+		switch {
+		case o.export:
+			_ = ExportVariable(target)
+		case o.unexport:
+			_ = UnexportVariable(target)
+		}
+	}
+	return status
+}
+
+// variableNames returns the names of all shell and environment variables in sorted order.
+func variableNames() []string {
+	variablesMutex.RLock()
+	names := make([]string, 0, len(variables)+len(attributes))
+	for name := range variables {
+		names = append(names, name)
+	}
+	for name := range attributes {
+		names = append(names, name)
+	}
+	variablesMutex.RUnlock()
+	for _, env := range os.Environ() {
+		if name, _, ok := strings.Cut(env, "="); ok && IsName(name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// declaration returns the declare command that recreates the variable name, like declare -p prints it.
+func declaration(name string) (string, bool) {
+	variablesMutex.RLock()
+	defer variablesMutex.RUnlock()
+	v := variables[name]
+	value, exported := os.LookupEnv(name)
+	attrs := attributes[name]
+	if v == nil && !exported && attrs == 0 {
+		return "", false
+	}
+
+	flags := ""
+	if v != nil && v.kind == variableIndexed {
+		flags += "a"
+	}
+	if v != nil && v.kind == variableAssociative {
+		flags += "A"
+	}
+	for _, flag := range "ilnru" {
+		if attrs&declareFlags[flag] != 0 {
+			flags += string(flag)
+		}
+	}
+	if exported {
+		flags += "x"
+	}
+	if flags == "" {
+		flags = "-"
+	}
+
+	switch {
+	case v == nil && !exported:
+		// the variable has attributes but no value
+		return fmt.Sprintf("declare -%s %s", flags, name), true
+	case v == nil:
+		return fmt.Sprintf("declare -%s %s=%s", flags, name, quoteDeclaration(value)), true
+	case v.kind == variableScalar:
+		return fmt.Sprintf("declare -%s %s=%s", flags, name, quoteDeclaration(v.value)), true
+	}
+	elements := make([]string, 0)
+	for _, k := range v.keys() {
+		element, _ := v.get(k)
+		elements = append(elements, "["+k.key+"]="+quoteDeclaration(element))
+	}
+	if v.kind == variableAssociative {
+		// like bash, the elements of an associative array are followed by a space
+		return fmt.Sprintf("declare -%s %s=(%s )", flags, name, strings.Join(elements, " ")), true
+	}
+	return fmt.Sprintf("declare -%s %s=(%s)", flags, name, strings.Join(elements, " ")), true
+}
+
+// quoteDeclaration quotes a value in double quotes, like declare -p does.
+func quoteDeclaration(s string) string {
+	sb := strings.Builder{}
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte("\\\"$`", s[i]) != -1 {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,533 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	dirsMutex sync.Mutex
+	// logicalDir is the working directory with the symbolic links it was reached through (cd -L), empty until it is known
+	logicalDir string
+	// dirStack holds the directories of pushd below the working directory, which is the top of the stack
+	dirStack = make([]string, 0)
+)
+
+// WorkingDirectory returns the logical working directory, like pwd -L prints it.
+// It falls back to the physical directory if the logical one no longer refers to it.
+func WorkingDirectory() (string, error) {
+	dirsMutex.Lock()
+	defer dirsMutex.Unlock()
+	return workingDirectory()
+}
+
+// workingDirectory is WorkingDirectory, dirsMutex must be held.
+func workingDirectory() (string, error) {
+	physical, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	candidate := logicalDir
+	if candidate == "" {
+		// a shell that was started from another shell inherits its logical directory
+		candidate = os.Getenv("PWD")
+	}
+	if candidate != "" && filepath.IsAbs(candidate) && sameFile(candidate, physical) {
+		logicalDir = candidate
+		return candidate, nil
+	}
+	logicalDir = physical
+	return physical, nil
+}
+
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+// changeDirectory changes the working directory to dir and updates PWD and OLDPWD, dirsMutex must be held.
+// With physical the symbolic links in dir are resolved, otherwise .. removes the last component of the logical path.
+func changeDirectory(dir string, physical bool) error {
+	old, err := workingDirectory()
+	if err != nil {
+		return err
+	}
+	var target string
+	if physical {
+		// the symbolic links are followed before .. is applied, like chdir does it
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+		if target, err = os.Getwd(); err != nil {
+			return err
+		}
+		if target, err = filepath.EvalSymlinks(target); err != nil {
+			return err
+		}
+	} else {
+		target = dir
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(old, target)
+		}
+		target = filepath.Clean(target)
+		if err := os.Chdir(target); err != nil {
+			// like bash, fall back to the physical path if the logical one doesn't exist
+			if err := os.Chdir(dir); err != nil {
+				return err
+			}
+			if target, err = os.Getwd(); err != nil {
+				return err
+			}
+		}
+	}
+	logicalDir = target
+	return errors.Join(
+		setDirectoryVariable("OLDPWD", old),
+		setDirectoryVariable("PWD", target),
+	)
+}
+
+func setDirectoryVariable(name, value string) error {
+	if err := Assign(Assignment{Name: name, Value: value}); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return ExportVariable(name)
+}
+
+// homeDirectory returns $HOME, or the home directory of the user if it isn't set.
+func homeDirectory() (string, error) {
+	if home, ok := Variable("HOME"); ok && home != "" {
+		return home, nil
+	}
+	return os.UserHomeDir()
+}
+
+// expandHome replaces a leading ~ in path with the home directory.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path, nil
+	}
+	home, err := homeDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// abbreviateHome replaces the home directory at the start of dir with ~, like dirs prints it.
+func abbreviateHome(dir string) string {
+	home, err := homeDirectory()
+	if err != nil || home == "" {
+		return dir
+	}
+	home = filepath.Clean(home)
+	if dir == home {
+		return "~"
+	}
+	if rest, ok := strings.CutPrefix(dir, home); ok && strings.HasPrefix(rest, string(filepath.Separator)) {
+		return "~" + rest
+	}
+	return dir
+}
+
+// physicalOption parses the -L and -P options of cd, pushd and pwd in front of the other arguments.
+// The last one wins, -L is the default.
+func physicalOption(c *Command) (physical bool, args []string, err error) {
+	args = c.Arguments
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && !isStackIndex(args[0]) {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, flag := range arg[1:] {
+			switch flag {
+			case 'L':
+				physical = false
+			case 'P':
+				physical = true
+			default:
+				_, _ = fmt.Fprintf(c.Stderr(), "%s: -%c: invalid option\n", c.Executable, flag)
+				return false, nil, errors.Join(fmt.Errorf("%s: -%c: invalid option", c.Executable, flag), ExitStatus(2))
+			}
+		}
+	}
+	return physical, args, nil
+}
+
+func execute_cd(c *Command, _ *IoProvider) error {
+	physical, args, err := physicalOption(c)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		_, _ = fmt.Fprintf(c.Stderr(), "cd: %s\n", err)
+		return errors.Join(errors.New("cd: failed to change directory"), err)
+	}
+
+	var dir string
+	printDir := false
+	switch len(args) {
+	case 0:
+		if dir, err = homeDirectory(); err != nil {
+			return fail(errors.Join(errors.New("failed to get home directory"), err))
+		}
+	case 1:
+		dir = args[0]
+	default:
+		_, _ = fmt.Fprintln(c.Stderr(), "cd: too many arguments")
+		return errors.New("cd: too many arguments")
+	}
+
+	switch {
+	case dir == "-":
+		old, ok := Variable("OLDPWD")
+		if !ok || old == "" {
+			return fail(errors.New("OLDPWD not set"))
+		}
+		dir = old
+		printDir = true
+	case strings.HasPrefix(dir, "~"):
+		if dir, err = expandHome(dir); err != nil {
+			return fail(errors.Join(errors.New("failed to get home directory"), err))
+		}
+	default:
+		// a relative directory is looked up in the directories of CDPATH first
+		if found, ok := searchCdPath(dir); ok {
+			dir = found
+			printDir = true
+		}
+	}
+
+	dirsMutex.Lock()
+	defer dirsMutex.Unlock()
+	if err := changeDirectory(dir, physical); err != nil {
+		return fail(err)
+	}
+	if printDir {
+		_, _ = fmt.Fprintln(c.Stdout(), logicalDir)
+	}
+	return nil
+}
+
+// searchCdPath looks up dir in the directories of CDPATH.
+// It reports whether it was found in one of them other than the working directory, cd prints the new directory then.
+func searchCdPath(dir string) (string, bool) {
+	if filepath.IsAbs(dir) || dir == "." || dir == ".." ||
+		strings.HasPrefix(dir, "./") || strings.HasPrefix(dir, "../") {
+		return dir, false
+	}
+	cdPath, ok := Variable("CDPATH")
+	if !ok || cdPath == "" {
+		return dir, false
+	}
+	for _, base := range filepath.SplitList(cdPath) {
+		if base == "" || base == "." {
+			// an empty entry is the working directory
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				return dir, false
+			}
+			continue
+		}
+		candidate := filepath.Join(base, dir)
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate, true
+		}
+	}
+	return dir, false
+}
+
+func execute_pwd(c *Command, _ *IoProvider) error {
+	physical, _, err := physicalOption(c)
+	if err != nil {
+		return err
+	}
+	var wd string
+	if physical {
+		if wd, err = os.Getwd(); err == nil {
+			wd, err = filepath.EvalSymlinks(wd)
+		}
+	} else {
+		wd, err = WorkingDirectory()
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(c.Stderr(), "pwd: ", err)
+		return errors.Join(errors.New("pwd: failed to get working directory"), err)
+	}
+	_, _ = fmt.Fprintln(c.Stdout(), wd)
+	return nil
+}
+
+// isStackIndex reports whether arg is +n or -n, an entry of the directory stack.
+func isStackIndex(arg string) bool {
+	if len(arg) < 2 || (arg[0] != '+' && arg[0] != '-') {
+		return false
+	}
+	_, err := strconv.Atoi(arg[1:])
+	return err == nil
+}
+
+// stackIndex returns the position of +n (from the top) or -n (from the bottom) in a stack of size entries.
+func stackIndex(arg string, size int) (int, bool) {
+	n, err := strconv.Atoi(arg[1:])
+	if err != nil || n < 0 || n >= size {
+		return 0, false
+	}
+	if arg[0] == '-' {
+		return size - 1 - n, true
+	}
+	return n, true
+}
+
+// fullStack returns the directory stack with the working directory on top, dirsMutex must be held.
+func fullStack() ([]string, error) {
+	wd, err := workingDirectory()
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{wd}, dirStack...), nil
+}
+
+// stackOptions parses the -n option of pushd and popd and the +n/-n entry argument.
+func stackOptions(c *Command) (noChange bool, args []string, err error) {
+	args = c.Arguments
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && !isStackIndex(args[0]) {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, flag := range arg[1:] {
+			if flag != 'n' {
+				_, _ = fmt.Fprintf(c.Stderr(), "%s: -%c: invalid option\n", c.Executable, flag)
+				return false, nil, errors.Join(fmt.Errorf("%s: -%c: invalid option", c.Executable, flag), ExitStatus(2))
+			}
+			noChange = true
+		}
+	}
+	if len(args) > 1 {
+		_, _ = fmt.Fprintf(c.Stderr(), "%s: too many arguments\n", c.Executable)
+		return false, nil, errors.Join(fmt.Errorf("%s: too many arguments", c.Executable), ExitStatus(2))
+	}
+	return noChange, args, nil
+}
+
+func execute_pushd(c *Command, _ *IoProvider) error {
+	noChange, args, err := stackOptions(c)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		_, _ = fmt.Fprintf(c.Stderr(), "pushd: %s\n", err)
+		return errors.Join(errors.New("pushd: failed to change the directory stack"), err)
+	}
+
+	dirsMutex.Lock()
+	defer dirsMutex.Unlock()
+	stack, err := fullStack()
+	if err != nil {
+		return fail(err)
+	}
+
+	switch {
+	case len(args) == 0 && noChange:
+		// nothing to do without changing the directory
+	case len(args) == 0:
+		// exchange the top two directories
+		if len(stack) < 2 {
+			return fail(errors.New("no other directory"))
+		}
+		stack[0], stack[1] = stack[1], stack[0]
+	case isStackIndex(args[0]):
+		i, ok := stackIndex(args[0], len(stack))
+		if !ok {
+			return fail(fmt.Errorf("%s: directory stack index out of range", args[0][1:]))
+		}
+		if noChange {
+			// the working directory stays on top, the entry moves below it
+			if i > 0 {
+				rest := stack[1:]
+				stack = append(stack[:1], append(rest[i-1:], rest[:i-1]...)...)
+			}
+			break
+		}
+		// rotate the stack so that the entry is on top
+		stack = append(stack[i:], stack[:i]...)
+	default:
+		dir, err := expandHome(args[0])
+		if err != nil {
+			return fail(err)
+		}
+		if noChange {
+			// the directory is added below the top and the working directory stays
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(stack[0], dir)
+			}
+			stack = append([]string{stack[0], filepath.Clean(dir)}, stack[1:]...)
+			break
+		}
+		if err := changeDirectory(dir, false); err != nil {
+			return fail(err)
+		}
+		stack = append([]string{logicalDir}, stack...)
+	}
+
+	if stack[0] != logicalDir {
+		if err := changeDirectory(stack[0], false); err != nil {
+			return fail(err)
+		}
+		stack[0] = logicalDir
+	}
+	dirStack = stack[1:]
+	return printStack(c, stack, false, false, false)
+}
+
+func execute_popd(c *Command, _ *IoProvider) error {
+	noChange, args, err := stackOptions(c)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		_, _ = fmt.Fprintf(c.Stderr(), "popd: %s\n", err)
+		return errors.Join(errors.New("popd: failed to change the directory stack"), err)
+	}
+
+	dirsMutex.Lock()
+	defer dirsMutex.Unlock()
+	stack, err := fullStack()
+	if err != nil {
+		return fail(err)
+	}
+	if len(stack) < 2 {
+		return fail(errors.New("directory stack empty"))
+	}
+
+	i := 0
+	if len(args) == 1 {
+		if !isStackIndex(args[0]) {
+			return fail(fmt.Errorf("%s: invalid argument", args[0]))
+		}
+		var ok bool
+		if i, ok = stackIndex(args[0], len(stack)); !ok {
+			return fail(fmt.Errorf("%s: directory stack index out of range", args[0][1:]))
+		}
+	}
+
+	if i == 0 && noChange {
+		// the working directory stays on top, the entry below it is removed
+		i = 1
+	}
+	stack = append(stack[:i], stack[i+1:]...)
+	if i == 0 {
+		if err := changeDirectory(stack[0], false); err != nil {
+			return fail(err)
+		}
+	}
+	stack[0] = logicalDir
+	dirStack = stack[1:]
+	return printStack(c, stack, false, false, false)
+}
+
+func execute_dirs(c *Command, _ *IoProvider) error {
+	var long, perLine, verbose, clear bool
+	args := c.Arguments
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && !isStackIndex(args[0]) {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, flag := range arg[1:] {
+			switch flag {
+			case 'c':
+				clear = true
+			case 'l':
+				long = true
+			case 'p':
+				perLine = true
+			case 'v':
+				verbose = true
+			default:
+				_, _ = fmt.Fprintf(c.Stderr(), "dirs: -%c: invalid option\n", flag)
+				return errors.Join(fmt.Errorf("dirs: -%c: invalid option", flag), ExitStatus(2))
+			}
+		}
+	}
+
+	dirsMutex.Lock()
+	defer dirsMutex.Unlock()
+	if clear {
+		dirStack = dirStack[:0]
+		return nil
+	}
+	stack, err := fullStack()
+	if err != nil {
+		_, _ = fmt.Fprintf(c.Stderr(), "dirs: %s\n", err)
+		return errors.Join(errors.New("dirs: failed to get working directory"), err)
+	}
+	switch len(args) {
+	case 0:
+		return printStack(c, stack, long, perLine, verbose)
+	case 1:
+		i, ok := 0, false
+		if isStackIndex(args[0]) {
+			i, ok = stackIndex(args[0], len(stack))
+		}
+		if !ok {
+			_, _ = fmt.Fprintf(c.Stderr(), "dirs: %s: directory stack index out of range\n", args[0])
+			return errors.Join(fmt.Errorf("dirs: %s: directory stack index out of range", args[0]), ExitStatus(1))
+		}
+		if verbose {
+			// the index is the one of the full listing
+			_, _ = fmt.Fprintf(c.Stdout(), "%2d  %s\n", i, displayDir(stack[i], long))
+			return nil
+		}
+		_, _ = fmt.Fprintln(c.Stdout(), displayDir(stack[i], long))
+		return nil
+	default:
+		_, _ = fmt.Fprintln(c.Stderr(), "dirs: too many arguments")
+		return errors.Join(errors.New("dirs: too many arguments"), ExitStatus(1))
+	}
+}
+
+func displayDir(dir string, long bool) string {
+	if long {
+		return dir
+	}
+	return abbreviateHome(dir)
+}
+
+// printStack prints the directory stack like dirs does, with -l, -p and -v.
+func printStack(c *Command, stack []string, long, perLine, verbose bool) error {
+	sb := strings.Builder{}
+	for i, dir := range stack {
+		switch {
+		case verbose:
+			sb.WriteString(fmt.Sprintf("%2d  %s\n", i, displayDir(dir, long)))
+		case perLine:
+			sb.WriteString(displayDir(dir, long) + "\n")
+		default:
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(displayDir(dir, long))
+		}
+	}
+	if !verbose && !perLine {
+		sb.WriteByte('\n')
+	}
+	_, err := c.Stdout().Write([]byte(sb.String()))
+	return err
+}
@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStackIndex(t *testing.T) {
+	cases := []struct {
+		arg     string
+		isIndex bool
+		index   int
+		ok      bool
+	}{
+		{arg: "+0", isIndex: true, index: 0, ok: true},
+		{arg: "+2", isIndex: true, index: 2, ok: true},
+		{arg: "-0", isIndex: true, index: 2, ok: true},
+		{arg: "-2", isIndex: true, index: 0, ok: true},
+		{arg: "+3", isIndex: true, ok: false},
+		{arg: "-3", isIndex: true, ok: false},
+		{arg: "+-1", isIndex: true, ok: false},
+		{arg: "-L", isIndex: false},
+		{arg: "+", isIndex: false},
+		{arg: "dir", isIndex: false},
+	}
+	for _, c := range cases {
+		t.Run(c.arg, func(t *testing.T) {
+			if isStackIndex(c.arg) != c.isIndex {
+				t.Fatalf("isStackIndex: %v, expected: %v", !c.isIndex, c.isIndex)
+			}
+			if !c.isIndex {
+				return
+			}
+			// a stack of 3 entries
+			index, ok := stackIndex(c.arg, 3)
+			if ok != c.ok || ok && index != c.index {
+				t.Errorf("stackIndex: %d, %v, expected: %d, %v", index, ok, c.index, c.ok)
+			}
+		})
+	}
+}
+
+func TestHomeDirectoryPaths(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "home")
+	if old, ok := Variable("HOME"); ok {
+		defer func() { _ = Assign(Assignment{Name: "HOME", Value: old}) }()
+	} else {
+		defer func() { _ = UnsetVariable("HOME") }()
+	}
+	if err := Assign(Assignment{Name: "HOME", Value: home + string(filepath.Separator)}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path        string
+		abbreviated string
+	}{
+		{path: home, abbreviated: "~"},
+		{path: filepath.Join(home, "src"), abbreviated: filepath.Join("~", "src")},
+		{path: home + "other", abbreviated: home + "other"},
+		{path: filepath.Dir(home), abbreviated: filepath.Dir(home)},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			abbreviated := abbreviateHome(c.path)
+			if abbreviated != c.abbreviated {
+				t.Fatalf("abbreviateHome: %q, expected: %q", abbreviated, c.abbreviated)
+			}
+			expanded, err := expandHome(abbreviated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expanded != c.path {
+				t.Errorf("expandHome: %q, expected: %q", expanded, c.path)
+			}
+		})
+	}
+	if expanded, _ := expandHome("~user/x"); expanded != "~user/x" {
+		t.Errorf("expandHome: %q, expected ~user/x to stay", expanded)
+	}
+}
@@ -15,50 +15,68 @@ import (
 	"github.com/tsukinoko-kun/ohmygosh/iohelper"
 )
 
-func execute_cd(c *Command, _ *IoProvider) error {
-	switch len(c.Arguments) {
-	case 0:
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return errors.Join(errors.New("cd: failed to get home directory"), err)
-		}
-		return os.Chdir(home)
-	case 1:
-		path := c.Arguments[0]
-		if strings.HasPrefix(path, "~") {
-			if home, err := os.UserHomeDir(); err == nil {
-				path = filepath.Join(home, path[1:])
-			} else {
-				return errors.Join(errors.New("cd: failed to get home directory"), err)
-			}
-		}
-		return os.Chdir(path)
-	default:
-		_, _ = fmt.Fprintln(**c.Stderr, "cd: too many arguments")
-		return errors.New("cd: too many arguments")
-	}
-}
-
 func execute_exit(c *Command, _ *IoProvider) error {
 	switch len(c.Arguments) {
 	case 0:
-		os.Exit(0)
+		// the status of the last command
+		Exit(Status())
 	case 1:
 		code, err := strconv.Atoi(c.Arguments[0])
 		if err != nil {
-			_, _ = fmt.Fprintln(**c.Stderr, "exit: ", err)
+			_, _ = fmt.Fprintln(c.Stderr(), "exit: ", err)
 			return errors.Join(fmt.Errorf("exit: failed to parse argument %q as an integer", c.Arguments[0]), err)
 		}
-		os.Exit(code)
+		Exit(code)
 	default:
-		_, _ = fmt.Fprintln(**c.Stderr, "exit: too many arguments")
+		_, _ = fmt.Fprintln(c.Stderr(), "exit: too many arguments")
 		return errors.New("exit: too many arguments")
 	}
 	return nil
 }
 
+func execute_exec(c *Command, iop *IoProvider) error {
+	if len(c.Arguments) == 0 {
+		// without a command, the redirections become the defaults for all following commands.
+		// Duplicates of inherited descriptors are resolved before any default changes, so exec 3>&1 1>&2 2>&3 swaps them.
+		fds := make(map[int]*FileDescriptor, len(c.Fds))
+		for n := range c.Fds {
+			fds[n] = c.Fd(n)
+		}
+		for n, fd := range fds {
+			iop.setFd(n, fd)
+			if fd != nil && fd.Writer != nil {
+				iop.Closer.RemoveE(fd.Writer)
+			}
+			if fd != nil && fd.Reader != nil {
+				if rc, ok := fd.Reader.(io.ReadCloser); ok {
+					iop.Closer.RemoveE(rc)
+				}
+			}
+		}
+		// the streams are owned by the IoProvider now
+		c.Fds = FdTable{}
+		return nil
+	}
+	replacement := &Command{
+		// FOO=1 exec cmd passes FOO to cmd
+		Assignments: c.Assignments,
+		Executable:  c.Arguments[0],
+		Arguments:   c.Arguments[1:],
+		PipeIn:      c.PipeIn,
+		PipeOut:     c.PipeOut,
+		Fds:         c.Fds,
+		iop:         c.iop,
+	}
+	return replaceProcess(replacement, iop)
+}
+
 func execute_echo(c *Command, _ *IoProvider) error {
-	_, _ = fmt.Fprintln(**c.Stdout, strings.Join(c.Arguments, " "))
+	if _, err := fmt.Fprintln(c.Stdout(), strings.Join(c.Arguments, " ")); isBrokenPipe(err) {
+		return brokenPipeStatus
+	} else if err != nil {
+		_, _ = fmt.Fprintln(c.Stderr(), "echo: write error:", err)
+		return errors.Join(errors.New("echo: write error"), err)
+	}
 	return nil
 }
 
@@ -70,13 +88,19 @@ func execute_cat(c *Command, iop *IoProvider) error {
 			if err != nil {
 				return errors.Join(fmt.Errorf("cat: failed to open file %q", arg), err)
 			}
-			_, err = io.Copy(**c.Stdout, r)
+			_, err = io.Copy(c.Stdout(), r)
+			if closer, ok := r.(io.Closer); ok {
+				iop.Closer.CloseE(closer)
+			}
+			if isBrokenPipe(err) {
+				return brokenPipeStatus
+			}
 			if err != nil {
 				return errors.Join(fmt.Errorf("cat: failed to read file %q", arg), err)
 			}
 		}
 	} else {
-		r := io.TeeReader(**c.Stdin, **c.Stdout)
+		r := io.TeeReader(c.Stdin(), c.Stdout())
 		// read from r until EOF
 		for {
 			buf := make([]byte, 1024)
@@ -85,7 +109,10 @@ func execute_cat(c *Command, iop *IoProvider) error {
 				if err == io.EOF {
 					break
 				}
-				_, _ = fmt.Fprintln(**c.Stderr, "cat: ", err)
+				if isBrokenPipe(err) {
+					return brokenPipeStatus
+				}
+				_, _ = fmt.Fprintln(c.Stderr(), "cat: ", err)
 				return errors.Join(errors.New("cat: failed to read from stdin"), err)
 			}
 		}
@@ -99,50 +126,168 @@ func execute_export(c *Command, _ *IoProvider) error {
 			pair := os.ExpandEnv(arg)
 			if pair != "" {
 				kv := strings.Split(pair, "=")
+				// move a shell variable into the environment, or create an empty one if it does not exist
+				_ = ExportVariable(kv[0])
 				if len(kv) >= 2 {
-					os.Setenv(kv[0], strings.Join(kv[1:], "="))
-				} else {
-					// does this variable exist?
-					if _, ok := os.LookupEnv(kv[0]); !ok {
-						// if not, set it to an empty string
-						os.Setenv(kv[0], "")
+					if err := Assign(Assignment{Name: kv[0], Value: strings.Join(kv[1:], "=")}); err != nil {
+						_, _ = fmt.Fprintf(c.Stderr(), "export: %s: %s\n", kv[0], err)
+						return errors.Join(fmt.Errorf("export: failed to assign %s", kv[0]), err)
 					}
 				}
 			}
 		}
 	} else {
 		for _, env := range os.Environ() {
-			_, _ = fmt.Fprintf(**c.Stdout, "declare -x %s\n", env)
+			_, _ = fmt.Fprintf(c.Stdout(), "declare -x %s\n", env)
 		}
 	}
 	return nil
 }
 
 func execute_unset(c *Command, _ *IoProvider) error {
-	if len(c.Arguments) > 0 {
-		for _, arg := range c.Arguments {
-			os.Unsetenv(arg)
+	nameref := false
+	var status error
+	for _, arg := range c.Arguments {
+		if arg == "-v" {
+			continue
+		}
+		if arg == "-n" {
+			// unset -n removes a name reference itself instead of the variable it refers to
+			nameref = true
+			continue
+		}
+		if name, key, ok := strings.Cut(arg, "["); ok && strings.HasSuffix(key, "]") {
+			// unset 'name[key]' removes a single element of an array
+			if err := UnsetVariableElement(name, key[:len(key)-1]); err != nil {
+				_, _ = fmt.Fprintf(c.Stderr(), "unset: %s: %s\n", arg, err)
+				return errors.Join(fmt.Errorf("unset: %s", arg), err)
+			}
+			continue
+		}
+		unset := UnsetVariable
+		if nameref {
+			unset = UnsetNameref
+		}
+		if err := unset(arg); err != nil {
+			_, _ = fmt.Fprintf(c.Stderr(), "unset: %s\n", err)
+			status = errors.Join(fmt.Errorf("unset: %s", arg), err)
+		}
+	}
+	return status
+}
+
+func execute_set(c *Command, _ *IoProvider) error {
+	if len(c.Arguments) == 0 {
+		printOptions(c.Stdout(), false)
+		return nil
+	}
+	for i := 0; i < len(c.Arguments); i++ {
+		arg := c.Arguments[i]
+		if arg == "--" {
+			SetPositionalParameters(c.Arguments[i+1:])
+			return nil
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			// the remaining arguments are the new positional parameters
+			SetPositionalParameters(c.Arguments[i:])
+			return nil
+		}
+		value := arg[0] == '-'
+		for j := 1; j < len(arg); j++ {
+			if arg[j] == 'o' {
+				// the option name is the next argument, like in set -euo pipefail
+				if i+1 == len(c.Arguments) {
+					// set -o prints the options in a human readable form, set +o as commands
+					printOptions(c.Stdout(), !value)
+					continue
+				}
+				i++
+				if err := SetOption(c.Arguments[i], value); err != nil {
+					_, _ = fmt.Fprintln(c.Stderr(), "set:", err)
+					return errors.Join(errors.New("set: failed to set option"), err)
+				}
+				continue
+			}
+			name, ok := optionByFlag(arg[j])
+			if !ok {
+				_, _ = fmt.Fprintf(c.Stderr(), "set: %c%c: invalid option\n", arg[0], arg[j])
+				return fmt.Errorf("set: %c%c: invalid option", arg[0], arg[j])
+			}
+			_ = SetOption(name, value)
 		}
 	}
 	return nil
 }
 
-func execute_whoami(c *Command, _ *IoProvider) error {
-	if u, err := user.Current(); err == nil {
-		_, _ = fmt.Fprintln(**c.Stdout, u.Username)
-	} else {
-		_, _ = fmt.Fprintln(**c.Stderr, "whoami: ", err)
-		return errors.Join(errors.New("whoami: failed to get current user"), err)
+func execute_shift(c *Command, _ *IoProvider) error {
+	n := 1
+	switch len(c.Arguments) {
+	case 0:
+	case 1:
+		var err error
+		if n, err = strconv.Atoi(c.Arguments[0]); err != nil || n < 0 {
+			_, _ = fmt.Fprintf(c.Stderr(), "shift: %s: numeric argument required\n", c.Arguments[0])
+			return fmt.Errorf("shift: %s: numeric argument required", c.Arguments[0])
+		}
+	default:
+		_, _ = fmt.Fprintln(c.Stderr(), "shift: too many arguments")
+		return errors.New("shift: too many arguments")
+	}
+	params := PositionalParameters()
+	if n > len(params) {
+		// like in bash, the parameters stay untouched
+		return ExitStatus(1)
 	}
+	SetPositionalParameters(params[n:])
 	return nil
 }
 
-func execute_pwd(c *Command, _ *IoProvider) error {
-	if wd, err := os.Getwd(); err == nil {
-		_, _ = fmt.Fprintln(**c.Stdout, wd)
+func execute_eval(c *Command, iop *IoProvider) error {
+	// the arguments are joined and run like a line of the shell, with the redirections of eval
+	text := strings.Join(c.Arguments, " ")
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	return syntaxErrorStatus(c, c.Executable, Interpret(text, c.ioProvider(iop)))
+}
+
+// syntaxErrorStatus prints a syntax error of the code that eval or source ran, prefixed with name,
+// and turns it into status 2 like bash. Other results are returned unchanged.
+func syntaxErrorStatus(c *Command, name string, err error) error {
+	var syntaxErr SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.Stderr(), "%s: %s\n", name, syntaxErr)
+	// the message is not wrapped, an eval around this one must not report it again
+	return errors.Join(fmt.Errorf("%s: %s", name, syntaxErr), ExitStatus(2))
+}
+
+func printOptions(w io.Writer, asCommands bool) {
+	for _, o := range shellOptions {
+		value := Option(o.name)
+		if asCommands {
+			sign := '+'
+			if value {
+				sign = '-'
+			}
+			_, _ = fmt.Fprintf(w, "set %co %s\n", sign, o.name)
+		} else {
+			state := "off"
+			if value {
+				state = "on"
+			}
+			_, _ = fmt.Fprintf(w, "%-15s\t%s\n", o.name, state)
+		}
+	}
+}
+
+func execute_whoami(c *Command, _ *IoProvider) error {
+	if u, err := user.Current(); err == nil {
+		_, _ = fmt.Fprintln(c.Stdout(), u.Username)
 	} else {
-		_, _ = fmt.Fprintln(**c.Stderr, "pwd: ", err)
-		return errors.Join(errors.New("pwd: failed to get working directory"), err)
+		_, _ = fmt.Fprintln(c.Stderr(), "whoami: ", err)
+		return errors.Join(errors.New("whoami: failed to get current user"), err)
 	}
 	return nil
 }
@@ -168,18 +313,18 @@ func execute_type(c *Command, _ *IoProvider) error {
 	if len(c.Arguments) != 0 {
 		for _, arg := range c.Arguments {
 			if _, ok := BuiltinCommands[arg]; ok {
-				_, _ = fmt.Fprintf(**c.Stdout, "%s is a builtin\n", arg)
+				_, _ = fmt.Fprintf(c.Stdout(), "%s is a builtin\n", arg)
 				continue
 			}
 			foundBinaries := findExecutable(arg, false)
 			if len(foundBinaries) != 0 {
-				_, _ = fmt.Fprintf(**c.Stdout, "%s is %s\n", arg, foundBinaries[0])
+				_, _ = fmt.Fprintf(c.Stdout(), "%s is %s\n", arg, foundBinaries[0])
 			} else {
-				_, _ = fmt.Fprintf(**c.Stderr, "type: could not find %s\n", arg)
+				_, _ = fmt.Fprintf(c.Stderr(), "type: could not find %s\n", arg)
 			}
 		}
 	} else {
-		_, _ = fmt.Fprintln(**c.Stderr, "type: missing arguments")
+		_, _ = fmt.Fprintln(c.Stderr(), "type: missing arguments")
 		return errors.New("type: missing arguments")
 	}
 	return nil
@@ -188,7 +333,7 @@ func execute_type(c *Command, _ *IoProvider) error {
 func execute_which(c *Command, _ *IoProvider) error {
 	if len(c.Arguments) != 0 {
 		fs := flag.NewFlagSet("which", flag.ContinueOnError)
-		fs.SetOutput(**c.Stderr)
+		fs.SetOutput(c.Stderr())
 		all := fs.Bool("a", false, "List all instances of executables found (instead of just the first one).")
 		silent := fs.Bool("s", false, "Do not print anything, only return an exit status.")
 		if err := fs.Parse(c.Arguments); err != nil {
@@ -200,7 +345,7 @@ func execute_which(c *Command, _ *IoProvider) error {
 			if len(foundBinaries) != 0 {
 				if !*silent {
 					for _, exe := range foundBinaries {
-						_, _ = fmt.Fprintln(**c.Stdout, exe)
+						_, _ = fmt.Fprintln(c.Stdout(), exe)
 					}
 				}
 			} else {
@@ -208,22 +353,29 @@ func execute_which(c *Command, _ *IoProvider) error {
 			}
 		}
 	} else {
-		_, _ = fmt.Fprintln(**c.Stderr, "which: missing arguments")
+		_, _ = fmt.Fprintln(c.Stderr(), "which: missing arguments")
 		return errors.New("which: missing arguments")
 	}
 	return nil
 }
 
 func execute_yes(c *Command, _ *IoProvider) error {
-	if len(c.Arguments) == 0 {
-		for {
-			_, _ = fmt.Fprintln(**c.Stdout, "y")
-			<-time.After(200 * time.Millisecond)
+	line := "y"
+	if len(c.Arguments) != 0 {
+		line = strings.Join(c.Arguments, " ")
+	}
+	for {
+		if _, err := fmt.Fprintln(c.Stdout(), line); err != nil {
+			if isBrokenPipe(err) {
+				return brokenPipeStatus
+			}
+			_, _ = fmt.Fprintln(c.Stderr(), "yes: write error:", err)
+			return errors.Join(errors.New("yes: write error"), err)
 		}
-	} else {
-		for {
-			_, _ = fmt.Fprintln(**c.Stdout, strings.Join(c.Arguments, " "))
-			<-time.After(200 * time.Millisecond)
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-c.interrupted():
+			return c.interruptStatus()
 		}
 	}
 }
@@ -252,6 +404,10 @@ func execute_sleep(c *Command, _ *IoProvider) error {
 			return errors.Join(fmt.Errorf("sleep: failed to parse argument %q as a duration", c.Arguments[0]), err)
 		}
 	}
-	<-time.After(d)
-	return nil
+	select {
+	case <-time.After(d):
+		return nil
+	case <-c.interrupted():
+		return c.interruptStatus()
+	}
 }
@@ -0,0 +1,64 @@
+package runtime
+
+import (
+	"testing"
+)
+
+func TestExecRedirections(t *testing.T) {
+	cases := []struct {
+		name string
+		// redirect applies the redirections of exec in source order
+		redirect func(FdTable)
+		stdout   string
+		stderr   string
+	}{
+		{
+			name: "exec 3>&1 1>&2 2>&3",
+			redirect: func(fds FdTable) {
+				fds.Dup(3, 1)
+				fds.Dup(1, 2)
+				fds.Dup(2, 3)
+			},
+			stdout: "2\n3\n",
+			stderr: "1\n",
+		},
+		{
+			name: "exec 2>&1 1>&-",
+			redirect: func(fds FdTable) {
+				fds.Dup(2, 1)
+				fds.Close(1)
+			},
+			stdout: "2\n",
+		},
+		{
+			name: "exec 1>&2 2>&1",
+			redirect: func(fds FdTable) {
+				fds.Dup(1, 2)
+				fds.Dup(2, 1)
+			},
+			stderr: "1\n2\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// the map order of the redirections must not matter
+			for range 20 {
+				iop, stdout, stderr := TestIoProvider("")
+				exec := NewCommand(iop)
+				exec.Executable = "exec"
+				c.redirect(exec.Fds)
+				if err := execute_exec(exec, iop); err != nil {
+					t.Fatal(err)
+				}
+				for n := 1; n <= 3; n++ {
+					if fd := iop.Fd(n); fd != nil && fd.Writer != nil {
+						_, _ = fd.Writer.Write([]byte{byte('0' + n), '\n'})
+					}
+				}
+				if stdout.String() != c.stdout || stderr.String() != c.stderr {
+					t.Fatalf("stdout: %q, stderr: %q, expected: %q and %q", stdout.String(), stderr.String(), c.stdout, c.stderr)
+				}
+			}
+		})
+	}
+}
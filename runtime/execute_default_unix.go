@@ -5,21 +5,32 @@ package runtime
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 func Execute_default(c *Command, _ *IoProvider) error {
 	cmd := &exec.Cmd{
-		Stdin:     **c.Stdin,
-		Stdout:    **c.Stdout,
-		Stderr:    **c.Stderr,
+		Stdin:     c.Stdin(),
+		Stdout:    c.Stdout(),
+		Stderr:    c.Stderr(),
 		WaitDelay: 5 * time.Second,
 	}
+	if len(c.Assignments) != 0 {
+		cmd.Env = c.environ()
+	}
 
-	if exe, err := exec.LookPath(c.Executable); err == nil {
+	if shell, args, ok := c.shellScript(); ok {
+		// shell scripts run with ohmygosh
+		cmd.Path = shell
+		cmd.Args = args
+	} else if exe, err := exec.LookPath(c.Executable); err == nil {
 		cmd.Path = exe
 		cmd.Args = append([]string{exe}, c.Arguments...)
 	} else {
@@ -32,9 +43,24 @@ func Execute_default(c *Command, _ *IoProvider) error {
 		}
 	}
 
-	err := cmd.Run()
+	extraFiles, cleanup, err := c.extraFiles()
 	if err != nil {
-		_, _ = fmt.Fprintf(**c.Stderr, "%s: failed to execute command: %s\n", filepath.Base(cmd.Path), err)
+		_, _ = fmt.Fprintf(c.Stderr(), "%s: %s\n", c.Executable, err)
+		return errors.Join(fmt.Errorf("failed to execute command %q", c.String()), err)
+	}
+	defer cleanup()
+	cmd.ExtraFiles = extraFiles
+
+	err = c.run(cmd)
+	c.processState = cmd.ProcessState
+	if err != nil {
+		var exitErr *exec.ExitError
+		var exitStatus ExitStatus
+		if errors.As(err, &exitErr) || errors.As(err, &exitStatus) {
+			// the program reported its failure itself or its job was killed before it started
+			return errors.Join(fmt.Errorf("failed to execute command %q", c.String()), err)
+		}
+		_, _ = fmt.Fprintf(c.Stderr(), "%s: failed to execute command: %s\n", filepath.Base(cmd.Path), err)
 		return errors.Join(fmt.Errorf("failed to execute command %q", c.String()), err)
 	}
 	return nil
@@ -43,25 +69,126 @@ func Execute_default(c *Command, _ *IoProvider) error {
 func execute_sudo(c *Command, _ *IoProvider) error {
 	sudoPath, err := exec.LookPath("sudo")
 	if err != nil {
-		_, _ = fmt.Fprintf(**c.Stderr, "sudo: %s\n", err)
+		_, _ = fmt.Fprintf(c.Stderr(), "sudo: %s\n", err)
 		return errors.Join(fmt.Errorf("failed to execute command %q", c.String()), err)
 	}
 	cmd := &exec.Cmd{
-		Stdin:  **c.Stdin,
-		Stdout: **c.Stdout,
-		Stderr: **c.Stderr,
+		Stdin:  c.Stdin(),
+		Stdout: c.Stdout(),
+		Stderr: c.Stderr(),
 		Path:   sudoPath,
 		Args:   append([]string{"sudo", c.Executable}, c.Arguments...),
 	}
 
 	err = cmd.Run()
+	c.processState = cmd.ProcessState
 	if err != nil {
-		_, _ = fmt.Fprintf(**c.Stderr, "sudo: failed to execute command: %s\n", err)
+		_, _ = fmt.Fprintf(c.Stderr(), "sudo: failed to execute command: %s\n", err)
 		return errors.Join(fmt.Errorf("failed to execute command %q", c.String()), err)
 	}
 	return nil
 }
 
+// extraFiles returns the file descriptors from 3 upwards for a child process.
+// Streams that are not backed by an *os.File get connected to the child using an os.Pipe.
+// The returned cleanup function must be called after the child process exited.
+func (c *Command) extraFiles() ([]*os.File, func(), error) {
+	maxFd := 2
+	for n := range c.Fds {
+		maxFd = max(maxFd, n)
+	}
+	if c.iop != nil {
+		for n := range c.iop.Fds {
+			maxFd = max(maxFd, n)
+		}
+	}
+	if maxFd < 3 {
+		return nil, func() {}, nil
+	}
+
+	files := make([]*os.File, maxFd-2)
+	parentEnds := make([]*os.File, 0)
+	copying := sync.WaitGroup{}
+	cleanup := func() {
+		for _, f := range parentEnds {
+			_ = f.Close()
+		}
+		copying.Wait()
+	}
+	for n := 3; n <= maxFd; n++ {
+		fd := c.Fd(n)
+		switch {
+		case fd == nil:
+			// closed in the child
+		case fd.Writer != nil:
+			if f, ok := fd.Writer.(*os.File); ok {
+				files[n-3] = f
+				break
+			}
+			r, w, err := os.Pipe()
+			if err != nil {
+				cleanup()
+				return nil, nil, errors.Join(fmt.Errorf("failed to create pipe for file descriptor %d", n), err)
+			}
+			files[n-3] = w
+			parentEnds = append(parentEnds, w)
+			copying.Add(1)
+			go func(dst io.Writer) {
+				defer copying.Done()
+				_, _ = io.Copy(dst, r)
+				_ = r.Close()
+			}(fd.Writer)
+		case fd.Reader != nil:
+			if f, ok := fd.Reader.(*os.File); ok {
+				files[n-3] = f
+				break
+			}
+			r, w, err := os.Pipe()
+			if err != nil {
+				cleanup()
+				return nil, nil, errors.Join(fmt.Errorf("failed to create pipe for file descriptor %d", n), err)
+			}
+			files[n-3] = r
+			parentEnds = append(parentEnds, r)
+			// the reader might block forever, so this goroutine is not waited for
+			go func(src io.Reader) {
+				_, _ = io.Copy(w, src)
+				_ = w.Close()
+			}(fd.Reader)
+		}
+	}
+	return files, cleanup, nil
+}
+
+// replaceProcess replaces the shell with the program c using the exec system call.
+// Builtins and programs whose streams can't be handed over run as a child process instead and the shell exits afterwards.
+func replaceProcess(c *Command, iop *IoProvider) error {
+	_, builtin := BuiltinCommands[strings.ToLower(c.Executable)]
+	if builtin || len(c.Fds) != 0 || len(iop.Fds) != 0 || c.PipeIn != nil || c.PipeOut != nil ||
+		!isStdio(c.Stdin(), 0) || !isStdio(c.Stdout(), 1) || !isStdio(c.Stderr(), 2) {
+		os.Exit(exitCode(c.Execute(iop)))
+	}
+
+	exe, err := exec.LookPath(c.Executable)
+	if err != nil {
+		_, _ = fmt.Fprintf(c.Stderr(), "exec: %s: not found\n", c.Executable)
+		return errors.Join(fmt.Errorf("exec: %s: not found", c.Executable), err)
+	}
+	err = syscall.Exec(exe, append([]string{c.Executable}, c.Arguments...), c.environ())
+	// syscall.Exec only returns on failure
+	_, _ = fmt.Fprintf(c.Stderr(), "exec: %s: %s\n", c.Executable, err)
+	return errors.Join(fmt.Errorf("exec: failed to execute %q", c.String()), err)
+}
+
+// isStdio reports whether v is the shell process's own file descriptor fd.
+func isStdio(v any, fd uintptr) bool {
+	if w, ok := v.(interface{ Unwrap() io.Writer }); ok {
+		v = w.Unwrap()
+	}
+	f, ok := v.(*os.File)
+	return ok && f.Fd() == fd
+}
+
 func isExecutable(path string) (string, bool) {
 	if fi, err := os.Stat(path); err == nil {
 		if fi.Mode()&0111 != 0 {
@@ -70,3 +197,8 @@ func isExecutable(path string) (string, bool) {
 	}
 	return "", false
 }
+
+// accessible reports whether the file at path can be accessed with mode (a combination of 4 read, 2 write, 1 execute).
+func accessible(path string, mode uint32) bool {
+	return syscall.Access(path, mode) == nil
+}
@@ -8,17 +8,25 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
 func Execute_default(c *Command, _ *IoProvider) error {
 	cmd := &exec.Cmd{
-		Stdin:  **c.Stdin,
-		Stdout: **c.Stdout,
-		Stderr: **c.Stderr,
+		Stdin:  c.Stdin(),
+		Stdout: c.Stdout(),
+		Stderr: c.Stderr(),
+	}
+	if len(c.Assignments) != 0 {
+		cmd.Env = c.environ()
 	}
 
-	if exe, err := exec.LookPath(c.Executable); err == nil {
+	if shell, args, ok := c.shellScript(); ok {
+		// shell scripts run with ohmygosh
+		cmd.Path = shell
+		cmd.Args = args
+	} else if exe, err := exec.LookPath(c.Executable); err == nil {
 		cmd.Path = exe
 		cmd.Args = append([]string{exe}, c.Arguments...)
 	} else if exe, err := filepath.Abs(c.Executable); err == nil && exists(exe) {
@@ -52,9 +60,16 @@ func Execute_default(c *Command, _ *IoProvider) error {
 		return fmt.Errorf("failed to execute command %q", c.String())
 	}
 
-	err := cmd.Run()
+	err := c.run(cmd)
+	c.processState = cmd.ProcessState
 	if err != nil {
-		_, _ = fmt.Fprintf(**c.Stderr, "%s: failed to execute command: %s\n", filepath.Base(cmd.Path), err)
+		var exitErr *exec.ExitError
+		var exitStatus ExitStatus
+		if errors.As(err, &exitErr) || errors.As(err, &exitStatus) {
+			// the program reported its failure itself or its job was killed before it started
+			return errors.Join(fmt.Errorf("failed to execute command %q", c.String()), err)
+		}
+		_, _ = fmt.Fprintf(c.Stderr(), "%s: failed to execute command: %s\n", filepath.Base(cmd.Path), err)
 		return errors.Join(fmt.Errorf("failed to execute command %q", c.String()), err)
 	}
 	return nil
@@ -63,26 +78,33 @@ func Execute_default(c *Command, _ *IoProvider) error {
 func execute_sudo(c *Command, _ *IoProvider) error {
 	sudoPath, err := exec.LookPath("sudo")
 	if err != nil {
-		_, _ = fmt.Fprintf(**c.Stderr, "sudo: %s\n", err)
-		_, _ = fmt.Fprintln(**c.Stderr, "install sudo for Windows from https://github.com/microsoft/sudo")
+		_, _ = fmt.Fprintf(c.Stderr(), "sudo: %s\n", err)
+		_, _ = fmt.Fprintln(c.Stderr(), "install sudo for Windows from https://github.com/microsoft/sudo")
 		return errors.Join(fmt.Errorf("failed to execute command %q", c.String()), err)
 	}
 	cmd := &exec.Cmd{
-		Stdin:  **c.Stdin,
-		Stdout: **c.Stdout,
-		Stderr: **c.Stderr,
+		Stdin:  c.Stdin(),
+		Stdout: c.Stdout(),
+		Stderr: c.Stderr(),
 		Path:   sudoPath,
 		Args:   append([]string{"sudo", c.Executable}, c.Arguments...),
 	}
 
 	err = cmd.Run()
 	if err != nil {
-		_, _ = fmt.Fprintf(**c.Stderr, "sudo: failed to execute command: %s\n", err)
+		_, _ = fmt.Fprintf(c.Stderr(), "sudo: failed to execute command: %s\n", err)
 		return errors.Join(fmt.Errorf("failed to execute command %q", c.String()), err)
 	}
 	return nil
 }
 
+// replaceProcess emulates replacing the shell with the program c, which Windows doesn't support.
+// The program runs as a child process and the shell exits afterwards.
+func replaceProcess(c *Command, iop *IoProvider) error {
+	os.Exit(exitCode(c.Execute(iop)))
+	return nil
+}
+
 func exists(path string) bool {
 	_, err := exec.LookPath(path)
 	return err == nil
@@ -102,3 +124,24 @@ func isExecutable(path string) (string, bool) {
 	}
 	return "", false
 }
+
+// accessible reports whether the file at path can be accessed with mode (a combination of 4 read, 2 write, 1 execute).
+// Windows has no execute permission, files with an extension from PATHEXT count as executable.
+func accessible(path string, mode uint32) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if mode&2 != 0 && fi.Mode().Perm()&0200 == 0 {
+		return false
+	}
+	if mode&1 != 0 && !fi.IsDir() {
+		ext := filepath.Ext(path)
+		if !slices.ContainsFunc(pathExt, func(e string) bool {
+			return strings.EqualFold(strings.TrimSpace(e), ext)
+		}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package runtime
+
+import (
+	"io"
+)
+
+type (
+	// FileDescriptor is a stream a command can read from or write to.
+	// Descriptors opened with <> have both a Reader and a Writer.
+	FileDescriptor struct {
+		Reader io.Reader
+		Writer io.WriteCloser
+		// inherit is set if this descriptor is a duplicate of the inherited descriptor fd.
+		// Inherited descriptors get resolved when the command runs, not when it is parsed.
+		inherit bool
+		fd      int
+	}
+
+	// FdTable holds the redirections of a command, applied in source order.
+	// Descriptors without an entry are inherited from the pipeline or the IoProvider.
+	FdTable map[int]*FileDescriptor
+)
+
+// Open makes n refer to fd.
+func (t FdTable) Open(n int, fd *FileDescriptor) {
+	t[n] = fd
+}
+
+// Dup makes n a copy of m (n>&m or n<&m).
+// Later redirections of m don't affect n.
+func (t FdTable) Dup(n, m int) {
+	if fd, ok := t[m]; ok {
+		cp := *fd
+		t[n] = &cp
+	} else {
+		t[n] = &FileDescriptor{inherit: true, fd: m}
+	}
+}
+
+// Close closes n (n>&- or n<&-).
+func (t FdTable) Close(n int) {
+	t[n] = &FileDescriptor{}
+}
+
+// isClosed reports whether the descriptor was closed using Close.
+func (fd *FileDescriptor) isClosed() bool {
+	return !fd.inherit && fd.Reader == nil && fd.Writer == nil
+}
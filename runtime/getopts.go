@@ -0,0 +1,132 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	getoptsMutex sync.Mutex
+	// getoptsOffset is the position of the next option character in a group like -abc,
+	// it belongs to getoptsArg, the argument getoptsIndex points to
+	getoptsOffset = 1
+	getoptsIndex  = 0
+	getoptsArg    = ""
+)
+
+func execute_getopts(c *Command, _ *IoProvider) error {
+	if len(c.Arguments) < 2 {
+		_, _ = fmt.Fprintln(c.Stderr(), "getopts: usage: getopts optstring name [arg ...]")
+		return ExitStatus(2)
+	}
+	optstring, name := c.Arguments[0], c.Arguments[1]
+	if !IsName(name) {
+		_, _ = fmt.Fprintf(c.Stderr(), "getopts: `%s': not a valid identifier\n", name)
+		return errors.Join(fmt.Errorf("getopts: `%s': not a valid identifier", name), ExitStatus(1))
+	}
+	args := c.Arguments[2:]
+	if len(args) == 0 {
+		args = PositionalParameters()
+	}
+	// a leading : selects silent error reporting, OPTERR=0 turns the messages off too
+	silent := strings.HasPrefix(optstring, ":")
+	optstring = strings.TrimPrefix(optstring, ":")
+	opterr, _ := Variable("OPTERR")
+	report := func(format string, a ...any) {
+		if !silent && opterr != "0" {
+			_, _ = fmt.Fprintf(c.Stderr(), "%s: "+format+"\n", append([]any{ScriptName()}, a...)...)
+		}
+	}
+
+	getoptsMutex.Lock()
+	defer getoptsMutex.Unlock()
+	optind := 1
+	if value, ok := Variable("OPTIND"); ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n > 0 {
+			optind = n
+		}
+	}
+	if optind != getoptsIndex || optind > len(args) || args[optind-1] != getoptsArg {
+		// OPTIND was changed by the script or another list is parsed, like OPTIND=1 or getopts with other arguments
+		getoptsOffset = 1
+	}
+	var status error
+	assign := func(values ...string) {
+		for i := 0; i+1 < len(values); i += 2 {
+			if err := Assign(Assignment{Name: values[i], Value: values[i+1]}); err != nil {
+				_, _ = fmt.Fprintf(c.Stderr(), "getopts: %s: %s\n", values[i], err)
+				status = errors.Join(fmt.Errorf("getopts: failed to assign %s", values[i]), ExitStatus(1))
+			}
+		}
+	}
+	defer func() {
+		assign("OPTIND", strconv.Itoa(optind))
+		getoptsIndex = optind
+		getoptsArg = ""
+		if optind <= len(args) {
+			getoptsArg = args[optind-1]
+		}
+	}()
+
+	// the options end at the first argument that is not an option or after --
+	if optind > len(args) || len(args[optind-1]) < 2 || args[optind-1][0] != '-' {
+		assign(name, "?")
+		return errors.Join(status, ExitStatus(1))
+	}
+	if args[optind-1] == "--" {
+		optind++
+		assign(name, "?")
+		return errors.Join(status, ExitStatus(1))
+	}
+
+	arg := args[optind-1]
+	if getoptsOffset >= len(arg) {
+		getoptsOffset = 1
+	}
+	option := arg[getoptsOffset]
+	getoptsOffset++
+	if getoptsOffset >= len(arg) {
+		optind++
+		getoptsOffset = 1
+	}
+	i := strings.IndexByte(optstring, option)
+	if i == -1 || option == ':' {
+		report("illegal option -- %c", option)
+		assign(name, "?")
+		if silent {
+			assign("OPTARG", string(option))
+		} else {
+			_ = UnsetVariable("OPTARG")
+		}
+		return status
+	}
+	if i+1 < len(optstring) && optstring[i+1] == ':' {
+		// the argument is the rest of the group (-ovalue) or the next argument (-o value)
+		switch {
+		case getoptsOffset != 1:
+			assign("OPTARG", arg[getoptsOffset:])
+			optind++
+			getoptsOffset = 1
+		case optind <= len(args):
+			assign("OPTARG", args[optind-1])
+			optind++
+		default:
+			report("option requires an argument -- %c", option)
+			if silent {
+				assign(name, ":", "OPTARG", string(option))
+			} else {
+				assign(name, "?")
+				_ = UnsetVariable("OPTARG")
+			}
+			return status
+		}
+		assign(name, string(option))
+		return status
+	}
+	assign(name, string(option))
+	_ = UnsetVariable("OPTARG")
+	return status
+}
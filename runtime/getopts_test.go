@@ -0,0 +1,83 @@
+package runtime
+
+import (
+	"testing"
+)
+
+func TestGetopts(t *testing.T) {
+	type call struct {
+		args   []string
+		name   string
+		optarg string
+		optind string
+		fails  bool
+	}
+	cases := []struct {
+		name  string
+		calls []call
+	}{
+		{
+			name: "group and separate argument",
+			calls: []call{
+				{args: []string{"ab:c", "opt", "-ac", "-b", "value", "rest"}, name: "a", optarg: "unset", optind: "1"},
+				{args: []string{"ab:c", "opt", "-ac", "-b", "value", "rest"}, name: "c", optarg: "unset", optind: "2"},
+				{args: []string{"ab:c", "opt", "-ac", "-b", "value", "rest"}, name: "b", optarg: "value", optind: "4"},
+				{args: []string{"ab:c", "opt", "-ac", "-b", "value", "rest"}, name: "?", optarg: "value", optind: "4", fails: true},
+			},
+		},
+		{
+			name: "argument in the group",
+			calls: []call{
+				{args: []string{"o:", "opt", "-ovalue", "--", "-o"}, name: "o", optarg: "value", optind: "2"},
+				{args: []string{"o:", "opt", "-ovalue", "--", "-o"}, name: "?", optarg: "value", optind: "3", fails: true},
+			},
+		},
+		{
+			// the offset in -abc must not be used for the shorter -x of another list
+			name: "another argument list at the same index",
+			calls: []call{
+				{args: []string{"abc", "opt", "-abc"}, name: "a", optarg: "unset", optind: "1"},
+				{args: []string{":ab", "opt", "-x"}, name: "?", optarg: "x", optind: "2"},
+				{args: []string{"ab", "opt", "-x", "-b"}, name: "b", optarg: "unset", optind: "3"},
+			},
+		},
+		{
+			name: "missing argument in silent mode",
+			calls: []call{
+				{args: []string{":a:", "opt", "-a"}, name: ":", optarg: "a", optind: "2"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_ = UnsetVariable("OPTIND")
+			_ = UnsetVariable("OPTARG")
+			_ = UnsetVariable("opt")
+			defer func() {
+				_ = UnsetVariable("OPTIND")
+				_ = UnsetVariable("OPTARG")
+				_ = UnsetVariable("opt")
+			}()
+			for i, call := range c.calls {
+				iop, _, _ := TestIoProvider("")
+				cmd := NewCommand(iop)
+				cmd.Executable = "getopts"
+				cmd.Arguments = call.args
+				err := execute_getopts(cmd, iop)
+				if (err != nil) != call.fails {
+					t.Errorf("call %d: error: %v, expected failure: %v", i, err, call.fails)
+				}
+				name, _ := Variable("opt")
+				optarg, ok := Variable("OPTARG")
+				if !ok {
+					optarg = "unset"
+				}
+				optind, _ := Variable("OPTIND")
+				if name != call.name || optarg != call.optarg || optind != call.optind {
+					t.Errorf("call %d: opt=%q OPTARG=%q OPTIND=%q, expected: opt=%q OPTARG=%q OPTIND=%q",
+						i, name, optarg, optind, call.name, call.optarg, call.optind)
+				}
+			}
+		})
+	}
+}
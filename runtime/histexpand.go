@@ -0,0 +1,290 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExpandHistory replaces the history references like !!, !$, !n and !prefix in line with the commands of the history
+// and ^old^new at the start of line with the previous command where old is replaced with new.
+// It reports whether line changed, the shell echoes the expanded line then.
+// Nothing is expanded inside single quotes or after a backslash.
+func ExpandHistory(line string) (string, bool, error) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	text := strings.TrimSuffix(line, "\n")
+	newline := line[len(text):]
+	if strings.HasPrefix(text, "^") {
+		expanded, err := quickSubstitution(text)
+		return expanded + newline, err == nil, err
+	}
+	if !strings.Contains(text, "!") {
+		return line, false, nil
+	}
+
+	sb := strings.Builder{}
+	changed := false
+	inSingle, inDouble := false, false
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case ch == '\\' && !inSingle && i+1 < len(text):
+			sb.WriteByte(ch)
+			i++
+			sb.WriteByte(text[i])
+			continue
+		case ch == '\'' && !inDouble:
+			inSingle = !inSingle
+		case ch == '"' && !inSingle:
+			inDouble = !inDouble
+		}
+		if ch != '!' || inSingle || !isHistoryReference(text, i, inDouble) {
+			sb.WriteByte(ch)
+			continue
+		}
+		expansion, n, err := historyReference(text[i:])
+		if err != nil {
+			return line, false, err
+		}
+		sb.WriteString(expansion)
+		i += n - 1
+		changed = true
+	}
+	if !changed {
+		return line, false, nil
+	}
+	return sb.String() + newline, true, nil
+}
+
+// isHistoryReference reports whether the ! at text[i] starts a history reference.
+// Like in bash, it doesn't if it is followed by a blank, = or ( or if it is part of $! or ${!name}.
+func isHistoryReference(text string, i int, inDouble bool) bool {
+	if i+1 == len(text) || strings.IndexByte(" \t\n=(", text[i+1]) != -1 || (inDouble && text[i+1] == '"') {
+		return false
+	}
+	if i > 0 && text[i-1] == '$' || i > 1 && text[i-2:i] == "${" {
+		return false
+	}
+	return true
+}
+
+// historyReference expands the history reference at the start of ref, it returns the expansion and its length in ref.
+func historyReference(ref string) (string, int, error) {
+	// the event designator selects the command
+	n := 1
+	var entry string
+	var ok bool
+	switch c := ref[1]; {
+	case c == '!':
+		n = 2
+		entry, ok = historyEvent(-1)
+	case c == '$' || c == '^' || c == '*':
+		// !$ is short for !!:$
+		if entry, ok = historyEvent(-1); !ok {
+			return "", 0, fmt.Errorf("%s: event not found", ref[:2])
+		}
+		words, err := historyWords(entry, ref[1:2], ref[:2])
+		return words, 2, err
+	case c == '-' || (c >= '0' && c <= '9'):
+		n = 2
+		for n < len(ref) && ref[n] >= '0' && ref[n] <= '9' {
+			n++
+		}
+		number, err := strconv.Atoi(ref[1:n])
+		if err != nil {
+			return "", 0, fmt.Errorf("%s: event not found", ref[:n])
+		}
+		if number < 0 {
+			entry, ok = historyEvent(number)
+		} else {
+			entry, ok = historyEvent(number - historyBase)
+			ok = ok && number >= historyBase
+		}
+	case c == '?':
+		// !?string? is the last command that contains string
+		end := strings.IndexByte(ref[2:], '?')
+		search := ref[2:]
+		n = len(ref)
+		if end != -1 {
+			search = ref[2 : 2+end]
+			n = end + 3
+		}
+		entry, ok = searchHistory(func(line string) bool { return strings.Contains(line, search) })
+	default:
+		// !prefix is the last command that starts with prefix
+		n = 1
+		for n < len(ref) && strings.IndexByte(" \t\n:;&|<>()\"'", ref[n]) == -1 {
+			n++
+		}
+		prefix := ref[1:n]
+		entry, ok = searchHistory(func(line string) bool { return strings.HasPrefix(line, prefix) })
+	}
+	if !ok {
+		return "", 0, fmt.Errorf("%s: event not found", ref[:n])
+	}
+
+	// a word designator after : selects words of the command
+	if n+1 < len(ref) && ref[n] == ':' && strings.IndexByte("0123456789^$*-", ref[n+1]) != -1 {
+		end := n + 1
+		for end < len(ref) && strings.IndexByte("0123456789^$*-", ref[end]) != -1 {
+			end++
+		}
+		words, err := historyWords(entry, ref[n+1:end], ref[:end])
+		return words, end, err
+	}
+	return entry, n, nil
+}
+
+// historyEvent returns the entry at offset from the start of the history, a negative offset counts from the end.
+func historyEvent(offset int) (string, bool) {
+	if offset < 0 {
+		offset += len(historyEntries)
+	}
+	if offset < 0 || offset >= len(historyEntries) {
+		return "", false
+	}
+	return historyEntries[offset].line, true
+}
+
+// searchHistory returns the last entry match returns true for.
+func searchHistory(match func(string) bool) (string, bool) {
+	for i := len(historyEntries) - 1; i >= 0; i-- {
+		if match(historyEntries[i].line) {
+			return historyEntries[i].line, true
+		}
+	}
+	return "", false
+}
+
+// historyWords returns the words of line that the word designator selects: n, ^, $, *, x-y, x- or x*.
+// Word 0 is the command name.
+func historyWords(line, designator, ref string) (string, error) {
+	words := splitHistoryWords(line)
+	last := len(words) - 1
+	bad := fmt.Errorf("%s: bad word specifier", ref)
+	if designator == "*" {
+		// all arguments, nothing if there are none
+		return strings.Join(words[min(1, len(words)):], " "), nil
+	}
+
+	word := func(s string) (int, string, bool) {
+		switch {
+		case s == "":
+			return 0, s, false
+		case s[0] == '^':
+			return 1, s[1:], true
+		case s[0] == '$':
+			return last, s[1:], true
+		}
+		end := 0
+		for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(s[:end])
+		return n, s[end:], err == nil
+	}
+	first, rest, ok := word(designator)
+	if !ok && strings.HasPrefix(designator, "-") {
+		// -y is short for 0-y
+		first, rest, ok = 0, designator, true
+	}
+	if !ok {
+		return "", bad
+	}
+	second := first
+	switch {
+	case rest == "":
+	case rest == "*":
+		second = last
+	case rest == "-":
+		second = last - 1
+	case rest[0] == '-':
+		if second, rest, ok = word(rest[1:]); !ok || rest != "" {
+			return "", bad
+		}
+	default:
+		return "", bad
+	}
+	if first < 0 || first > last || second > last {
+		return "", bad
+	}
+	if second < first {
+		// x- selects nothing if x is the last word
+		return "", nil
+	}
+	return strings.Join(words[first:second+1], " "), nil
+}
+
+// splitHistoryWords splits line into words like the shell does.
+// Quotes are kept, the operators ; & | < > ( ) are words of their own.
+func splitHistoryWords(line string) []string {
+	words := make([]string, 0)
+	word := strings.Builder{}
+	inWord := false
+	flush := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			flush()
+		case strings.IndexByte(";&|<>()", ch) != -1:
+			flush()
+			end := i + 1
+			for end < len(line) && strings.IndexByte(";&|<>", line[end]) != -1 && ch != '(' && ch != ')' {
+				end++
+			}
+			words = append(words, line[i:end])
+			i = end - 1
+		case ch == '\\' && i+1 < len(line):
+			word.WriteString(line[i : i+2])
+			inWord = true
+			i++
+		case ch == '\'' || ch == '"':
+			// the quoted part belongs to the word, up to the closing quote
+			end := i + 1
+			for end < len(line) && line[end] != ch {
+				if ch == '"' && line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(line))
+			word.WriteString(line[i:end])
+			inWord = true
+			i = end - 1
+		default:
+			word.WriteByte(ch)
+			inWord = true
+		}
+	}
+	flush()
+	return words
+}
+
+// quickSubstitution expands ^old^new^ to the previous command with the first old replaced with new.
+func quickSubstitution(text string) (string, error) {
+	parts := strings.SplitN(text[1:], "^", 3)
+	old, replacement, rest := parts[0], "", ""
+	if len(parts) > 1 {
+		replacement = parts[1]
+	}
+	if len(parts) > 2 {
+		rest = parts[2]
+	}
+	previous, ok := historyEvent(-1)
+	if !ok {
+		return text, fmt.Errorf("!!: event not found")
+	}
+	if old == "" || !strings.Contains(previous, old) {
+		return text, fmt.Errorf("^%s^%s: substitution failed", old, replacement)
+	}
+	return strings.Replace(previous, old, replacement, 1) + rest, nil
+}
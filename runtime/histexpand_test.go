@@ -0,0 +1,124 @@
+package runtime
+
+import (
+	"testing"
+)
+
+func TestHistoryWords(t *testing.T) {
+	line := `git commit -m "a message" file.go | cat`
+	cases := []struct {
+		designator string
+		expect     string
+		fails      bool
+	}{
+		{designator: "0", expect: "git"},
+		{designator: "2", expect: "-m"},
+		{designator: "3", expect: `"a message"`},
+		{designator: "^", expect: "commit"},
+		{designator: "$", expect: "cat"},
+		{designator: "*", expect: `commit -m "a message" file.go | cat`},
+		{designator: "1-3", expect: `commit -m "a message"`},
+		{designator: "-2", expect: "git commit -m"},
+		{designator: "2*", expect: `-m "a message" file.go | cat`},
+		{designator: "2-", expect: `-m "a message" file.go |`},
+		{designator: "^-$", expect: `commit -m "a message" file.go | cat`},
+		{designator: "6*", expect: "cat"},
+		{designator: "6-", expect: ""},
+		{designator: "7", fails: true},
+		{designator: "3-1x", fails: true},
+		{designator: "2-9", fails: true},
+		{designator: "x", fails: true},
+	}
+	for _, c := range cases {
+		t.Run(c.designator, func(t *testing.T) {
+			words, err := historyWords(line, c.designator, "!!:"+c.designator)
+			if (err != nil) != c.fails {
+				t.Fatalf("error: %v, expected failure: %v", err, c.fails)
+			}
+			if words != c.expect {
+				t.Errorf("words: %q, expected: %q", words, c.expect)
+			}
+		})
+	}
+	if words, err := historyWords("", "*", "!*"); err != nil || words != "" {
+		t.Errorf("!* of an empty line: %q, %v", words, err)
+	}
+}
+
+func TestSplitHistoryWords(t *testing.T) {
+	cases := []struct {
+		line   string
+		expect []string
+	}{
+		{line: "echo a  b", expect: []string{"echo", "a", "b"}},
+		{line: `echo "a b" 'c d'e`, expect: []string{"echo", `"a b"`, `'c d'e`}},
+		{line: `echo a\ b "x\"y"`, expect: []string{"echo", `a\ b`, `"x\"y"`}},
+		{line: "a&&b|c;d>>f", expect: []string{"a", "&&", "b", "|", "c", ";", "d", ">>", "f"}},
+		{line: "(cd x)", expect: []string{"(", "cd", "x", ")"}},
+		{line: `echo "open`, expect: []string{"echo", `"open`}},
+	}
+	for _, c := range cases {
+		t.Run(c.line, func(t *testing.T) {
+			words := splitHistoryWords(c.line)
+			if len(words) != len(c.expect) {
+				t.Fatalf("words: %q, expected: %q", words, c.expect)
+			}
+			for i := range words {
+				if words[i] != c.expect[i] {
+					t.Fatalf("words: %q, expected: %q", words, c.expect)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandHistory(t *testing.T) {
+	defer func() {
+		historyEntries = historyEntries[:0]
+		historyBase = 1
+	}()
+	historyEntries = historyEntries[:0]
+	historyBase = 5
+	for _, line := range []string{"ls -l /tmp", "echo hello world", "cat file | wc -l"} {
+		historyEntries = append(historyEntries, historyEntry{line: line})
+	}
+
+	cases := []struct {
+		line    string
+		expect  string
+		changed bool
+		fails   bool
+	}{
+		{line: "!!", expect: "cat file | wc -l", changed: true},
+		{line: "sudo !!\n", expect: "sudo cat file | wc -l\n", changed: true},
+		{line: "echo !$", expect: "echo -l", changed: true},
+		{line: "echo !^ !*", expect: "echo file file | wc -l", changed: true},
+		{line: "!5", expect: "ls -l /tmp", changed: true},
+		{line: "!-2:1-", expect: "hello", changed: true},
+		{line: "!ec:2", expect: "world", changed: true},
+		{line: "!?hello?:0", expect: "echo", changed: true},
+		{line: "!ls:$", expect: "/tmp", changed: true},
+		{line: "^hello^bye", fails: true},
+		{line: "^wc^grep x^ -c", expect: "cat file | grep x -l -c", changed: true},
+		{line: "^nothing^x", fails: true},
+		{line: "echo '!!' \\!! $! ${!x} a!= !( \"a!\"", expect: "echo '!!' \\!! $! ${!x} a!= !( \"a!\"", changed: false},
+		{line: "echo \"!!\"", expect: "echo \"cat file | wc -l\"", changed: true},
+		{line: "!4", fails: true},
+		{line: "!missing", fails: true},
+		{line: "!!:9", fails: true},
+	}
+	for _, c := range cases {
+		t.Run(c.line, func(t *testing.T) {
+			expanded, changed, err := ExpandHistory(c.line)
+			if (err != nil) != c.fails {
+				t.Fatalf("error: %v, expected failure: %v", err, c.fails)
+			}
+			if err != nil {
+				return
+			}
+			if expanded != c.expect || changed != c.changed {
+				t.Errorf("expansion: %q, %v, expected: %q, %v", expanded, changed, c.expect, c.changed)
+			}
+		})
+	}
+}
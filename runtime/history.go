@@ -0,0 +1,487 @@
+package runtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyEntry is a command line of the history, it can span several lines.
+type historyEntry struct {
+	line string
+	time time.Time
+}
+
+var (
+	historyMutex   sync.Mutex
+	historyEntries = make([]historyEntry, 0)
+	// historyBase is the number of the first entry, it grows when old entries are dropped
+	historyBase = 1
+	// historySaved is the number of entries at the start of historyEntries that are in the history file
+	historySaved = 0
+	// historyEnabled is set by an interactive shell, the history is saved when it exits
+	historyEnabled = false
+)
+
+const (
+	defaultHistorySize = 500
+	// historyLockTimeout is how long a session waits for another one to finish writing the history file,
+	// a lock that is older is left over from a session that crashed
+	historyLockTimeout = 2 * time.Second
+)
+
+// historyFile returns $HISTFILE or ~/.ohmygosh_history, it is empty if the history isn't saved.
+func historyFile() string {
+	if file, ok := Variable("HISTFILE"); ok {
+		return file
+	}
+	home, err := homeDirectory()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ohmygosh_history")
+}
+
+// historySize returns the number of entries that are kept, from the variable name (HISTSIZE or HISTFILESIZE).
+// A negative number means that there is no limit.
+func historySize(name string) int {
+	value, ok := Variable(name)
+	if !ok {
+		if name == "HISTFILESIZE" {
+			return historySize("HISTSIZE")
+		}
+		return defaultHistorySize
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return defaultHistorySize
+	}
+	return n
+}
+
+// LoadHistory reads the history file and turns on the history of an interactive shell.
+func LoadHistory() error {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	historyEnabled = true
+	file := historyFile()
+	if file == "" {
+		return nil
+	}
+	entries, err := readHistoryFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	historyEntries = append(historyEntries, entries...)
+	truncateHistory()
+	historySaved = len(historyEntries)
+	return nil
+}
+
+// AddHistory adds the command line to the history, unless HISTCONTROL excludes it.
+// HISTCONTROL is a colon separated list of ignorespace, ignoredups, ignoreboth and erasedups.
+func AddHistory(line string) {
+	line = strings.TrimRight(line, "\n")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	control, _ := Variable("HISTCONTROL")
+	options := map[string]bool{}
+	for _, option := range strings.Split(control, ":") {
+		if option == "ignoreboth" {
+			options["ignorespace"] = true
+			options["ignoredups"] = true
+		}
+		options[option] = true
+	}
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if options["ignorespace"] && (line[0] == ' ' || line[0] == '\t') {
+		return
+	}
+	if options["ignoredups"] && len(historyEntries) > 0 && historyEntries[len(historyEntries)-1].line == line {
+		return
+	}
+	if options["erasedups"] {
+		for i := len(historyEntries) - 1; i >= 0; i-- {
+			if historyEntries[i].line == line {
+				deleteHistoryEntry(i)
+			}
+		}
+	}
+	historyEntries = append(historyEntries, historyEntry{line: line, time: time.Now()})
+	truncateHistory()
+}
+
+// History returns the command lines of the history, the oldest first.
+func History() []string {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	lines := make([]string, len(historyEntries))
+	for i, entry := range historyEntries {
+		lines[i] = entry.line
+	}
+	return lines
+}
+
+// HistoryBase returns the number of the first entry of History.
+func HistoryBase() int {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	return historyBase
+}
+
+// SaveHistory appends the entries of this session to the history file.
+// It does nothing if the shell isn't interactive.
+func SaveHistory() error {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if !historyEnabled {
+		return nil
+	}
+	return appendHistory()
+}
+
+// truncateHistory drops the oldest entries beyond HISTSIZE, historyMutex must be held.
+func truncateHistory() {
+	size := historySize("HISTSIZE")
+	if size < 0 || len(historyEntries) <= size {
+		return
+	}
+	drop := len(historyEntries) - size
+	historyEntries = append(historyEntries[:0], historyEntries[drop:]...)
+	historyBase += drop
+	historySaved = max(historySaved-drop, 0)
+}
+
+// deleteHistoryEntry removes the entry at index i, historyMutex must be held.
+func deleteHistoryEntry(i int) {
+	historyEntries = append(historyEntries[:i], historyEntries[i+1:]...)
+	if i < historySaved {
+		historySaved--
+	}
+}
+
+// appendHistory appends the entries that aren't saved yet to the history file, historyMutex must be held.
+// Other sessions may have appended to the file in the meantime, their entries are kept.
+func appendHistory() error {
+	file := historyFile()
+	if file == "" || historySaved >= len(historyEntries) {
+		return nil
+	}
+	err := updateHistoryFile(file, func(entries []historyEntry) []historyEntry {
+		return append(entries, historyEntries[historySaved:]...)
+	})
+	if err != nil {
+		return err
+	}
+	historySaved = len(historyEntries)
+	return nil
+}
+
+// writeHistory replaces the history file with the history of this session, historyMutex must be held.
+func writeHistory() error {
+	file := historyFile()
+	if file == "" {
+		return nil
+	}
+	err := updateHistoryFile(file, func([]historyEntry) []historyEntry {
+		return historyEntries
+	})
+	if err != nil {
+		return err
+	}
+	historySaved = len(historyEntries)
+	return nil
+}
+
+// updateHistoryFile replaces the entries of the history file with the result of update.
+// The file is locked while it is updated and replaced at once, so concurrent sessions don't lose entries
+// and a session that reads it never sees half of a write.
+func updateHistoryFile(file string, update func([]historyEntry) []historyEntry) error {
+	unlock, err := lockHistoryFile(file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readHistoryFile(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	entries = update(entries)
+	if size := historySize("HISTFILESIZE"); size >= 0 && len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	// every entry gets a time, so a command that looks like a time (#123) is read back as a command.
+	// Entries that were read without a time get the time of the write.
+	now := time.Now()
+	w := bufio.NewWriter(tmp)
+	for _, entry := range entries {
+		t := entry.time
+		if t.IsZero() {
+			t = now
+		}
+		_, _ = fmt.Fprintf(w, "#%d\n%s\n", t.Unix(), entry.line)
+	}
+	if err := errors.Join(w.Flush(), tmp.Close()); err != nil {
+		return err
+	}
+	// the history may contain secrets, only the user can read it
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// lockHistoryFile creates the lock file file.lock, which only one session can hold at a time.
+func lockHistoryFile(file string) (unlock func(), err error) {
+	lock := file + ".lock"
+	deadline := time.Now().Add(historyLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_ = f.Close()
+			return func() {
+				_ = os.Remove(lock)
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			if info, statErr := os.Stat(lock); statErr == nil && time.Since(info.ModTime()) > historyLockTimeout {
+				// the session that held the lock is gone
+				_ = os.Remove(lock)
+				deadline = time.Now().Add(historyLockTimeout)
+				continue
+			}
+			return nil, fmt.Errorf("%s: history file is locked", file)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readHistoryFile reads the entries of a history file.
+// An entry is a line and, as long as the command is incomplete, the lines after it.
+// A line #seconds in front of an entry is its time, a line like that without a command after it is a command itself.
+func readHistoryFile(file string) ([]historyEntry, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(string(content), "\n")
+	if text == "" {
+		return []historyEntry{}, nil
+	}
+	lines := strings.Split(text, "\n")
+
+	// timestamp[i] is set if lines[i] is the time of the command on the next line.
+	// Of two #seconds lines in a row, the second one is the time if a command follows it.
+	timestamp := make([]bool, len(lines)+1)
+	for i := len(lines) - 2; i >= 0; i-- {
+		if _, ok := historyTimestamp(lines[i]); ok {
+			_, next := historyTimestamp(lines[i+1])
+			timestamp[i] = !next || !timestamp[i+1]
+		}
+	}
+
+	entries := make([]historyEntry, 0)
+	for i := 0; i < len(lines); i++ {
+		entry := historyEntry{}
+		if timestamp[i] {
+			seconds, _ := historyTimestamp(lines[i])
+			entry.time = time.Unix(seconds, 0)
+			i++
+		}
+		entry.line = lines[i]
+		for i+1 < len(lines) && Incomplete != nil && Incomplete(entry.line+"\n") {
+			i++
+			entry.line += "\n" + lines[i]
+		}
+		if strings.TrimSpace(entry.line) != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func historyTimestamp(line string) (int64, bool) {
+	if len(line) < 2 || line[0] != '#' {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(line[1:], 10, 64)
+	return seconds, err == nil
+}
+
+func execute_history(c *Command, _ *IoProvider) error {
+	fail := func(format string, a ...any) error {
+		msg := "history: " + fmt.Sprintf(format, a...)
+		_, _ = fmt.Fprintln(c.Stderr(), msg)
+		return errors.Join(errors.New(msg), ExitStatus(1))
+	}
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	args := c.Arguments
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	} else if len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		// an option changes the history, it is only printed without one
+		switch args[0] {
+		case "-c":
+			historyEntries = historyEntries[:0]
+			historyBase = 1
+			historySaved = 0
+		case "-d":
+			if len(args) < 2 {
+				_, _ = fmt.Fprintln(c.Stderr(), "history: -d: option requires an argument")
+				return ExitStatus(2)
+			}
+			n, err := strconv.Atoi(args[1])
+			// a negative offset counts from the end of the history
+			i := n - historyBase
+			if n < 0 {
+				i = len(historyEntries) + n
+			}
+			if err != nil || i < 0 || i >= len(historyEntries) {
+				return fail("%s: history position out of range", args[1])
+			}
+			deleteHistoryEntry(i)
+		case "-w":
+			if err := writeHistory(); err != nil {
+				return fail("%s", err)
+			}
+		case "-a":
+			if err := appendHistory(); err != nil {
+				return fail("%s", err)
+			}
+		case "-r":
+			file := historyFile()
+			if file == "" {
+				return nil
+			}
+			entries, err := readHistoryFile(file)
+			if err != nil {
+				return fail("%s", err)
+			}
+			historyEntries = append(historyEntries, entries...)
+			truncateHistory()
+		default:
+			_, _ = fmt.Fprintf(c.Stderr(), "history: %s: invalid option\n", args[0])
+			return errors.Join(fmt.Errorf("history: %s: invalid option", args[0]), ExitStatus(2))
+		}
+		return nil
+	}
+
+	entries := historyEntries
+	switch len(args) {
+	case 0:
+	case 1:
+		// the last n entries
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return fail("%s: numeric argument required", args[0])
+		}
+		entries = entries[max(len(entries)-n, 0):]
+	default:
+		return fail("too many arguments")
+	}
+
+	format, timed := Variable("HISTTIMEFORMAT")
+	first := historyBase + len(historyEntries) - len(entries)
+	sb := strings.Builder{}
+	for i, entry := range entries {
+		sb.WriteString(fmt.Sprintf("%5d  ", first+i))
+		if timed && !entry.time.IsZero() {
+			sb.WriteString(strftime(format, entry.time))
+		}
+		sb.WriteString(entry.line + "\n")
+	}
+	_, err := c.Stdout().Write([]byte(sb.String()))
+	return err
+}
+
+// strftime formats t with the conversions of the C function strftime that are used for HISTTIMEFORMAT.
+func strftime(format string, t time.Time) string {
+	sb := strings.Builder{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			sb.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'a':
+			sb.WriteString(t.Format("Mon"))
+		case 'A':
+			sb.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			sb.WriteString(t.Format("Jan"))
+		case 'B':
+			sb.WriteString(t.Format("January"))
+		case 'c':
+			sb.WriteString(t.Format("Mon Jan _2 15:04:05 2006"))
+		case 'd':
+			sb.WriteString(t.Format("02"))
+		case 'D':
+			sb.WriteString(t.Format("01/02/06"))
+		case 'e':
+			sb.WriteString(t.Format("_2"))
+		case 'F':
+			sb.WriteString(t.Format("2006-01-02"))
+		case 'H':
+			sb.WriteString(t.Format("15"))
+		case 'I':
+			sb.WriteString(t.Format("03"))
+		case 'j':
+			sb.WriteString(fmt.Sprintf("%03d", t.YearDay()))
+		case 'm':
+			sb.WriteString(t.Format("01"))
+		case 'M':
+			sb.WriteString(t.Format("04"))
+		case 'p':
+			sb.WriteString(t.Format("PM"))
+		case 'R':
+			sb.WriteString(t.Format("15:04"))
+		case 's':
+			sb.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'S':
+			sb.WriteString(t.Format("05"))
+		case 'T':
+			sb.WriteString(t.Format("15:04:05"))
+		case 'y':
+			sb.WriteString(t.Format("06"))
+		case 'Y':
+			sb.WriteString(t.Format("2006"))
+		case 'z':
+			sb.WriteString(t.Format("-0700"))
+		case 'Z':
+			sb.WriteString(t.Format("MST"))
+		case '%':
+			sb.WriteByte('%')
+		default:
+			sb.WriteByte('%')
+			sb.WriteByte(format[i])
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,166 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// incompleteStub treats a trailing backslash or && as an incomplete command, like the compiler does.
+func incompleteStub(text string) bool {
+	text = strings.TrimSuffix(text, "\n")
+	return strings.HasSuffix(text, "\\") || strings.HasSuffix(text, "&&")
+}
+
+func TestReadHistoryFile(t *testing.T) {
+	defer func(incomplete func(string) bool) { Incomplete = incomplete }(Incomplete)
+	Incomplete = incompleteStub
+
+	cases := []struct {
+		name    string
+		content string
+		lines   []string
+		times   []int64
+	}{
+		{
+			name:    "without times",
+			content: "ls\necho a\n",
+			lines:   []string{"ls", "echo a"},
+			times:   []int64{0, 0},
+		},
+		{
+			name:    "with times",
+			content: "#100\nls\n#200\necho a\n",
+			lines:   []string{"ls", "echo a"},
+			times:   []int64{100, 200},
+		},
+		{
+			name:    "multi-line entry",
+			content: "#100\necho a &&\necho b\n#200\nls\n",
+			lines:   []string{"echo a &&\necho b", "ls"},
+			times:   []int64{100, 200},
+		},
+		{
+			// untimed lines after a timed entry are entries of their own
+			name:    "mixed",
+			content: "#100\nls\npwd\n#200\necho a\ncd\n",
+			lines:   []string{"ls", "pwd", "echo a", "cd"},
+			times:   []int64{100, 0, 200, 0},
+		},
+		{
+			name:    "command that looks like a time",
+			content: "#100\n#123\n#200\nls\n#300\n",
+			lines:   []string{"#123", "ls", "#300"},
+			times:   []int64{100, 200, 0},
+		},
+		{
+			name:    "comment in front of a timed entry",
+			content: "#123\n#200\nls\n",
+			lines:   []string{"#123", "ls"},
+			times:   []int64{0, 200},
+		},
+		{
+			name:    "empty",
+			content: "",
+			lines:   []string{},
+			times:   []int64{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "history")
+			if err := os.WriteFile(file, []byte(c.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := readHistoryFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkHistoryEntries(t, entries, c.lines, c.times)
+
+			// writing the entries back and reading them again keeps them
+			if err := updateHistoryFile(file, func([]historyEntry) []historyEntry { return entries }); err != nil {
+				t.Fatal(err)
+			}
+			again, err := readHistoryFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := make([]string, len(again))
+			for i, entry := range again {
+				lines[i] = entry.line
+				if entry.time.IsZero() {
+					t.Errorf("entry %d has no time after writing", i)
+				}
+				if !entries[i].time.IsZero() && !entry.time.Equal(entries[i].time) {
+					t.Errorf("entry %d: time %v, expected: %v", i, entry.time, entries[i].time)
+				}
+			}
+			if strings.Join(lines, "|") != strings.Join(c.lines, "|") {
+				t.Errorf("after writing: %q, expected: %q", lines, c.lines)
+			}
+		})
+	}
+}
+
+func checkHistoryEntries(t *testing.T, entries []historyEntry, lines []string, times []int64) {
+	t.Helper()
+	if len(entries) != len(lines) {
+		t.Fatalf("entries: %v, expected lines: %q", entries, lines)
+	}
+	for i, entry := range entries {
+		var seconds int64
+		if !entry.time.IsZero() {
+			seconds = entry.time.Unix()
+		}
+		if entry.line != lines[i] || seconds != times[i] {
+			t.Errorf("entry %d: %q at %d, expected: %q at %d", i, entry.line, seconds, lines[i], times[i])
+		}
+	}
+}
+
+func TestAddHistory(t *testing.T) {
+	defer func() {
+		historyEntries = historyEntries[:0]
+		historyBase = 1
+		historySaved = 0
+	}()
+	cases := []struct {
+		control string
+		add     []string
+		lines   []string
+	}{
+		{control: "", add: []string{"ls\n", "ls\n", " pwd\n"}, lines: []string{"ls", "ls", " pwd"}},
+		{control: "ignoredups", add: []string{"ls\n", "ls\n", "pwd\n", "ls\n"}, lines: []string{"ls", "pwd", "ls"}},
+		{control: "ignorespace", add: []string{"ls\n", " pwd\n", "\tcd\n"}, lines: []string{"ls"}},
+		{control: "ignoreboth", add: []string{"ls\n", "ls\n", " pwd\n"}, lines: []string{"ls"}},
+		{control: "erasedups", add: []string{"ls\n", "pwd\n", "ls\n"}, lines: []string{"pwd", "ls"}},
+		{control: "", add: []string{"\n", "  \n"}, lines: []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.control, func(t *testing.T) {
+			historyEntries = historyEntries[:0]
+			t.Setenv("HISTCONTROL", c.control)
+			for _, line := range c.add {
+				AddHistory(line)
+			}
+			lines := History()
+			if strings.Join(lines, "|") != strings.Join(c.lines, "|") {
+				t.Errorf("history: %q, expected: %q", lines, c.lines)
+			}
+		})
+	}
+
+	// HISTSIZE drops the oldest entries, the numbers of the others stay
+	historyEntries = historyEntries[:0]
+	historyBase = 1
+	t.Setenv("HISTCONTROL", "")
+	t.Setenv("HISTSIZE", "2")
+	for _, line := range []string{"a", "b", "c"} {
+		AddHistory(line)
+	}
+	if lines := History(); strings.Join(lines, "|") != "b|c" || HistoryBase() != 2 {
+		t.Errorf("history: %q starting at %d, expected: [b c] starting at 2", lines, HistoryBase())
+	}
+}
@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"io"
+	"maps"
 	"os"
 	"strings"
 
@@ -12,7 +13,9 @@ type IoProvider struct {
 	DefaultOut io.WriteCloser
 	DefaultErr io.WriteCloser
 	DefaultIn  io.Reader
-	Closer     *iohelper.Closer
+	// Fds holds the default streams for file descriptors from 3 upwards, opened using exec.
+	Fds    FdTable
+	Closer *iohelper.Closer
 }
 
 func DefaultIoProvider() *IoProvider {
@@ -20,6 +23,7 @@ func DefaultIoProvider() *IoProvider {
 		DefaultOut: iohelper.WrapWriteFakeCloser(os.Stdout),
 		DefaultErr: iohelper.WrapWriteFakeCloser(os.Stderr),
 		DefaultIn:  os.Stdin,
+		Fds:        FdTable{},
 		Closer:     iohelper.NewCloser(),
 	}
 }
@@ -39,6 +43,7 @@ func TestIoProvider(stdin string) (*IoProvider, *strings.Builder, *strings.Build
 		DefaultOut: outW,
 		DefaultErr: errW,
 		DefaultIn:  inR,
+		Fds:        FdTable{},
 		Closer:     iohelper.NewCloser(),
 	}, outSB, errSB
 }
@@ -50,10 +55,61 @@ func SubshellIoProvider(parent *IoProvider) (*IoProvider, *strings.Builder) {
 		DefaultOut: w,
 		DefaultErr: parent.DefaultErr,
 		DefaultIn:  parent.DefaultIn,
+		Fds:        maps.Clone(parent.Fds),
 		Closer:     iohelper.NewCloser(),
 	}, sb
 }
 
+// Fd returns the default stream for the file descriptor n or nil if there is none.
+func (i *IoProvider) Fd(n int) *FileDescriptor {
+	switch n {
+	case 0:
+		return &FileDescriptor{Reader: i.DefaultIn}
+	case 1:
+		return &FileDescriptor{Writer: i.DefaultOut}
+	case 2:
+		return &FileDescriptor{Writer: i.DefaultErr}
+	default:
+		if fd, ok := i.Fds[n]; ok && !fd.isClosed() {
+			return fd
+		}
+		return nil
+	}
+}
+
+// setFd makes fd the default stream for the file descriptor n, nil closes it.
+func (i *IoProvider) setFd(n int, fd *FileDescriptor) {
+	switch n {
+	case 0:
+		if fd != nil && fd.Reader != nil {
+			i.DefaultIn = fd.Reader
+		} else {
+			i.DefaultIn = iohelper.BadFileDescriptor
+		}
+	case 1:
+		if fd != nil && fd.Writer != nil {
+			i.DefaultOut = fd.Writer
+		} else {
+			i.DefaultOut = iohelper.BadFileDescriptor
+		}
+	case 2:
+		if fd != nil && fd.Writer != nil {
+			i.DefaultErr = fd.Writer
+		} else {
+			i.DefaultErr = iohelper.BadFileDescriptor
+		}
+	default:
+		if i.Fds == nil {
+			i.Fds = FdTable{}
+		}
+		if fd != nil {
+			i.Fds.Open(n, fd)
+		} else {
+			delete(i.Fds, n)
+		}
+	}
+}
+
 func (i *IoProvider) Close() {
 	i.Closer.Close()
 }
@@ -0,0 +1,855 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// JobState is the state of a job in the job table.
+type JobState int
+
+const (
+	JobRunning JobState = iota
+	JobStopped
+	JobDone
+)
+
+// Job is a command started in the background with &, together with the other commands of its pipeline.
+// The commands run in goroutines, the processes they start are recorded, so the job can get signals.
+type Job struct {
+	ID   int
+	Text string
+
+	mutex sync.Mutex
+	state JobState
+	err   error
+	// pids are the processes of the job in the order they started,
+	// pgid is their process group if job control (set -m) is on
+	pids []int
+	pgid int
+	// syntheticPid stands in for the process ID of a job that had no process when its ID was needed
+	syntheticPid int
+	// started is closed when the first command of the job begins
+	started   chan struct{}
+	startOnce sync.Once
+	done      chan struct{}
+	// interrupted is closed when the job gets a signal that ends it, builtins like sleep stop then
+	interrupted   chan struct{}
+	interruptOnce sync.Once
+	signal        syscall.Signal
+}
+
+var (
+	jobsMutex sync.Mutex
+	jobTable  = make([]*Job, 0)
+	// lastJob is the job started last ($!)
+	lastJob *Job
+	// jobsChanged is closed and replaced whenever a job finishes, wait -n waits for it
+	jobsChanged = make(chan struct{})
+	// lastSyntheticPid is the synthetic process ID given out last, they start above the IDs of real processes
+	lastSyntheticPid = 1 << 30
+)
+
+// NewJob adds the commands of a background pipeline to the job table.
+// The last command of the pipeline must call Finish with its result.
+func NewJob(commands []*Command) *Job {
+	pipeline := make([]string, len(commands))
+	for i, c := range commands {
+		pipeline[i] = c.text()
+	}
+	j := &Job{
+		Text:        strings.Join(pipeline, " | "),
+		started:     make(chan struct{}),
+		done:        make(chan struct{}),
+		interrupted: make(chan struct{}),
+	}
+	for _, c := range commands {
+		c.setJob(j)
+	}
+
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	// like bash, the job number is one more than the highest number in use
+	j.ID = 1
+	if len(jobTable) != 0 {
+		j.ID = jobTable[len(jobTable)-1].ID + 1
+	}
+	jobTable = append(jobTable, j)
+	lastJob = j
+	return j
+}
+
+// text returns the command with its And and Or chain as it is shown by jobs.
+func (c *Command) text() string {
+	words := make([]string, 0, len(c.Assignments)+len(c.Arguments)+1)
+	for _, a := range c.Assignments {
+		words = append(words, a.Name+"="+Quote(a.Value))
+	}
+	if c.Executable != "" {
+		words = append(words, Quote(c.Executable))
+	}
+	for _, arg := range c.Arguments {
+		words = append(words, Quote(arg))
+	}
+	text := strings.Join(words, " ")
+	if c.And != nil {
+		text += " && " + c.And.text()
+	}
+	if c.Or != nil {
+		text += " || " + c.Or.text()
+	}
+	return text
+}
+
+func (c *Command) setJob(j *Job) {
+	c.Job = j
+	if c.And != nil {
+		c.And.setJob(j)
+	}
+	if c.Or != nil {
+		c.Or.setJob(j)
+	}
+}
+
+// run runs the process cmd of the command and waits for it.
+// In a job, the process is recorded and joins the process group of the job.
+func (c *Command) run(cmd *exec.Cmd) error {
+	if c.Job == nil {
+		return cmd.Run()
+	}
+	if err := c.Job.start(cmd); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// interrupted returns a channel that is closed when the job of the command gets a signal that ends it.
+// Without a job the channel is nil, so it never fires.
+func (c *Command) interrupted() <-chan struct{} {
+	if c.Job == nil {
+		return nil
+	}
+	return c.Job.interrupted
+}
+
+// interruptStatus is the result of a builtin that stopped because its job got a signal (128 + signal).
+func (c *Command) interruptStatus() error {
+	c.Job.mutex.Lock()
+	defer c.Job.mutex.Unlock()
+	return ExitStatus(128 + int(c.Job.signal))
+}
+
+// begin marks the job as started, either by a builtin or by the first process of the job.
+func (j *Job) begin() {
+	if j == nil {
+		return
+	}
+	j.startOnce.Do(func() {
+		close(j.started)
+	})
+}
+
+func (j *Job) start(cmd *exec.Cmd) error {
+	// process starts are serialized, so the processes of a pipeline end up in the same process group
+	j.mutex.Lock()
+	select {
+	case <-j.interrupted:
+		j.mutex.Unlock()
+		return ExitStatus(128 + int(j.signal))
+	default:
+	}
+	ownGroup := setProcessGroup(cmd, j.pgid)
+	err := cmd.Start()
+	if err == nil {
+		j.pids = append(j.pids, cmd.Process.Pid)
+		if ownGroup && j.pgid == 0 {
+			j.pgid = cmd.Process.Pid
+		}
+	}
+	j.mutex.Unlock()
+	j.begin()
+	return err
+}
+
+// Finish records the result of the job, it is called when the last command of the job is done.
+func (j *Job) Finish(err error) {
+	if j == nil {
+		return
+	}
+	j.mutex.Lock()
+	j.state = JobDone
+	j.err = err
+	if j.signal != 0 && err == nil {
+		// the last command of a killed job might be a builtin that didn't notice the signal, like cat
+		j.err = ExitStatus(128 + int(j.signal))
+	}
+	j.mutex.Unlock()
+	j.begin()
+	close(j.done)
+
+	jobsMutex.Lock()
+	close(jobsChanged)
+	jobsChanged = make(chan struct{})
+	jobsMutex.Unlock()
+}
+
+// Pid returns the process ID of the job, which is the first process it started.
+// A job that only ran builtins so far gets a synthetic process ID that wait, kill and jobs understand,
+// once it is given out the job keeps it.
+// It waits until the first command of the job begins.
+func (j *Job) Pid() int {
+	<-j.started
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.syntheticPid != 0 {
+		return j.syntheticPid
+	}
+	if len(j.pids) != 0 {
+		return j.pids[0]
+	}
+	jobsMutex.Lock()
+	lastSyntheticPid++
+	j.syntheticPid = lastSyntheticPid
+	jobsMutex.Unlock()
+	return j.syntheticPid
+}
+
+// State returns the state of the job and its exit status if it is done.
+func (j *Job) State() (JobState, int) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.state, exitCode(j.err)
+}
+
+// Wait waits until the job is done and returns its exit status.
+func (j *Job) Wait() int {
+	<-j.done
+	_, code := j.State()
+	return code
+}
+
+// kill sends the signal sig to the processes of the job and stops its builtins if the signal ends the job.
+func (j *Job) kill(sig syscall.Signal) error {
+	j.signaled(sig)
+	j.mutex.Lock()
+	if sig != 0 && sig != continueSignal && !stopSignals[sig] && !harmlessSignals[sig] {
+		// processes of the job that didn't start yet won't start anymore
+		j.interruptOnce.Do(func() {
+			j.signal = sig
+			close(j.interrupted)
+		})
+	}
+	pgid := j.pgid
+	pids := append([]int{}, j.pids...)
+	j.mutex.Unlock()
+
+	var err error
+	if pgid != 0 {
+		err = signalGroup(pgid, sig)
+	} else {
+		for _, pid := range pids {
+			// some of the processes might be done already
+			_ = signalProcess(pid, sig)
+		}
+	}
+	return err
+}
+
+// signaled updates the state of the job after its processes got the signal sig.
+func (j *Job) signaled(sig syscall.Signal) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.state == JobDone {
+		return
+	}
+	switch {
+	case stopSignals[sig]:
+		j.state = JobStopped
+	case sig == continueSignal:
+		j.state = JobRunning
+	}
+}
+
+// resume continues a stopped job.
+func (j *Job) resume() error {
+	if state, _ := j.State(); state != JobStopped {
+		return nil
+	}
+	return j.kill(continueSignal)
+}
+
+// format returns the line that jobs prints for the job, marker is + for the current job and - for the previous one.
+func (j *Job) format(marker byte, long bool) string {
+	state, code := j.State()
+	text := j.Text
+	description := ""
+	switch {
+	case state == JobRunning:
+		description = "Running"
+		text += " &"
+	case state == JobStopped:
+		description = "Stopped"
+	case code == 0:
+		description = "Done"
+	case code > 128 && signalDescriptions[syscall.Signal(code-128)] != "":
+		description = signalDescriptions[syscall.Signal(code-128)]
+	default:
+		description = "Exit " + strconv.Itoa(code)
+	}
+	if long {
+		return fmt.Sprintf("[%d]%c %d %-24s%s\n", j.ID, marker, j.Pid(), description, text)
+	}
+	return fmt.Sprintf("[%d]%c  %-24s%s\n", j.ID, marker, description, text)
+}
+
+// signalDescriptions are the states jobs shows for a job that was killed by one of these signals.
+var signalDescriptions = map[syscall.Signal]string{
+	syscall.SIGHUP:  "Hangup",
+	syscall.SIGINT:  "Interrupt",
+	syscall.SIGKILL: "Killed",
+	syscall.SIGTERM: "Terminated",
+}
+
+// jobs returns a copy of the job table.
+func jobs() []*Job {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	return append([]*Job{}, jobTable...)
+}
+
+// marker returns + for the current job (the newest one) and - for the previous one.
+func marker(table []*Job, j *Job) byte {
+	switch {
+	case len(table) > 0 && table[len(table)-1] == j:
+		return '+'
+	case len(table) > 1 && table[len(table)-2] == j:
+		return '-'
+	default:
+		return ' '
+	}
+}
+
+func removeJob(j *Job) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	for i, other := range jobTable {
+		if other == j {
+			jobTable = append(jobTable[:i], jobTable[i+1:]...)
+			return
+		}
+	}
+}
+
+// findJob returns the job of a job specification like %1, %%, %+, %-, %name or %?text.
+func findJob(spec string) (*Job, error) {
+	table := jobs()
+	name, _ := strings.CutPrefix(spec, "%")
+	switch {
+	case name == "" || name == "%" || name == "+":
+		if len(table) != 0 {
+			return table[len(table)-1], nil
+		}
+		return nil, fmt.Errorf("%s: no current job", spec)
+	case name == "-":
+		if len(table) > 1 {
+			return table[len(table)-2], nil
+		}
+		return nil, fmt.Errorf("%s: no previous job", spec)
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		for _, j := range table {
+			if j.ID == n {
+				return j, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	var found *Job
+	for _, j := range table {
+		var ok bool
+		if text, contains := strings.CutPrefix(name, "?"); contains {
+			ok = strings.Contains(j.Text, text)
+		} else {
+			ok = strings.HasPrefix(j.Text, name)
+		}
+		if !ok {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%s: ambiguous job spec", spec)
+		}
+		found = j
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return found, nil
+}
+
+// jobByPid returns the job that started the process pid or has pid as its synthetic process ID, or nil.
+func jobByPid(pid int) *Job {
+	for _, j := range jobs() {
+		j.mutex.Lock()
+		found := slices.Contains(j.pids, pid) || j.syntheticPid == pid
+		j.mutex.Unlock()
+		if found {
+			return j
+		}
+	}
+	return nil
+}
+
+// hasSyntheticPid reports whether pid is the synthetic process ID of the job.
+func (j *Job) hasSyntheticPid(pid int) bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.syntheticPid == pid
+}
+
+// LastJobPid returns the process ID of the last background job ($!) or "" if there is none.
+func LastJobPid() string {
+	jobsMutex.Lock()
+	j := lastJob
+	jobsMutex.Unlock()
+	if j == nil {
+		return ""
+	}
+	return strconv.Itoa(j.Pid())
+}
+
+// NotifyJobs prints the jobs that are done since the last notice to w and removes them from the job table.
+// The interactive shell calls it before each prompt.
+func NotifyJobs(w io.Writer) {
+	table := jobs()
+	for _, j := range table {
+		if state, _ := j.State(); state == JobDone {
+			_, _ = io.WriteString(w, j.format(marker(table, j), false))
+			removeJob(j)
+		}
+	}
+}
+
+// statusError returns the error for the exit status code.
+func statusError(code int) error {
+	if code == 0 {
+		return nil
+	}
+	return ExitStatus(code)
+}
+
+func execute_jobs(c *Command, _ *IoProvider) error {
+	long, pidsOnly, running, stopped := false, false, false, false
+	args := c.Arguments
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'l':
+				long = true
+			case 'p':
+				pidsOnly = true
+			case 'r':
+				running = true
+			case 's':
+				stopped = true
+			default:
+				_, _ = fmt.Fprintf(c.Stderr(), "jobs: -%c: invalid option\n", flag)
+				_, _ = fmt.Fprintln(c.Stderr(), "jobs: usage: jobs [-lprs] [jobspec ...]")
+				return ExitStatus(2)
+			}
+		}
+		args = args[1:]
+	}
+
+	table := jobs()
+	selected := table
+	var status error
+	if len(args) != 0 {
+		selected = make([]*Job, 0, len(args))
+		for _, spec := range args {
+			j, err := findJob(spec)
+			if err != nil {
+				_, _ = fmt.Fprintf(c.Stderr(), "jobs: %s\n", err)
+				status = ExitStatus(1)
+				continue
+			}
+			selected = append(selected, j)
+		}
+	}
+	out := strings.Builder{}
+	for _, j := range selected {
+		state, _ := j.State()
+		if (running && state != JobRunning) || (stopped && state != JobStopped) {
+			continue
+		}
+		if pidsOnly {
+			out.WriteString(strconv.Itoa(j.Pid()) + "\n")
+			continue
+		}
+		out.WriteString(j.format(marker(table, j), long))
+		if state == JobDone {
+			// like a notice, a job that is done is reported once
+			removeJob(j)
+		}
+	}
+	if _, err := io.WriteString(c.Stdout(), out.String()); err != nil {
+		return err
+	}
+	return status
+}
+
+func execute_wait(c *Command, _ *IoProvider) error {
+	args := c.Arguments
+	next := false
+	if len(args) > 0 && args[0] == "-n" {
+		next = true
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+
+	// the jobs to wait for, nil for unknown ones
+	waitFor := make([]*Job, 0, len(args))
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "%") {
+			j, err := findJob(arg)
+			if err != nil {
+				_, _ = fmt.Fprintf(c.Stderr(), "wait: %s\n", err)
+			}
+			waitFor = append(waitFor, j)
+			continue
+		}
+		pid, err := strconv.Atoi(arg)
+		if err != nil {
+			_, _ = fmt.Fprintf(c.Stderr(), "wait: `%s': not a pid or valid job spec\n", arg)
+			return ExitStatus(2)
+		}
+		j := jobByPid(pid)
+		if j == nil {
+			_, _ = fmt.Fprintf(c.Stderr(), "wait: pid %d is not a child of this shell\n", pid)
+		}
+		waitFor = append(waitFor, j)
+	}
+
+	if next {
+		return waitNext(waitFor, len(args) != 0)
+	}
+	if len(args) == 0 {
+		// without arguments wait waits for all jobs and its status is 0
+		for _, j := range jobs() {
+			j.Wait()
+			removeJob(j)
+		}
+		return nil
+	}
+	code := 0
+	for _, j := range waitFor {
+		if j == nil {
+			code = 127
+			continue
+		}
+		code = j.Wait()
+		removeJob(j)
+	}
+	return statusError(code)
+}
+
+// waitNext waits until one of the jobs is done (any job if there is no selection) and returns its exit status.
+// A job that is done already and was not waited for counts too.
+func waitNext(selection []*Job, selected bool) error {
+	for {
+		jobsMutex.Lock()
+		table := append([]*Job{}, jobTable...)
+		changed := jobsChanged
+		jobsMutex.Unlock()
+		if selected {
+			table = make([]*Job, 0, len(selection))
+			for _, j := range selection {
+				if j != nil && slices.Contains(jobs(), j) {
+					table = append(table, j)
+				}
+			}
+		}
+		if len(table) == 0 {
+			return ExitStatus(127)
+		}
+		for _, j := range table {
+			if state, code := j.State(); state == JobDone {
+				removeJob(j)
+				return statusError(code)
+			}
+		}
+		<-changed
+	}
+}
+
+// jobOrCurrent returns the job of the first argument or the current job.
+func jobOrCurrent(c *Command) (*Job, error) {
+	spec := "%+"
+	if len(c.Arguments) != 0 {
+		spec = c.Arguments[0]
+	}
+	j, err := findJob(spec)
+	if err != nil && len(c.Arguments) == 0 {
+		return nil, errors.New("current: no such job")
+	}
+	return j, err
+}
+
+func execute_fg(c *Command, _ *IoProvider) error {
+	j, err := jobOrCurrent(c)
+	if err != nil {
+		_, _ = fmt.Fprintf(c.Stderr(), "fg: %s\n", err)
+		return ExitStatus(1)
+	}
+	_, _ = fmt.Fprintln(c.Stdout(), j.Text)
+	// the job gets the terminal, so it can read from it and receives Ctrl+C
+	restore := foreground(j)
+	err = j.resume()
+	code := j.Wait()
+	restore()
+	removeJob(j)
+	if err != nil {
+		_, _ = fmt.Fprintf(c.Stderr(), "fg: %s\n", err)
+	}
+	return statusError(code)
+}
+
+func execute_bg(c *Command, _ *IoProvider) error {
+	specs := c.Arguments
+	if len(specs) == 0 {
+		specs = []string{"%+"}
+	}
+	var status error
+	for _, spec := range specs {
+		j, err := findJob(spec)
+		if err != nil {
+			if len(c.Arguments) == 0 {
+				err = errors.New("current: no such job")
+			}
+			_, _ = fmt.Fprintf(c.Stderr(), "bg: %s\n", err)
+			status = ExitStatus(1)
+			continue
+		}
+		switch state, _ := j.State(); state {
+		case JobDone:
+			_, _ = fmt.Fprintf(c.Stderr(), "bg: job %d has terminated\n", j.ID)
+			status = ExitStatus(1)
+		case JobRunning:
+			_, _ = fmt.Fprintf(c.Stderr(), "bg: job %d already in background\n", j.ID)
+		default:
+			if err := j.resume(); err != nil {
+				_, _ = fmt.Fprintf(c.Stderr(), "bg: %s\n", err)
+				status = ExitStatus(1)
+				continue
+			}
+			_, _ = fmt.Fprintf(c.Stdout(), "[%d]%c %s &\n", j.ID, marker(jobs(), j), j.Text)
+		}
+	}
+	return status
+}
+
+func execute_disown(c *Command, _ *IoProvider) error {
+	all, running := false, false
+	args := c.Arguments
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'a':
+				all = true
+			case 'r':
+				running = true
+			case 'h':
+				// the shell doesn't send SIGHUP to its jobs, so every job is kept alive
+			default:
+				_, _ = fmt.Fprintf(c.Stderr(), "disown: -%c: invalid option\n", flag)
+				_, _ = fmt.Fprintln(c.Stderr(), "disown: usage: disown [-h] [-ar] [jobspec ... | pid ...]")
+				return ExitStatus(2)
+			}
+		}
+		args = args[1:]
+	}
+
+	selected := make([]*Job, 0)
+	var status error
+	switch {
+	case all || (running && len(args) == 0):
+		selected = jobs()
+	case len(args) == 0:
+		j, err := findJob("%+")
+		if err != nil {
+			_, _ = fmt.Fprintln(c.Stderr(), "disown: current: no such job")
+			return ExitStatus(1)
+		}
+		selected = append(selected, j)
+	}
+	for _, arg := range args {
+		var j *Job
+		var err error
+		if pid, pidErr := strconv.Atoi(arg); pidErr == nil {
+			if j = jobByPid(pid); j == nil {
+				err = fmt.Errorf("%s: no such job", arg)
+			}
+		} else {
+			j, err = findJob(arg)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(c.Stderr(), "disown: %s\n", err)
+			status = ExitStatus(1)
+			continue
+		}
+		selected = append(selected, j)
+	}
+	for _, j := range selected {
+		if state, _ := j.State(); running && state != JobRunning {
+			continue
+		}
+		removeJob(j)
+	}
+	return status
+}
+
+// killSignal returns the signal of a specification like TERM, SIGTERM, term or 15.
+func killSignal(spec string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		return syscall.Signal(n), n >= 0
+	}
+	name := strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	for _, s := range append(signals, killSignals...) {
+		if s.name == name {
+			return s.signal, true
+		}
+	}
+	return 0, false
+}
+
+func execute_kill(c *Command, _ *IoProvider) error {
+	args := c.Arguments
+	sig := syscall.SIGTERM
+	invalid := func(spec string) error {
+		_, _ = fmt.Fprintf(c.Stderr(), "kill: %s: invalid signal specification\n", spec)
+		return ExitStatus(1)
+	}
+	if len(args) > 0 {
+		switch arg := args[0]; {
+		case arg == "-l" || arg == "-L":
+			return listSignals(c, args[1:])
+		case arg == "-s" || arg == "-n":
+			if len(args) < 2 {
+				_, _ = fmt.Fprintf(c.Stderr(), "kill: %s: option requires an argument\n", arg)
+				return ExitStatus(2)
+			}
+			var ok bool
+			if sig, ok = killSignal(args[1]); !ok {
+				return invalid(args[1])
+			}
+			args = args[2:]
+		case arg == "--":
+			args = args[1:]
+		case len(arg) > 1 && arg[0] == '-':
+			var ok bool
+			if sig, ok = killSignal(arg[1:]); !ok {
+				return invalid(arg[1:])
+			}
+			args = args[1:]
+		}
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		_, _ = fmt.Fprintln(c.Stderr(), "kill: usage: kill [-s sigspec | -n signum | -sigspec] pid | jobspec ... or kill -l [sigspec]")
+		return ExitStatus(2)
+	}
+
+	var status error
+	for _, arg := range args {
+		var err error
+		if strings.HasPrefix(arg, "%") {
+			var j *Job
+			if j, err = findJob(arg); err == nil {
+				if state, _ := j.State(); state == JobDone {
+					err = fmt.Errorf("%s: no such job", arg)
+				} else {
+					err = j.kill(sig)
+				}
+			}
+		} else if pid, pidErr := strconv.Atoi(arg); pidErr == nil {
+			j := jobByPid(pid)
+			if j != nil && j.hasSyntheticPid(pid) {
+				// there is no process, the signal stops the builtins of the job
+				err = j.kill(sig)
+			} else {
+				if j != nil {
+					j.signaled(sig)
+				}
+				if err = signalProcess(pid, sig); err != nil {
+					err = fmt.Errorf("(%d) - %w", pid, err)
+				}
+			}
+		} else {
+			err = fmt.Errorf("%s: arguments must be process or job IDs", arg)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(c.Stderr(), "kill: %s\n", err)
+			status = ExitStatus(1)
+		}
+	}
+	return status
+}
+
+// listSignals prints the signal names for kill -l, or the names of the given signal numbers and exit statuses.
+func listSignals(c *Command, args []string) error {
+	all := append(append([]struct {
+		name   string
+		signal syscall.Signal
+	}{}, signals...), killSignals...)
+	if len(args) == 0 {
+		names := make([]string, len(all))
+		for i, s := range all {
+			names[i] = s.name
+		}
+		_, _ = fmt.Fprintln(c.Stdout(), strings.Join(names, " "))
+		return nil
+	}
+	var status error
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil {
+			// the exit status of a process killed by a signal is 128 + signal
+			if n > 128 {
+				n -= 128
+			}
+			found := false
+			for _, s := range all {
+				if int(s.signal) == n {
+					_, _ = fmt.Fprintln(c.Stdout(), s.name)
+					found = true
+					break
+				}
+			}
+			if !found {
+				_, _ = fmt.Fprintf(c.Stderr(), "kill: %s: invalid signal specification\n", arg)
+				status = ExitStatus(1)
+			}
+			continue
+		}
+		if sig, ok := killSignal(arg); ok {
+			_, _ = fmt.Fprintln(c.Stdout(), int(sig))
+		} else {
+			_, _ = fmt.Fprintf(c.Stderr(), "kill: %s: invalid signal specification\n", arg)
+			status = ExitStatus(1)
+		}
+	}
+	return status
+}
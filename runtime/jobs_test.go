@@ -0,0 +1,124 @@
+package runtime
+
+import (
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJobPidWithoutProcess(t *testing.T) {
+	defer func() {
+		NotifyJobs(io.Discard)
+		_ = UnsetVariable("JOB_ASSIGNED")
+	}()
+	cases := []struct {
+		name    string
+		command func(*Command)
+	}{
+		{
+			name: "assignment",
+			command: func(c *Command) {
+				c.Assignments = []Assignment{{Name: "JOB_ASSIGNED", Value: "1"}}
+			},
+		},
+		{
+			name: "builtin",
+			command: func(c *Command) {
+				c.Executable = "true"
+			},
+		},
+		{
+			name: "failed redirection",
+			command: func(c *Command) {
+				c.Executable = "true"
+				c.RedirectionErr = io.ErrUnexpectedEOF
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			iop, _, _ := TestIoProvider("")
+			defer iop.Close()
+			cmd := NewCommand(iop)
+			cmd.Background = true
+			c.command(cmd)
+			j := NewJob([]*Command{cmd})
+			defer j.Finish(nil)
+			_ = cmd.Execute(iop)
+
+			// the job isn't finished yet, Pid must not wait for it
+			pid := make(chan int, 1)
+			go func() {
+				pid <- j.Pid()
+			}()
+			select {
+			case p := <-pid:
+				// a job without a process gets a synthetic process ID that stays the same
+				if !j.hasSyntheticPid(p) || j.Pid() != p {
+					t.Errorf("pid: %d, expected a synthetic process ID", p)
+				}
+				if jobByPid(p) != j {
+					t.Errorf("no job found for the synthetic process ID %d", p)
+				}
+				if LastJobPid() != strconv.Itoa(p) {
+					t.Errorf("$!: %q, expected: %d", LastJobPid(), p)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Pid waits for a job that has no process")
+			}
+		})
+	}
+}
+
+func TestFindJob(t *testing.T) {
+	iop, _, _ := TestIoProvider("")
+	defer iop.Close()
+	defer func(last *Job) { lastJob = last }(lastJob)
+	started := make([]*Job, 0, 3)
+	for _, args := range [][]string{{"sleep", "5"}, {"sleep", "10"}, {"grep", "x"}} {
+		cmd := NewCommand(iop)
+		cmd.Executable = args[0]
+		cmd.Arguments = args[1:]
+		j := NewJob([]*Command{cmd})
+		defer removeJob(j)
+		started = append(started, j)
+	}
+	first, second, third := started[0], started[1], started[2]
+
+	cases := []struct {
+		spec   string
+		expect *Job
+	}{
+		{spec: "%%", expect: third},
+		{spec: "%+", expect: third},
+		{spec: "%", expect: third},
+		{spec: "%-", expect: second},
+		{spec: "%" + strconv.Itoa(first.ID), expect: first},
+		{spec: "%" + strconv.Itoa(third.ID+1)},
+		{spec: "%grep", expect: third},
+		// both sleep jobs match
+		{spec: "%sleep"},
+		{spec: "%?10", expect: second},
+		{spec: "%?x", expect: third},
+		{spec: "%?e"},
+		{spec: "%cat"},
+	}
+	for _, c := range cases {
+		t.Run(c.spec, func(t *testing.T) {
+			j, err := findJob(c.spec)
+			if c.expect == nil {
+				if err == nil {
+					t.Errorf("found job %d %q, expected an error", j.ID, j.Text)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if j != c.expect {
+				t.Errorf("found job %d %q, expected: %d %q", j.ID, j.Text, c.expect.ID, c.expect.Text)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+//go:build !windows
+
+package runtime
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+const continueSignal = syscall.SIGCONT
+
+// stopSignals are the signals that stop a job, harmlessSignals neither stop nor end it.
+var (
+	stopSignals = map[syscall.Signal]bool{
+		syscall.SIGSTOP: true,
+		syscall.SIGTSTP: true,
+		syscall.SIGTTIN: true,
+		syscall.SIGTTOU: true,
+	}
+	harmlessSignals = map[syscall.Signal]bool{
+		syscall.SIGCHLD:  true,
+		syscall.SIGWINCH: true,
+		syscall.SIGURG:   true,
+	}
+)
+
+// setProcessGroup puts the process cmd into the process group pgid, 0 creates a new group.
+// It reports whether the process gets its own group, which is only the case if job control (set -m) is on.
+func setProcessGroup(cmd *exec.Cmd, pgid int) bool {
+	if !Option("monitor") {
+		return false
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: pgid}
+	return true
+}
+
+func signalProcess(pid int, sig syscall.Signal) error {
+	return syscall.Kill(pid, sig)
+}
+
+func signalGroup(pgid int, sig syscall.Signal) error {
+	return syscall.Kill(-pgid, sig)
+}
+
+// foreground makes the process group of the job the foreground process group of the terminal,
+// restore gives the terminal back to the shell.
+func foreground(j *Job) (restore func()) {
+	j.mutex.Lock()
+	pgid := j.pgid
+	j.mutex.Unlock()
+	if _, ok := isTerminal(os.Stdin); !ok || pgid == 0 {
+		return func() {}
+	}
+	if setForegroundGroup(pgid) != nil {
+		return func() {}
+	}
+	return func() {
+		// the shell is in the background now, taking the terminal back would stop it without ignoring SIGTTOU
+		trapsMutex.Lock()
+		defer trapsMutex.Unlock()
+		signal.Ignore(syscall.SIGTTOU)
+		_ = setForegroundGroup(syscall.Getpgrp())
+		// updateSignals sets the action of the trap for SIGTTOU again
+		signalActions[syscall.SIGTTOU] = signalIgnore
+		updateSignals()
+	}
+}
+
+func setForegroundGroup(pgid int) error {
+	id := int32(pgid)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), uintptr(syscall.TIOCSPGRP), uintptr(unsafe.Pointer(&id)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+//go:build windows
+
+package runtime
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// continueSignal is a value no signal has, jobs can't be stopped on Windows.
+const continueSignal = syscall.Signal(-1)
+
+// stopSignals are the signals that stop a job, harmlessSignals neither stop nor end it.
+var (
+	stopSignals     = map[syscall.Signal]bool{}
+	harmlessSignals = map[syscall.Signal]bool{}
+)
+
+// setProcessGroup does nothing, Windows has no process groups like Unix.
+func setProcessGroup(_ *exec.Cmd, _ int) bool {
+	return false
+}
+
+// signalProcess can only kill a process, INT and TERM kill it too.
+func signalProcess(pid int, sig syscall.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	switch sig {
+	case 0:
+		return nil
+	case syscall.SIGKILL, syscall.SIGTERM, syscall.SIGINT:
+		return p.Kill()
+	}
+	return errors.New("signal not supported on windows")
+}
+
+func signalGroup(_ int, _ syscall.Signal) error {
+	return errors.New("process groups are not supported on windows")
+}
+
+// foreground does nothing, the processes of a job share the console with the shell.
+func foreground(_ *Job) (restore func()) {
+	return func() {}
+}
@@ -0,0 +1,58 @@
+package runtime
+
+import (
+	"fmt"
+	"sync"
+)
+
+type shellOption struct {
+	name string
+	// flag is the single letter form (set -C), 0 if there is none
+	flag byte
+}
+
+// shellOptions lists the options that can be changed using set -o in the order set -o prints them.
+var shellOptions = []shellOption{
+	{"errexit", 'e'},
+	{"histexpand", 'H'},
+	{"monitor", 'm'},
+	{"noclobber", 'C'},
+	{"nounset", 'u'},
+	{"pipefail", 0},
+	{"xtrace", 'x'},
+}
+
+var (
+	optionsMutex sync.RWMutex
+	options      = map[string]bool{}
+)
+
+// Option reports whether the shell option with the given name is enabled.
+func Option(name string) bool {
+	optionsMutex.RLock()
+	defer optionsMutex.RUnlock()
+	return options[name]
+}
+
+// SetOption enables or disables the shell option with the given name.
+func SetOption(name string, value bool) error {
+	for _, o := range shellOptions {
+		if o.name == name {
+			optionsMutex.Lock()
+			defer optionsMutex.Unlock()
+			options[name] = value
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: invalid option name", name)
+}
+
+// optionByFlag returns the name of the shell option with the given single letter form.
+func optionByFlag(flag byte) (string, bool) {
+	for _, o := range shellOptions {
+		if o.flag != 0 && o.flag == flag {
+			return o.name, true
+		}
+	}
+	return "", false
+}
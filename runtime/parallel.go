@@ -0,0 +1,226 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tsukinoko-kun/ohmygosh/iohelper"
+)
+
+// lockedBuffer collects the output of a job, the commands of the job can write to it concurrently.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+// parallelJob is a command of parallel with its buffered output.
+type parallelJob struct {
+	command string
+	stdout  lockedBuffer
+	stderr  lockedBuffer
+	err     error
+	done    chan struct{}
+}
+
+// parallelPlaceholders replaces the placeholders of the command template with the quoted input.
+// {} is the input, {.} the input without extension, {/} its base name, {//} its directory and {/.} the base name
+// without extension. With several input sources {1}, {2}, ... are the inputs of the single sources.
+func parallelPlaceholders(template string, inputs []string) string {
+	input := strings.Join(inputs, " ")
+	base := filepath.Base(input)
+	replacements := []string{
+		"{}", Quote(input),
+		"{.}", Quote(strings.TrimSuffix(input, filepath.Ext(input))),
+		"{/}", Quote(base),
+		"{//}", Quote(filepath.Dir(input)),
+		"{/.}", Quote(strings.TrimSuffix(base, filepath.Ext(base))),
+	}
+	for i, in := range inputs {
+		replacements = append(replacements, "{"+strconv.Itoa(i+1)+"}", Quote(in))
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+// hasPlaceholder reports whether the command template uses the input, otherwise it gets appended.
+func hasPlaceholder(template string, sources int) bool {
+	for _, p := range []string{"{}", "{.}", "{/}", "{//}", "{/.}"} {
+		if strings.Contains(template, p) {
+			return true
+		}
+	}
+	for i := 1; i <= sources; i++ {
+		if strings.Contains(template, "{"+strconv.Itoa(i)+"}") {
+			return true
+		}
+	}
+	return false
+}
+
+// combinations returns every combination of one input of each source, like GNU parallel does for several :::.
+func combinations(sources [][]string) [][]string {
+	result := [][]string{{}}
+	for _, source := range sources {
+		next := make([][]string, 0, len(result)*len(source))
+		for _, combination := range result {
+			for _, input := range source {
+				next = append(next, append(append([]string{}, combination...), input))
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+func execute_parallel(c *Command, iop *IoProvider) error {
+	jobs := goruntime.NumCPU()
+	keepOrder := false
+	args := c.Arguments
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		arg := args[0]
+		args = args[1:]
+		switch {
+		case arg == "--":
+		case arg == "-k" || arg == "--keep-order":
+			keepOrder = true
+		case arg == "-j" || arg == "--jobs" || strings.HasPrefix(arg, "-j") || strings.HasPrefix(arg, "--jobs="):
+			value := strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(arg, "--jobs"), "-j"), "=")
+			if value == "" {
+				if len(args) == 0 {
+					_, _ = fmt.Fprintln(c.Stderr(), "parallel: option -j requires an argument")
+					return ExitStatus(255)
+				}
+				value, args = args[0], args[1:]
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				_, _ = fmt.Fprintf(c.Stderr(), "parallel: invalid number of jobs: %s\n", value)
+				return ExitStatus(255)
+			}
+			// -j 0 runs all jobs at once
+			jobs = n
+		default:
+			_, _ = fmt.Fprintf(c.Stderr(), "parallel: unknown option: %s\n", arg)
+			return ExitStatus(255)
+		}
+		if arg == "--" {
+			break
+		}
+	}
+
+	// the command template ends at the first :::, the inputs follow
+	template := args
+	sources := make([][]string, 0)
+	for i, arg := range args {
+		if arg != ":::" {
+			continue
+		}
+		if len(sources) == 0 {
+			template = args[:i]
+		}
+		sources = append(sources, []string{})
+		for _, input := range args[i+1:] {
+			if input == ":::" {
+				break
+			}
+			sources[len(sources)-1] = append(sources[len(sources)-1], input)
+		}
+	}
+	if len(sources) == 0 {
+		// one input per line of stdin
+		lines := make([]string, 0)
+		scanner := bufio.NewScanner(c.Stdin())
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			_, _ = fmt.Fprintf(c.Stderr(), "parallel: %s\n", err)
+			return errors.Join(errors.New("parallel: failed to read input"), err)
+		}
+		sources = append(sources, lines)
+	}
+	command := strings.Join(template, " ")
+	if command != "" && !hasPlaceholder(command, len(sources)) {
+		command += " {}"
+	}
+
+	inputs := combinations(sources)
+	queue := make([]*parallelJob, len(inputs))
+	for i, in := range inputs {
+		queue[i] = &parallelJob{done: make(chan struct{})}
+		if command == "" {
+			// without a command template the inputs are the commands
+			queue[i].command = strings.Join(in, " ")
+		} else {
+			queue[i].command = parallelPlaceholders(command, in)
+		}
+	}
+	if jobs == 0 || jobs > len(queue) {
+		jobs = max(len(queue), 1)
+	}
+
+	// the jobs run in background goroutines, at most jobs at the same time
+	slots := make(chan struct{}, jobs)
+	finished := make(chan *parallelJob, len(queue))
+	go func() {
+		for _, job := range queue {
+			slots <- struct{}{}
+			go func(job *parallelJob) {
+				defer func() {
+					<-slots
+					close(job.done)
+					finished <- job
+				}()
+				jobIop := &IoProvider{
+					DefaultOut: iohelper.WrapWriteFakeCloser(&job.stdout),
+					DefaultErr: iohelper.WrapWriteFakeCloser(&job.stderr),
+					DefaultIn:  strings.NewReader(""),
+					Fds:        maps.Clone(iop.Fds),
+					Closer:     iohelper.NewCloser(),
+				}
+				defer jobIop.Close()
+				job.err = Interpret(job.command, jobIop)
+			}(job)
+		}
+	}()
+
+	// the output of a job is written at once when it is done, so the output of jobs never interleaves
+	failed := 0
+	var writeErr error
+	for i := range queue {
+		job := <-finished
+		if keepOrder {
+			job = queue[i]
+			<-job.done
+		}
+		if job.err != nil {
+			failed++
+		}
+		if writeErr == nil {
+			_, writeErr = io.Copy(c.Stdout(), &job.stdout.buffer)
+		}
+		_, _ = io.Copy(c.Stderr(), &job.stderr.buffer)
+	}
+	if isBrokenPipe(writeErr) {
+		return brokenPipeStatus
+	}
+	if failed != 0 {
+		// like GNU parallel, the exit status is the number of failed jobs
+		return ExitStatus(min(failed, 101))
+	}
+	return nil
+}
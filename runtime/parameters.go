@@ -0,0 +1,44 @@
+package runtime
+
+import (
+	"slices"
+	"sync"
+)
+
+var (
+	parametersMutex sync.RWMutex
+	// positionalParameters are $1, $2, ...
+	positionalParameters []string
+	// scriptName is $0
+	scriptName = "ohmygosh"
+)
+
+// PositionalParameters returns the positional parameters $1, $2, ...
+func PositionalParameters() []string {
+	parametersMutex.RLock()
+	defer parametersMutex.RUnlock()
+	return slices.Clone(positionalParameters)
+}
+
+// SetPositionalParameters replaces the positional parameters and returns the old ones.
+func SetPositionalParameters(args []string) []string {
+	parametersMutex.Lock()
+	defer parametersMutex.Unlock()
+	old := positionalParameters
+	positionalParameters = slices.Clone(args)
+	return old
+}
+
+// ScriptName returns the name of the shell or the running script ($0).
+func ScriptName() string {
+	parametersMutex.RLock()
+	defer parametersMutex.RUnlock()
+	return scriptName
+}
+
+// SetScriptName sets the name of the running script ($0).
+func SetScriptName(name string) {
+	parametersMutex.Lock()
+	defer parametersMutex.Unlock()
+	scriptName = name
+}
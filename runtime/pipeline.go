@@ -0,0 +1,97 @@
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Pipeline is the state shared by the commands of a pipeline (cmd1 | cmd2 | ...) or of a timed command.
+// It is needed where the pipeline has to be looked at as a whole:
+// the time keyword measures all of its commands and with set -o pipefail its exit status depends on all of them.
+type Pipeline struct {
+	// Time is set by the time keyword, Posix selects the output format of time -p.
+	Time    bool
+	Posix   bool
+	running sync.WaitGroup
+	once    sync.Once
+	mutex   sync.Mutex
+	start   time.Time
+	user    time.Duration
+	sys     time.Duration
+	// errs holds the result of every command in the order of the pipeline
+	errs []error
+}
+
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// Add appends the command to the pipeline.
+// It must be called before any command of the pipeline gets executed.
+func (p *Pipeline) Add(c *Command) {
+	c.Pipeline = p
+	c.pipelineIndex = len(p.errs)
+	p.errs = append(p.errs, nil)
+	p.running.Add(1)
+}
+
+func (p *Pipeline) begin() {
+	p.once.Do(func() {
+		p.start = time.Now()
+	})
+}
+
+// done records the result of the command with the given index, state is nil for builtins.
+func (p *Pipeline) done(index int, state *os.ProcessState, err error) {
+	p.mutex.Lock()
+	if state != nil {
+		p.user += state.UserTime()
+		p.sys += state.SystemTime()
+	}
+	p.errs[index] = err
+	p.mutex.Unlock()
+	p.running.Done()
+}
+
+// finish is called by the last command of the pipeline with its result.
+// If needed, it waits for the other commands, reports the times to w and returns the exit status of the pipeline.
+func (p *Pipeline) finish(w io.Writer, err error) error {
+	pipefail := Option("pipefail")
+	if !p.Time && !pipefail {
+		return err
+	}
+	p.running.Wait()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.Time {
+		p.report(w)
+	}
+	if pipefail {
+		// the status of the last command that failed
+		for i := len(p.errs) - 1; i >= 0; i-- {
+			if p.errs[i] != nil {
+				return p.errs[i]
+			}
+		}
+	}
+	return err
+}
+
+func (p *Pipeline) report(w io.Writer) {
+	elapsed := time.Since(p.start)
+	if p.Posix {
+		_, _ = fmt.Fprintf(w, "real %.2f\nuser %.2f\nsys %.2f\n", elapsed.Seconds(), p.user.Seconds(), p.sys.Seconds())
+		return
+	}
+	_, _ = fmt.Fprintf(w, "\nreal\t%s\nuser\t%s\nsys\t%s\n", formatDuration(elapsed), formatDuration(p.user), formatDuration(p.sys))
+}
+
+// formatDuration formats d like bash does in the output of time (0m0.000s).
+func formatDuration(d time.Duration) string {
+	minutes := int64(d / time.Minute)
+	seconds := (d % time.Minute).Seconds()
+	return fmt.Sprintf("%dm%.3fs", minutes, seconds)
+}
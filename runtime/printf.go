@@ -0,0 +1,378 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// printfState holds the arguments of printf and the errors of their conversions.
+type printfState struct {
+	args []string
+	// used is the number of arguments consumed in the current pass over the format
+	used   int
+	failed bool
+	stderr func(format string, a ...any)
+}
+
+func (p *printfState) next() (string, bool) {
+	if len(p.args) == 0 {
+		return "", false
+	}
+	arg := p.args[0]
+	p.args = p.args[1:]
+	p.used++
+	return arg, true
+}
+
+// integer converts a numeric argument like bash: a leading quote gives the code of the following character.
+func (p *printfState) integer(arg string) int64 {
+	if arg == "" {
+		return 0
+	}
+	if arg[0] == '\'' || arg[0] == '"' {
+		r, _ := utf8.DecodeRuneInString(arg[1:])
+		if r == utf8.RuneError {
+			return 0
+		}
+		return int64(r)
+	}
+	s := strings.TrimSpace(arg)
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		if u, uerr := strconv.ParseUint(s, 0, 64); uerr == nil {
+			return int64(u)
+		}
+		p.failed = true
+		p.stderr("printf: %s: invalid number\n", arg)
+	}
+	return n
+}
+
+func (p *printfState) float(arg string) float64 {
+	if arg == "" {
+		return 0
+	}
+	if arg[0] == '\'' || arg[0] == '"' {
+		return float64(p.integer(arg))
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+	if err != nil {
+		p.failed = true
+		p.stderr("printf: %s: invalid number\n", arg)
+	}
+	return f
+}
+
+// printfEscape expands the backslash escape at s[i] (s[i] is the character after the backslash).
+// In %b arguments octal escapes start with \0 and \c stops the output.
+// It returns the expansion, the index after the escape and whether the output stops.
+func printfEscape(s string, i int, argument bool) (string, int, bool) {
+	c := s[i]
+	switch c {
+	case 'a':
+		return "\a", i + 1, false
+	case 'b':
+		return "\b", i + 1, false
+	case 'e', 'E':
+		return "\x1b", i + 1, false
+	case 'f':
+		return "\f", i + 1, false
+	case 'n':
+		return "\n", i + 1, false
+	case 'r':
+		return "\r", i + 1, false
+	case 't':
+		return "\t", i + 1, false
+	case 'v':
+		return "\v", i + 1, false
+	case '\\':
+		return "\\", i + 1, false
+	case '"', '\'':
+		if argument {
+			return "\\" + string(c), i + 1, false
+		}
+		return string(c), i + 1, false
+	case 'c':
+		if argument {
+			return "", i + 1, true
+		}
+	case 'x', 'u', 'U':
+		digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+		j := i + 1
+		for j < len(s) && j < i+1+digits && isHexDigit(s[j]) {
+			j++
+		}
+		if j == i+1 {
+			break
+		}
+		n, _ := strconv.ParseUint(s[i+1:j], 16, 32)
+		if c == 'x' {
+			return string([]byte{byte(n)}), j, false
+		}
+		return string(rune(n)), j, false
+	}
+	if c >= '0' && c <= '7' {
+		j := i
+		if argument && c == '0' {
+			// \0nnn
+			j++
+		}
+		start := j
+		for j < len(s) && j < start+3 && s[j] >= '0' && s[j] <= '7' {
+			j++
+		}
+		n, _ := strconv.ParseUint("0"+s[start:j], 8, 32)
+		return string([]byte{byte(n)}), j, false
+	}
+	// unknown escapes stay as they are
+	return "\\" + string(c), i + 1, false
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// expandEscapes expands the backslash escapes of a %b argument and reports whether \c stopped the output.
+func expandEscapes(s string) (string, bool) {
+	sb := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		expansion, end, stop := printfEscape(s, i+1, true)
+		sb.WriteString(expansion)
+		if stop {
+			return sb.String(), true
+		}
+		i = end - 1
+	}
+	return sb.String(), false
+}
+
+// printf formats the arguments like the printf builtin of bash.
+// The format is reused as long as there are arguments left and the last pass consumed some.
+// printfQuote quotes s like %q of bash: special characters get a backslash,
+// strings with control characters are quoted as $'...'.
+func printfQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if strings.IndexFunc(s, func(r rune) bool { return r == utf8.RuneError || r < ' ' || r == 0x7f }) != -1 {
+		sb := strings.Builder{}
+		sb.WriteString("$'")
+		for i := 0; i < len(s); i++ {
+			switch c := s[i]; c {
+			case '\\', '\'':
+				sb.WriteByte('\\')
+				sb.WriteByte(c)
+			case '\a':
+				sb.WriteString(`\a`)
+			case '\b':
+				sb.WriteString(`\b`)
+			case 0x1b:
+				sb.WriteString(`\E`)
+			case '\f':
+				sb.WriteString(`\f`)
+			case '\n':
+				sb.WriteString(`\n`)
+			case '\r':
+				sb.WriteString(`\r`)
+			case '\t':
+				sb.WriteString(`\t`)
+			case '\v':
+				sb.WriteString(`\v`)
+			default:
+				r, size := utf8.DecodeRuneInString(s[i:])
+				if c < ' ' || c == 0x7f || r == utf8.RuneError && size <= 1 {
+					sb.WriteString(fmt.Sprintf("\\%03o", c))
+					continue
+				}
+				sb.WriteString(s[i : i+size])
+				i += size - 1
+			}
+		}
+		sb.WriteByte('\'')
+		return sb.String()
+	}
+	sb := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(" \t'\"\\|&;()<>!{}*[]?^$`,", c) != -1,
+			// ~ starts a tilde expansion at the start of a word or after = and :
+			c == '~' && (i == 0 || s[i-1] == '=' || s[i-1] == ':'),
+			// # starts a comment at the start of a word
+			c == '#' && i == 0:
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+func printf(format string, p *printfState) (string, error) {
+	sb := strings.Builder{}
+	for {
+		p.used = 0
+		for i := 0; i < len(format); i++ {
+			c := format[i]
+			if c == '\\' && i+1 < len(format) {
+				expansion, end, _ := printfEscape(format, i+1, false)
+				sb.WriteString(expansion)
+				i = end - 1
+				continue
+			}
+			if c != '%' {
+				sb.WriteByte(c)
+				continue
+			}
+			if i+1 < len(format) && format[i+1] == '%' {
+				sb.WriteByte('%')
+				i++
+				continue
+			}
+
+			// %[flags][width][.precision]conversion
+			j := i + 1
+			spec := strings.Builder{}
+			spec.WriteByte('%')
+			for j < len(format) && strings.IndexByte("-+ #0", format[j]) != -1 {
+				spec.WriteByte(format[j])
+				j++
+			}
+			if j < len(format) && format[j] == '*' {
+				arg, _ := p.next()
+				width := p.integer(arg)
+				if width < 0 {
+					spec.WriteByte('-')
+					width = -width
+				}
+				spec.WriteString(strconv.FormatInt(width, 10))
+				j++
+			} else {
+				for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+					spec.WriteByte(format[j])
+					j++
+				}
+			}
+			hasPrecision := false
+			if j < len(format) && format[j] == '.' {
+				hasPrecision = true
+				spec.WriteByte('.')
+				j++
+				if j < len(format) && format[j] == '*' {
+					arg, _ := p.next()
+					spec.WriteString(strconv.FormatInt(max(p.integer(arg), 0), 10))
+					j++
+				} else {
+					for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+						spec.WriteByte(format[j])
+						j++
+					}
+				}
+			}
+			// length modifiers are accepted and ignored
+			for j < len(format) && strings.IndexByte("hlL", format[j]) != -1 {
+				j++
+			}
+			if j >= len(format) {
+				p.stderr("printf: %s: missing format character\n", format[i:])
+				return sb.String(), errors.Join(fmt.Errorf("printf: %s: missing format character", format[i:]), ExitStatus(1))
+			}
+			verb := format[j]
+			i = j
+			arg, _ := p.next()
+
+			switch verb {
+			case 's':
+				sb.WriteString(fmt.Sprintf(spec.String()+"s", arg))
+			case 'b':
+				expanded, stop := expandEscapes(arg)
+				sb.WriteString(fmt.Sprintf(spec.String()+"s", expanded))
+				if stop {
+					return sb.String(), nil
+				}
+			case 'q':
+				sb.WriteString(fmt.Sprintf(spec.String()+"s", printfQuote(arg)))
+			case 'c':
+				r, _ := utf8.DecodeRuneInString(arg)
+				if arg == "" {
+					sb.WriteString(fmt.Sprintf(spec.String()+"s", ""))
+				} else {
+					sb.WriteString(fmt.Sprintf(spec.String()+"c", r))
+				}
+			case 'd', 'i':
+				sb.WriteString(fmt.Sprintf(spec.String()+"d", p.integer(arg)))
+			case 'u':
+				sb.WriteString(fmt.Sprintf(spec.String()+"d", uint64(p.integer(arg))))
+			case 'o', 'x', 'X':
+				// negative numbers are printed as their unsigned 64 bit representation
+				sb.WriteString(fmt.Sprintf(spec.String()+string(verb), uint64(p.integer(arg))))
+			case 'f', 'F', 'e', 'E', 'g', 'G':
+				f := p.float(arg)
+				if !hasPrecision {
+					// the precision of C defaults to 6, Go prints the shortest representation for %g
+					spec.WriteString(".6")
+				}
+				sb.WriteString(fmt.Sprintf(spec.String()+string(verb), f))
+			default:
+				p.stderr("printf: %%%c: invalid format character\n", verb)
+				return sb.String(), errors.Join(fmt.Errorf("printf: %%%c: invalid format character", verb), ExitStatus(1))
+			}
+		}
+		if len(p.args) == 0 || p.used == 0 {
+			break
+		}
+	}
+	if p.failed {
+		return sb.String(), ExitStatus(1)
+	}
+	return sb.String(), nil
+}
+
+func execute_printf(c *Command, _ *IoProvider) error {
+	args := c.Arguments
+	variable := ""
+	if len(args) > 1 && args[0] == "-v" {
+		variable, args = args[1], args[2:]
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		_, _ = fmt.Fprintln(c.Stderr(), "printf: usage: printf [-v var] format [arguments]")
+		return ExitStatus(2)
+	}
+	p := &printfState{
+		args: args[1:],
+		stderr: func(format string, a ...any) {
+			_, _ = fmt.Fprintf(c.Stderr(), format, a...)
+		},
+	}
+	out, status := printf(args[0], p)
+
+	if variable != "" {
+		a, ok := ParseAssignment(variable + "=")
+		if !ok {
+			_, _ = fmt.Fprintf(c.Stderr(), "printf: `%s': not a valid identifier\n", variable)
+			return fmt.Errorf("printf: `%s': not a valid identifier", variable)
+		}
+		a.Value = out
+		if err := Assign(a); err != nil {
+			_, _ = fmt.Fprintf(c.Stderr(), "printf: %s\n", err)
+			return err
+		}
+		return status
+	}
+	if _, err := c.Stdout().Write([]byte(out)); isBrokenPipe(err) {
+		return brokenPipeStatus
+	} else if err != nil {
+		_, _ = fmt.Fprintln(c.Stderr(), "printf: write error:", err)
+		return errors.Join(errors.New("printf: write error"), err)
+	}
+	return status
+}
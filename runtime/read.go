@@ -0,0 +1,354 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errReadTimeout is returned by the byte source of read -t when the time is up.
+var errReadTimeout = errors.New("read: timeout")
+
+// readOptions are the flags of the read builtin.
+type readOptions struct {
+	raw     bool
+	silent  bool
+	prompt  string
+	array   string
+	delim   byte
+	nchars  int
+	timeout time.Duration
+}
+
+func parseReadOptions(c *Command) (readOptions, []string, error) {
+	o := readOptions{delim: '\n', nchars: -1, timeout: -1}
+	args := c.Arguments
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for i := 1; i < len(arg); i++ {
+			switch flag := arg[i]; flag {
+			case 'r':
+				o.raw = true
+			case 's':
+				o.silent = true
+			case 'a', 'd', 'n', 'p', 't':
+				// the value is the rest of the argument or the next argument
+				value := arg[i+1:]
+				if value == "" {
+					if len(args) == 0 {
+						return o, nil, fmt.Errorf("read: -%c: option requires an argument", flag)
+					}
+					value, args = args[0], args[1:]
+				}
+				i = len(arg)
+				switch flag {
+				case 'a':
+					if !IsName(value) {
+						return o, nil, fmt.Errorf("read: `%s': not a valid identifier", value)
+					}
+					o.array = value
+				case 'd':
+					// an empty delimiter reads up to a NUL byte
+					o.delim = 0
+					if value != "" {
+						o.delim = value[0]
+					}
+				case 'n':
+					n, err := strconv.Atoi(value)
+					if err != nil || n < 0 {
+						return o, nil, fmt.Errorf("read: %s: invalid number", value)
+					}
+					o.nchars = n
+				case 'p':
+					o.prompt = value
+				case 't':
+					seconds, err := strconv.ParseFloat(value, 64)
+					if err != nil || seconds < 0 {
+						return o, nil, fmt.Errorf("read: %s: invalid timeout specification", value)
+					}
+					o.timeout = time.Duration(seconds * float64(time.Second))
+				}
+			default:
+				return o, nil, fmt.Errorf("read: -%c: invalid option", flag)
+			}
+		}
+	}
+	for _, name := range args {
+		if !IsName(name) {
+			return o, nil, fmt.Errorf("read: `%s': not a valid identifier", name)
+		}
+	}
+	return o, args, nil
+}
+
+// isTerminal returns the file behind r if it is a terminal.
+func isTerminal(r io.Reader) (*os.File, bool) {
+	f, ok := r.(*os.File)
+	if !ok {
+		return nil, false
+	}
+	fi, err := f.Stat()
+	return f, err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// readByte reads a single byte, so read never consumes more input than it needs.
+// If read -t gave up on a byte of r, that byte is returned first.
+func readByte(r io.Reader) (byte, error) {
+	pendingMutex.Lock()
+	pending, ok := pendingReads[r]
+	delete(pendingReads, r)
+	pendingMutex.Unlock()
+	if ok {
+		res := <-pending
+		return res.b, res.err
+	}
+
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n == 1 {
+			return buf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// byteResult is the result of readByte.
+type byteResult struct {
+	b   byte
+	err error
+}
+
+var (
+	pendingMutex sync.Mutex
+	// pendingReads holds the reads that were still waiting for input when the time of read -t was up,
+	// the next read of the same input gets their byte, so it isn't lost.
+	pendingReads = make(map[io.Reader]chan byteResult)
+)
+
+// timeoutReader returns a byte source that fails with errReadTimeout after timeout.
+// Files are read with a deadline, so no byte is read after the time is up.
+// Other inputs are read in a goroutine, a byte that arrives too late is kept for the next read.
+// stop must be called when reading is done.
+func timeoutReader(r io.Reader, timeout time.Duration) (next func() (byte, error), stop func()) {
+	deadline := time.Now().Add(timeout)
+	if f, ok := r.(*os.File); ok {
+		restore := func() {}
+		if f.SetReadDeadline(deadline) != nil {
+			f, restore, ok = pollableFile(f)
+		}
+		if ok && f.SetReadDeadline(deadline) == nil {
+			return func() (byte, error) {
+					b, err := readByte(f)
+					if errors.Is(err, os.ErrDeadlineExceeded) {
+						return 0, errReadTimeout
+					}
+					return b, err
+				}, func() {
+					_ = f.SetReadDeadline(time.Time{})
+					restore()
+				}
+		}
+		if ok {
+			restore()
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	timedOut := false
+	next = func() (byte, error) {
+		if timedOut {
+			return 0, errReadTimeout
+		}
+		results := make(chan byteResult, 1)
+		go func() {
+			b, err := readByte(r)
+			results <- byteResult{b, err}
+		}()
+		select {
+		case res := <-results:
+			return res.b, res.err
+		case <-timer.C:
+			timedOut = true
+			pendingMutex.Lock()
+			pendingReads[r] = results
+			pendingMutex.Unlock()
+			return 0, errReadTimeout
+		}
+	}
+	return next, func() {
+		timer.Stop()
+	}
+}
+
+// runeLength returns the length of the UTF-8 sequence that starts with b.
+func runeLength(b byte) int {
+	switch {
+	case b < 0xC0:
+		return 1
+	case b < 0xE0:
+		return 2
+	case b < 0xF0:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// splitFields splits line at the characters of ifs like read does, escaped (literal) characters never split.
+// Whitespace in ifs is trimmed around the fields. The last of n fields gets the rest of the line,
+// for n < 0 all fields are split.
+func splitFields(line []byte, literal []bool, ifs string, n int) []string {
+	isIFS := func(i int) bool {
+		return !literal[i] && strings.IndexByte(ifs, line[i]) != -1
+	}
+	isWhite := func(i int) bool {
+		return isIFS(i) && (line[i] == ' ' || line[i] == '\t' || line[i] == '\n')
+	}
+	i, end := 0, len(line)
+	for i < end && isWhite(i) {
+		i++
+	}
+	for end > i && isWhite(end-1) {
+		end--
+	}
+	fields := make([]string, 0)
+	for i < end {
+		if n >= 0 && len(fields) == n-1 {
+			fields = append(fields, string(line[i:end]))
+			break
+		}
+		start := i
+		for i < end && !isIFS(i) {
+			i++
+		}
+		fields = append(fields, string(line[start:i]))
+		// a delimiter is whitespace around at most one other character of ifs
+		for i < end && isWhite(i) {
+			i++
+		}
+		if i < end && isIFS(i) {
+			i++
+			for i < end && isWhite(i) {
+				i++
+			}
+		}
+	}
+	return fields
+}
+
+func execute_read(c *Command, _ *IoProvider) error {
+	o, names, err := parseReadOptions(c)
+	if err != nil {
+		_, _ = fmt.Fprintln(c.Stderr(), err)
+		return errors.Join(err, ExitStatus(2))
+	}
+
+	stdin := c.Stdin()
+	if f, ok := isTerminal(stdin); ok {
+		// like in bash, the prompt is only shown if the input comes from a terminal
+		if o.prompt != "" {
+			_, _ = io.WriteString(c.Stderr(), o.prompt)
+		}
+		if o.silent {
+			if restore, err := disableEcho(f); err == nil {
+				defer restore()
+			}
+		}
+	}
+	next := func() (byte, error) {
+		return readByte(stdin)
+	}
+	if o.timeout >= 0 {
+		var stop func()
+		next, stop = timeoutReader(stdin, o.timeout)
+		defer stop()
+	}
+
+	// literal marks the characters that were escaped with a backslash, they don't split fields
+	var (
+		line    []byte
+		literal []bool
+		status  error
+	)
+	for count := 0; o.nchars < 0 || count < o.nchars; count++ {
+		b, err := next()
+		escaped := false
+		if err == nil && !o.raw && b == '\\' {
+			escaped = true
+			b, err = next()
+			if err == nil && b == '\n' {
+				// line continuation
+				count--
+				continue
+			}
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, errReadTimeout):
+				// like in bash, the status is greater than 128
+				status = ExitStatus(142)
+			case errors.Is(err, io.EOF):
+				status = ExitStatus(1)
+			default:
+				_, _ = fmt.Fprintf(c.Stderr(), "read: %s\n", err)
+				status = errors.Join(errors.New("read: failed to read input"), err)
+			}
+			break
+		}
+		if !escaped && b == o.delim {
+			break
+		}
+		line = append(line, b)
+		literal = append(literal, escaped)
+		// -n counts characters, not bytes
+		for range runeLength(b) - 1 {
+			b, err = next()
+			if err != nil {
+				break
+			}
+			line = append(line, b)
+			literal = append(literal, escaped)
+		}
+	}
+
+	// partial input is assigned too, even if read fails
+	if o.array == "" && len(names) == 0 {
+		// REPLY gets the line without field splitting
+		if err := Assign(Assignment{Name: "REPLY", Value: string(line)}); err != nil {
+			return err
+		}
+		return status
+	}
+	ifs, ok := Variable("IFS")
+	if !ok {
+		ifs = " \t\n"
+	}
+	if o.array != "" {
+		if err := SetArray(o.array, splitFields(line, literal, ifs, -1)); err != nil {
+			return err
+		}
+	}
+	fields := splitFields(line, literal, ifs, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(fields) {
+			value = fields[i]
+		}
+		if err := Assign(Assignment{Name: name, Value: value}); err != nil {
+			return err
+		}
+	}
+	return status
+}
@@ -0,0 +1,71 @@
+package runtime
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadTimeoutKeepsLateInput(t *testing.T) {
+	defer func() {
+		_ = UnsetVariable("READ_X")
+		_ = UnsetVariable("READ_Y")
+	}()
+	cases := []struct {
+		name string
+		pipe func() (io.Reader, io.WriteCloser, error)
+	}{
+		{
+			name: "file",
+			pipe: func() (io.Reader, io.WriteCloser, error) {
+				return os.Pipe()
+			},
+		},
+		{
+			name: "reader",
+			pipe: func() (io.Reader, io.WriteCloser, error) {
+				r, w := io.Pipe()
+				return r, w, nil
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, w, err := c.pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			iop, _, _ := TestIoProvider("")
+			defer iop.Close()
+			read := func(args ...string) error {
+				cmd := NewCommand(iop)
+				cmd.Executable = "read"
+				cmd.Arguments = args
+				cmd.Fds.Open(0, &FileDescriptor{Reader: r})
+				return execute_read(cmd, iop)
+			}
+
+			start := time.Now()
+			if err := read("-t", "0.1", "READ_X"); exitCode(err) != 142 {
+				t.Fatalf("read -t: %v, expected status 142", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("read -t took %s", elapsed)
+			}
+			go func() {
+				_, _ = io.WriteString(w, "hello\n")
+			}()
+			if err := read("READ_Y"); err != nil {
+				t.Fatal(err)
+			}
+			if x, _ := Variable("READ_X"); x != "" {
+				t.Errorf("READ_X: %q, expected it to be empty", x)
+			}
+			if y, _ := Variable("READ_Y"); y != "hello" {
+				t.Errorf("READ_Y: %q, expected: %q", y, "hello")
+			}
+		})
+	}
+}
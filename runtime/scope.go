@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// scope is a function call or a sourced file.
+// Variables made local with the local builtin get their previous state back when the scope ends (dynamic scoping).
+type scope struct {
+	saved map[string]savedVariable
+}
+
+// savedVariable is the state of a variable before it was made local.
+type savedVariable struct {
+	v          *variable
+	env        string
+	exported   bool
+	attributes variableAttributes
+}
+
+// scopes is the stack of active scopes, it is guarded by variablesMutex.
+var scopes = make([]*scope, 0)
+
+// PushScope begins a new scope for local variables, PopScope must be called when it ends.
+func PushScope() {
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	scopes = append(scopes, &scope{saved: map[string]savedVariable{}})
+}
+
+// PopScope ends the innermost scope and restores the variables that were made local in it.
+func PopScope() {
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	if len(scopes) == 0 {
+		return
+	}
+	s := scopes[len(scopes)-1]
+	scopes = scopes[:len(scopes)-1]
+	for name, old := range s.saved {
+		delete(variables, name)
+		delete(attributes, name)
+		_ = os.Unsetenv(name)
+		if old.v != nil {
+			variables[name] = old.v
+		}
+		if old.exported {
+			_ = os.Setenv(name, old.env)
+		}
+		if old.attributes != 0 {
+			attributes[name] = old.attributes
+		}
+	}
+}
+
+func inScope() bool {
+	variablesMutex.RLock()
+	defer variablesMutex.RUnlock()
+	return len(scopes) != 0
+}
+
+// makeLocal makes the variable name local to the innermost scope, it starts unset.
+func makeLocal(name string) error {
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	if len(scopes) == 0 {
+		return errors.New("can only be used in a function or sourced script")
+	}
+	if attributes[name]&attributeReadonly != 0 {
+		return fmt.Errorf("%s: %w", name, ErrReadonly)
+	}
+	s := scopes[len(scopes)-1]
+	if _, ok := s.saved[name]; ok {
+		// local in the same scope again
+		return nil
+	}
+	env, exported := os.LookupEnv(name)
+	s.saved[name] = savedVariable{v: variables[name], env: env, exported: exported, attributes: attributes[name]}
+	delete(variables, name)
+	delete(attributes, name)
+	return os.Unsetenv(name)
+}
+
+// returnStatus is the result of the return builtin.
+// It ends the execution of the function or sourced file, which then has the exit status.
+type returnStatus int
+
+func (r returnStatus) Error() string {
+	return fmt.Sprintf("return %d", int(r))
+}
+
+// IsReturn reports whether err was caused by the return builtin, the rest of the function or sourced file is skipped then.
+func IsReturn(err error) bool {
+	var r returnStatus
+	return errors.As(err, &r)
+}
+
+func execute_return(c *Command, _ *IoProvider) error {
+	if !inScope() {
+		_, _ = fmt.Fprintln(c.Stderr(), "return: can only `return' from a function or sourced script")
+		return ExitStatus(1)
+	}
+	// without an argument the status is the one of the last command
+	code := Status()
+	if len(c.Arguments) > 0 {
+		n, err := strconv.Atoi(c.Arguments[0])
+		if err != nil {
+			_, _ = fmt.Fprintf(c.Stderr(), "return: %s: numeric argument required\n", c.Arguments[0])
+			return returnStatus(2)
+		}
+		code = n & 0xff
+	}
+	return returnStatus(code)
+}
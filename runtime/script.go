@@ -0,0 +1,137 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ShellPath is the ohmygosh executable that runs shell scripts.
+// The ohmygosh command sets it to itself, otherwise ohmygosh is looked up in PATH.
+var ShellPath string
+
+func shellPath() (string, bool) {
+	if ShellPath != "" {
+		return ShellPath, true
+	}
+	if p, err := exec.LookPath("ohmygosh"); err == nil {
+		return p, true
+	}
+	return "", false
+}
+
+// maxShebangLength is the length of the interpreter line that isShellScript reads, like the buffer of the Linux kernel.
+const maxShebangLength = 256
+
+// isShellScript reports whether the file at p has a shebang line for ohmygosh or sh,
+// like #!/bin/sh or #!/usr/bin/env ohmygosh.
+func isShellScript(p string) bool {
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != "#!" {
+		return false
+	}
+	// binaries don't have a line end, so the interpreter line has a hard limit
+	line := make([]byte, maxShebangLength)
+	n, err := io.ReadFull(f, line)
+	if err != nil && n == 0 {
+		return false
+	}
+	interpreter, _, _ := strings.Cut(string(line[:n]), "\n")
+	fields := strings.Fields(interpreter)
+	if len(fields) == 0 {
+		return false
+	}
+	name := path.Base(filepath.ToSlash(fields[0]))
+	if name == "env" && len(fields) > 1 {
+		name = fields[1]
+	}
+	name = strings.TrimSuffix(name, ".exe")
+	return name == "ohmygosh" || name == "sh"
+}
+
+// shellScript returns the program and arguments to run the command with ohmygosh if it is a shell script.
+func (c *Command) shellScript() (string, []string, bool) {
+	name := c.Executable
+	if !strings.ContainsAny(name, `/\`) {
+		p, err := exec.LookPath(name)
+		if err != nil {
+			return "", nil, false
+		}
+		name = p
+	}
+	if !isShellScript(name) {
+		return "", nil, false
+	}
+	shell, ok := shellPath()
+	if !ok {
+		return "", nil, false
+	}
+	return shell, append([]string{shell, name}, c.Arguments...), true
+}
+
+// findSourceFile looks up the file for source and . like POSIX describes it:
+// a name without a slash is searched in PATH, the file doesn't need to be executable.
+// Like bash, it falls back to the current directory if PATH doesn't contain the file.
+func findSourceFile(name string) (string, error) {
+	if strings.ContainsAny(name, `/\`) {
+		if _, err := os.Stat(name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	pathList, _ := Variable("PATH")
+	for _, dir := range filepath.SplitList(pathList) {
+		if dir == "" {
+			dir = "."
+		}
+		p := filepath.Join(dir, name)
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+			return p, nil
+		}
+	}
+	if fi, err := os.Stat(name); err == nil && fi.Mode().IsRegular() {
+		return name, nil
+	}
+	return "", fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+}
+
+func execute_source(c *Command, iop *IoProvider) error {
+	if len(c.Arguments) == 0 {
+		_, _ = fmt.Fprintf(c.Stderr(), "%s: filename argument required\n", c.Executable)
+		return ExitStatus(2)
+	}
+	p, err := findSourceFile(c.Arguments[0])
+	if err != nil {
+		_, _ = fmt.Fprintf(c.Stderr(), "%s: %s\n", c.Executable, err)
+		return errors.Join(fmt.Errorf("%s: failed to find file %q", c.Executable, c.Arguments[0]), err)
+	}
+	content, err := os.ReadFile(p)
+	if err != nil {
+		_, _ = fmt.Fprintf(c.Stderr(), "%s: %s\n", c.Executable, err)
+		return errors.Join(fmt.Errorf("%s: failed to read file %q", c.Executable, p), err)
+	}
+	if len(c.Arguments) > 1 {
+		// the arguments are the positional parameters while the file runs
+		old := SetPositionalParameters(c.Arguments[1:])
+		defer SetPositionalParameters(old)
+	}
+	// the file is a scope for local variables and return ends it
+	PushScope()
+	defer PopScope()
+	err = Interpret(string(content), c.ioProvider(iop))
+	if IsReturn(err) {
+		return statusError(exitCode(err))
+	}
+	return syntaxErrorStatus(c, c.Executable+": "+p, err)
+}
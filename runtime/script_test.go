@@ -0,0 +1,43 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsShellScript(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		expect  bool
+	}{
+		{name: "sh", content: "#!/bin/sh\necho hi\n", expect: true},
+		{name: "env ohmygosh", content: "#!/usr/bin/env ohmygosh\n", expect: true},
+		{name: "space after #!", content: "#! /bin/sh -e\n", expect: true},
+		{name: "without line end", content: "#!/bin/sh", expect: true},
+		{name: "other interpreter", content: "#!/usr/bin/env python3\nprint()\n", expect: false},
+		{name: "no shebang", content: "echo hi\n", expect: false},
+		{name: "empty", content: "", expect: false},
+		{name: "only #!", content: "#!", expect: false},
+		{name: "one byte", content: "#", expect: false},
+		{name: "binary", content: "\x7fELF" + strings.Repeat("\x00", 4096), expect: false},
+		{name: "long line without line end", content: "#!" + strings.Repeat("x", 1<<20), expect: false},
+	}
+	dir := t.TempDir()
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := filepath.Join(dir, string(rune('a'+i)))
+			if err := os.WriteFile(p, []byte(c.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if got := isShellScript(p); got != c.expect {
+				t.Errorf("isShellScript: %v, expected: %v", got, c.expect)
+			}
+		})
+	}
+	if isShellScript(filepath.Join(dir, "missing")) {
+		t.Error("isShellScript is true for a missing file")
+	}
+}
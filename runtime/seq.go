@@ -0,0 +1,235 @@
+package runtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// seqOptions are the flags of the seq builtin.
+type seqOptions struct {
+	separator  string
+	equalWidth bool
+	format     string
+}
+
+// isSeqNumber reports whether arg is a number, so negative numbers are not mistaken for options.
+func isSeqNumber(arg string) bool {
+	_, err := strconv.ParseFloat(arg, 64)
+	return err == nil
+}
+
+// seqPrecision returns the number of fractional digits of a number argument like 1.25 or 2.5e-1.
+func seqPrecision(arg string) int {
+	mantissa, exponent, _ := strings.Cut(strings.ToLower(arg), "e")
+	precision := 0
+	if _, fraction, ok := strings.Cut(mantissa, "."); ok {
+		precision = len(fraction)
+	}
+	if exponent != "" {
+		e, _ := strconv.Atoi(exponent)
+		precision = max(precision-e, 0)
+	}
+	return precision
+}
+
+func parseSeqOptions(c *Command) (seqOptions, []string, error) {
+	o := seqOptions{separator: "\n"}
+	args := c.Arguments
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && !isSeqNumber(args[0]) {
+		arg := args[0]
+		args = args[1:]
+		// the value of -s and -f is the rest of the argument or the next argument
+		value := func(flag string) (string, error) {
+			if v := strings.TrimPrefix(arg, flag); v != "" {
+				return strings.TrimPrefix(v, "="), nil
+			}
+			if len(args) == 0 {
+				return "", fmt.Errorf("seq: option requires an argument -- '%s'", strings.TrimLeft(flag, "-"))
+			}
+			v := args[0]
+			args = args[1:]
+			return v, nil
+		}
+		var err error
+		switch {
+		case arg == "--":
+			return o, args, nil
+		case arg == "-w" || arg == "--equal-width":
+			o.equalWidth = true
+		case strings.HasPrefix(arg, "--separator"):
+			o.separator, err = value("--separator")
+		case strings.HasPrefix(arg, "-s"):
+			o.separator, err = value("-s")
+		case strings.HasPrefix(arg, "--format"):
+			o.format, err = value("--format")
+		case strings.HasPrefix(arg, "-f"):
+			o.format, err = value("-f")
+		default:
+			err = fmt.Errorf("seq: invalid option -- '%s'", strings.TrimLeft(arg, "-"))
+		}
+		if err != nil {
+			return o, nil, err
+		}
+	}
+	if o.format != "" && o.equalWidth {
+		return o, nil, errors.New("seq: format string may not be specified when printing equal width strings")
+	}
+	return o, args, nil
+}
+
+// checkSeqFormat makes sure that the format of seq -f has exactly one floating point directive.
+func checkSeqFormat(format string) error {
+	directives := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(format) && strings.IndexByte("-+ #0123456789.'", format[j]) != -1 {
+			j++
+		}
+		if j == len(format) || strings.IndexByte("eEfFgGaA", format[j]) == -1 {
+			return fmt.Errorf("seq: format %q has unknown %%%s directive", format, format[i+1:min(j+1, len(format))])
+		}
+		directives++
+		i = j
+	}
+	if directives != 1 {
+		return fmt.Errorf("seq: format %q has no %% directive", format)
+	}
+	return nil
+}
+
+func execute_seq(c *Command, _ *IoProvider) error {
+	fail := func(err error) error {
+		_, _ = fmt.Fprintln(c.Stderr(), err)
+		return err
+	}
+	o, args, err := parseSeqOptions(c)
+	if err != nil {
+		return fail(err)
+	}
+	switch {
+	case len(args) == 0:
+		return fail(errors.New("seq: missing operand"))
+	case len(args) > 3:
+		return fail(fmt.Errorf("seq: extra operand '%s'", args[3]))
+	}
+	if o.format != "" {
+		if err := checkSeqFormat(o.format); err != nil {
+			return fail(err)
+		}
+	}
+
+	// seq last, seq first last and seq first incr last
+	operands := []string{"1", "1", args[len(args)-1]}
+	switch len(args) {
+	case 2:
+		operands[0] = args[0]
+	case 3:
+		operands[0], operands[1] = args[0], args[1]
+	}
+	numbers := make([]float64, 3)
+	integers := true
+	for i, operand := range operands {
+		if numbers[i], err = strconv.ParseFloat(operand, 64); err != nil {
+			return fail(fmt.Errorf("seq: invalid floating point argument: '%s'", operand))
+		}
+		integers = integers && !strings.ContainsAny(operand, ".eExXpPiInN")
+	}
+	first, increment, last := numbers[0], numbers[1], numbers[2]
+	if increment == 0 {
+		return fail(fmt.Errorf("seq: invalid Zero increment value: '%s'", operands[1]))
+	}
+
+	// like GNU seq, the output has as many fractional digits as the first number or the increment
+	precision := max(seqPrecision(operands[0]), seqPrecision(operands[1]))
+	format := func(x float64) string {
+		if o.format != "" {
+			s, _ := printf(o.format, &printfState{args: []string{strconv.FormatFloat(x, 'g', -1, 64)}, stderr: func(string, ...any) {}})
+			return s
+		}
+		return strconv.FormatFloat(x, 'f', precision, 64)
+	}
+	width := 0
+	if o.equalWidth {
+		width = max(len(format(first)), len(format(last)))
+	}
+	pad := func(s string) string {
+		if len(s) >= width {
+			return s
+		}
+		if sign, ok := strings.CutPrefix(s, "-"); ok {
+			return "-" + strings.Repeat("0", width-len(s)) + sign
+		}
+		return strings.Repeat("0", width-len(s)) + s
+	}
+
+	w := bufio.NewWriter(c.Stdout())
+	count := 0
+	write := func(s string) error {
+		if count != 0 {
+			if _, err := w.WriteString(o.separator); err != nil {
+				return err
+			}
+		}
+		count++
+		_, err := w.WriteString(pad(s))
+		return err
+	}
+
+	if integers && o.format == "" {
+		// integers are counted exactly
+		a, _ := strconv.ParseInt(operands[0], 10, 64)
+		step, _ := strconv.ParseInt(operands[1], 10, 64)
+		b, _ := strconv.ParseInt(operands[2], 10, 64)
+		for x := a; (step > 0 && x <= b) || (step < 0 && x >= b); x += step {
+			if err = write(strconv.FormatInt(x, 10)); err != nil {
+				break
+			}
+			if (step > 0 && x > math.MaxInt64-step) || (step < 0 && x < math.MinInt64-step) {
+				break
+			}
+		}
+	} else {
+		// every number is computed from first, so rounding errors don't add up
+		previous := ""
+		for i := 0; ; i++ {
+			x := first + float64(i)*increment
+			if (increment > 0 && x > last) || (increment < 0 && x < last) {
+				// the number is printed anyway if it only exceeds last because of rounding
+				s := format(x)
+				if v, parseErr := strconv.ParseFloat(strings.TrimSpace(s), 64); parseErr == nil && v == last && s != previous {
+					err = write(s)
+				}
+				break
+			}
+			previous = format(x)
+			if err = write(previous); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil && count != 0 {
+		_, err = w.WriteString("\n")
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if isBrokenPipe(err) {
+		return brokenPipeStatus
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(c.Stderr(), "seq: write error:", err)
+		return errors.Join(errors.New("seq: write error"), err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+
+package runtime
+
+import "syscall"
+
+// signals lists the signals that can be trapped, in the order trap -l prints them.
+var signals = []struct {
+	name   string
+	signal syscall.Signal
+}{
+	{"HUP", syscall.SIGHUP},
+	{"INT", syscall.SIGINT},
+	{"QUIT", syscall.SIGQUIT},
+	{"ILL", syscall.SIGILL},
+	{"TRAP", syscall.SIGTRAP},
+	{"ABRT", syscall.SIGABRT},
+	{"BUS", syscall.SIGBUS},
+	{"FPE", syscall.SIGFPE},
+	{"USR1", syscall.SIGUSR1},
+	{"SEGV", syscall.SIGSEGV},
+	{"USR2", syscall.SIGUSR2},
+	{"PIPE", syscall.SIGPIPE},
+	{"ALRM", syscall.SIGALRM},
+	{"TERM", syscall.SIGTERM},
+	{"CHLD", syscall.SIGCHLD},
+	{"CONT", syscall.SIGCONT},
+	{"TSTP", syscall.SIGTSTP},
+	{"TTIN", syscall.SIGTTIN},
+	{"TTOU", syscall.SIGTTOU},
+	{"WINCH", syscall.SIGWINCH},
+}
+
+// killSignals are the signals that can be sent with kill but can't be trapped.
+var killSignals = []struct {
+	name   string
+	signal syscall.Signal
+}{
+	{"KILL", syscall.SIGKILL},
+	{"STOP", syscall.SIGSTOP},
+}
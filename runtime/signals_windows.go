@@ -0,0 +1,32 @@
+//go:build windows
+
+package runtime
+
+import "syscall"
+
+// signals lists the signals that can be trapped, in the order trap -l prints them.
+var signals = []struct {
+	name   string
+	signal syscall.Signal
+}{
+	{"HUP", syscall.SIGHUP},
+	{"INT", syscall.SIGINT},
+	{"QUIT", syscall.SIGQUIT},
+	{"ILL", syscall.SIGILL},
+	{"TRAP", syscall.SIGTRAP},
+	{"ABRT", syscall.SIGABRT},
+	{"BUS", syscall.SIGBUS},
+	{"FPE", syscall.SIGFPE},
+	{"SEGV", syscall.SIGSEGV},
+	{"PIPE", syscall.SIGPIPE},
+	{"ALRM", syscall.SIGALRM},
+	{"TERM", syscall.SIGTERM},
+}
+
+// killSignals are the signals that can be sent with kill but can't be trapped.
+var killSignals = []struct {
+	name   string
+	signal syscall.Signal
+}{
+	{"KILL", syscall.SIGKILL},
+}
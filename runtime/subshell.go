@@ -0,0 +1,130 @@
+package runtime
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// shellState is the part of the shell that the commands of a subshell can change.
+type shellState struct {
+	variables  map[string]*variable
+	attributes map[string]variableAttributes
+	scopes     []*scope
+	env        map[string]string
+	options    map[string]bool
+	traps      map[string]trap
+	parameters []string
+	workDir    string
+	logicalDir string
+	dirStack   []string
+}
+
+// clone returns a copy of v that shares no arrays with it.
+func (v *variable) clone() *variable {
+	if v == nil {
+		return nil
+	}
+	c := *v
+	c.indexed = maps.Clone(v.indexed)
+	c.assoc = maps.Clone(v.assoc)
+	return &c
+}
+
+// environment returns the environment of the process as a map.
+func environment() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok && name != "" {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+// EnterSubshell saves the variables, options, traps, positional parameters and working directory of the shell.
+// A subshell like $(...) runs in the same process, restore undoes its changes, so they don't reach the caller.
+func EnterSubshell() (restore func()) {
+	var s shellState
+
+	variablesMutex.RLock()
+	s.variables = make(map[string]*variable, len(variables))
+	for name, v := range variables {
+		s.variables[name] = v.clone()
+	}
+	s.attributes = maps.Clone(attributes)
+	s.scopes = make([]*scope, len(scopes))
+	for i, sc := range scopes {
+		saved := make(map[string]savedVariable, len(sc.saved))
+		for name, old := range sc.saved {
+			old.v = old.v.clone()
+			saved[name] = old
+		}
+		s.scopes[i] = &scope{saved: saved}
+	}
+	s.env = environment()
+	variablesMutex.RUnlock()
+
+	optionsMutex.RLock()
+	s.options = maps.Clone(options)
+	optionsMutex.RUnlock()
+
+	trapsMutex.Lock()
+	s.traps = maps.Clone(traps)
+	trapsMutex.Unlock()
+
+	parametersMutex.RLock()
+	s.parameters = slices.Clone(positionalParameters)
+	parametersMutex.RUnlock()
+
+	dirsMutex.Lock()
+	s.workDir, _ = os.Getwd()
+	s.logicalDir = logicalDir
+	s.dirStack = slices.Clone(dirStack)
+	dirsMutex.Unlock()
+
+	return s.restore
+}
+
+// restore puts the saved state back.
+func (s *shellState) restore() {
+	variablesMutex.Lock()
+	variables = s.variables
+	attributes = s.attributes
+	scopes = s.scopes
+	for name, value := range environment() {
+		if old, ok := s.env[name]; !ok {
+			_ = os.Unsetenv(name)
+		} else if old != value {
+			_ = os.Setenv(name, old)
+		}
+	}
+	for name, value := range s.env {
+		if _, ok := os.LookupEnv(name); !ok {
+			_ = os.Setenv(name, value)
+		}
+	}
+	variablesMutex.Unlock()
+
+	optionsMutex.Lock()
+	options = s.options
+	optionsMutex.Unlock()
+
+	trapsMutex.Lock()
+	traps = s.traps
+	updateSignals()
+	trapsMutex.Unlock()
+
+	parametersMutex.Lock()
+	positionalParameters = s.parameters
+	parametersMutex.Unlock()
+
+	dirsMutex.Lock()
+	if wd, err := os.Getwd(); s.workDir != "" && (err != nil || wd != s.workDir) {
+		_ = os.Chdir(s.workDir)
+	}
+	logicalDir = s.logicalDir
+	dirStack = s.dirStack
+	dirsMutex.Unlock()
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+
+package runtime
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// disableEcho turns off the echo of the terminal f, restore turns it back on.
+func disableEcho(f *os.File) (restore func(), err error) {
+	cmd := exec.Command("stty", "-echo")
+	cmd.Stdin = f
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return func() {
+		cmd := exec.Command("stty", "echo")
+		cmd.Stdin = f
+		_ = cmd.Run()
+	}, nil
+}
+
+// pollableFile returns a copy of f that supports read deadlines, for inputs like terminals that block.
+// The input is non-blocking until restore is called, which also closes the copy.
+func pollableFile(f *os.File) (pollable *os.File, restore func(), ok bool) {
+	fd := int(f.Fd())
+	dup, err := syscall.Dup(fd)
+	if err != nil {
+		return nil, nil, false
+	}
+	// the flag belongs to the open file, so it is set for f too
+	if err := syscall.SetNonblock(dup, true); err != nil {
+		_ = syscall.Close(dup)
+		return nil, nil, false
+	}
+	// a non-blocking file gets added to the poller of the Go runtime
+	pollable = os.NewFile(uintptr(dup), f.Name())
+	return pollable, func() {
+		_ = pollable.Close()
+		_ = syscall.SetNonblock(fd, false)
+	}, true
+}
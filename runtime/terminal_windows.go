@@ -0,0 +1,32 @@
+//go:build windows
+
+package runtime
+
+import (
+	"os"
+	"syscall"
+)
+
+const enableEchoInput = 0x0004
+
+var setConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// disableEcho turns off the echo of the console f, restore turns it back on.
+func disableEcho(f *os.File) (restore func(), err error) {
+	handle := syscall.Handle(f.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(handle, &mode); err != nil {
+		return nil, err
+	}
+	if r, _, err := setConsoleMode.Call(uintptr(handle), uintptr(mode&^enableEchoInput)); r == 0 {
+		return nil, err
+	}
+	return func() {
+		_, _, _ = setConsoleMode.Call(uintptr(handle), uintptr(mode))
+	}, nil
+}
+
+// pollableFile returns a copy of f that supports read deadlines, consoles and pipes on Windows don't.
+func pollableFile(_ *os.File) (pollable *os.File, restore func(), ok bool) {
+	return nil, nil, false
+}
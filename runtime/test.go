@@ -0,0 +1,285 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func isUnaryTestOperator(op string) bool {
+	switch op {
+	case "-e", "-f", "-d", "-r", "-w", "-x", "-s", "-L", "-h", "-p", "-S", "-b", "-c", "-t", "-z", "-n":
+		return true
+	}
+	return false
+}
+
+func isBinaryTestOperator(op string) bool {
+	switch op {
+	case "=", "==", "!=", "<", ">", "-eq", "-ne", "-lt", "-le", "-gt", "-ge", "-nt", "-ot", "-ef":
+		return true
+	}
+	return false
+}
+
+// unaryTest evaluates a file or string test like -f file or -z string.
+func unaryTest(op, operand string) (bool, error) {
+	switch op {
+	case "-z":
+		return operand == "", nil
+	case "-n":
+		return operand != "", nil
+	case "-t":
+		n, err := strconv.Atoi(strings.TrimSpace(operand))
+		if err != nil {
+			return false, fmt.Errorf("%s: integer expression expected", operand)
+		}
+		// only the standard streams of the shell are checked
+		files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+		if n < 0 || n >= len(files) {
+			return false, nil
+		}
+		_, ok := isTerminal(files[n])
+		return ok, nil
+	case "-L", "-h":
+		fi, err := os.Lstat(operand)
+		return err == nil && fi.Mode()&os.ModeSymlink != 0, nil
+	case "-r":
+		return accessible(operand, 4), nil
+	case "-w":
+		return accessible(operand, 2), nil
+	case "-x":
+		return accessible(operand, 1), nil
+	}
+	fi, err := os.Stat(operand)
+	if err != nil {
+		return false, nil
+	}
+	switch op {
+	case "-f":
+		return fi.Mode().IsRegular(), nil
+	case "-d":
+		return fi.IsDir(), nil
+	case "-s":
+		return fi.Size() > 0, nil
+	case "-p":
+		return fi.Mode()&os.ModeNamedPipe != 0, nil
+	case "-S":
+		return fi.Mode()&os.ModeSocket != 0, nil
+	case "-b":
+		return fi.Mode()&os.ModeDevice != 0 && fi.Mode()&os.ModeCharDevice == 0, nil
+	case "-c":
+		return fi.Mode()&os.ModeCharDevice != 0, nil
+	default:
+		// -e
+		return true, nil
+	}
+}
+
+// binaryTest evaluates a comparison like a = b, 1 -lt 2 or file1 -nt file2.
+func binaryTest(left, op, right string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	case "<":
+		return left < right, nil
+	case ">":
+		return left > right, nil
+	case "-nt", "-ot":
+		l, lerr := os.Stat(left)
+		r, rerr := os.Stat(right)
+		if op == "-ot" {
+			l, lerr, r, rerr = r, rerr, l, lerr
+		}
+		// an existing file is newer than a missing one
+		return lerr == nil && (rerr != nil || l.ModTime().After(r.ModTime())), nil
+	case "-ef":
+		l, lerr := os.Stat(left)
+		r, rerr := os.Stat(right)
+		return lerr == nil && rerr == nil && os.SameFile(l, r), nil
+	}
+	a, err := strconv.ParseInt(strings.TrimSpace(left), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("%s: integer expression expected", left)
+	}
+	b, err := strconv.ParseInt(strings.TrimSpace(right), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("%s: integer expression expected", right)
+	}
+	switch op {
+	case "-eq":
+		return a == b, nil
+	case "-ne":
+		return a != b, nil
+	case "-lt":
+		return a < b, nil
+	case "-le":
+		return a <= b, nil
+	case "-gt":
+		return a > b, nil
+	default:
+		// -ge
+		return a >= b, nil
+	}
+}
+
+// evalTest evaluates the arguments of test.
+// Up to four arguments are evaluated by the rules of POSIX, so operands that look like operators work,
+// longer expressions are parsed with ! binding tighter than -a and -a tighter than -o.
+func evalTest(args []string) (bool, error) {
+	switch len(args) {
+	case 0:
+		return false, nil
+	case 1:
+		return args[0] != "", nil
+	case 2:
+		if args[0] == "!" {
+			ok, err := evalTest(args[1:])
+			return !ok, err
+		}
+		if isUnaryTestOperator(args[0]) {
+			return unaryTest(args[0], args[1])
+		}
+		return false, fmt.Errorf("%s: unary operator expected", args[0])
+	case 3:
+		if isBinaryTestOperator(args[1]) {
+			return binaryTest(args[0], args[1], args[2])
+		}
+		if args[1] == "-a" {
+			return args[0] != "" && args[2] != "", nil
+		}
+		if args[1] == "-o" {
+			return args[0] != "" || args[2] != "", nil
+		}
+		if args[0] == "!" {
+			ok, err := evalTest(args[1:])
+			return !ok, err
+		}
+		if args[0] == "(" && args[2] == ")" {
+			return evalTest(args[1:2])
+		}
+		return false, fmt.Errorf("%s: binary operator expected", args[1])
+	case 4:
+		if args[0] == "!" {
+			ok, err := evalTest(args[1:])
+			return !ok, err
+		}
+		if args[0] == "(" && args[3] == ")" {
+			return evalTest(args[1:3])
+		}
+	}
+	p := testParser{args: args}
+	ok, err := p.or()
+	if err == nil && p.i < len(p.args) {
+		err = fmt.Errorf("%s: unexpected argument", p.args[p.i])
+	}
+	return ok, err
+}
+
+// testParser parses expressions of test with more than four arguments.
+type testParser struct {
+	args []string
+	i    int
+}
+
+func (p *testParser) peek(offset int) (string, bool) {
+	if p.i+offset < len(p.args) {
+		return p.args[p.i+offset], true
+	}
+	return "", false
+}
+
+func (p *testParser) or() (bool, error) {
+	ok, err := p.and()
+	for err == nil {
+		if op, _ := p.peek(0); op != "-o" {
+			break
+		}
+		p.i++
+		var right bool
+		right, err = p.and()
+		ok = ok || right
+	}
+	return ok, err
+}
+
+func (p *testParser) and() (bool, error) {
+	ok, err := p.not()
+	for err == nil {
+		if op, _ := p.peek(0); op != "-a" {
+			break
+		}
+		p.i++
+		var right bool
+		right, err = p.not()
+		ok = ok && right
+	}
+	return ok, err
+}
+
+func (p *testParser) not() (bool, error) {
+	if op, _ := p.peek(0); op == "!" {
+		p.i++
+		ok, err := p.not()
+		return !ok, err
+	}
+	return p.primary()
+}
+
+func (p *testParser) primary() (bool, error) {
+	arg, ok := p.peek(0)
+	if !ok {
+		return false, errors.New("argument expected")
+	}
+	if op, ok := p.peek(1); ok && isBinaryTestOperator(op) {
+		if right, ok := p.peek(2); ok {
+			p.i += 3
+			return binaryTest(arg, op, right)
+		}
+	}
+	if arg == "(" {
+		p.i++
+		result, err := p.or()
+		if err != nil {
+			return false, err
+		}
+		if closing, _ := p.peek(0); closing != ")" {
+			return false, errors.New("`)' expected")
+		}
+		p.i++
+		return result, nil
+	}
+	if isUnaryTestOperator(arg) {
+		if operand, ok := p.peek(1); ok {
+			p.i += 2
+			return unaryTest(arg, operand)
+		}
+	}
+	p.i++
+	return arg != "", nil
+}
+
+func execute_test(c *Command, _ *IoProvider) error {
+	name := c.Executable
+	args := c.Arguments
+	if name == "[" {
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			_, _ = fmt.Fprintln(c.Stderr(), "[: missing `]'")
+			return errors.Join(errors.New("[: missing `]'"), ExitStatus(2))
+		}
+		args = args[:len(args)-1]
+	}
+	ok, err := evalTest(args)
+	if err != nil {
+		_, _ = fmt.Fprintf(c.Stderr(), "%s: %s\n", name, err)
+		return errors.Join(fmt.Errorf("%s: %w", name, err), ExitStatus(2))
+	}
+	if !ok {
+		return ExitStatus(1)
+	}
+	return nil
+}
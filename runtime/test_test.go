@@ -0,0 +1,91 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvalTest(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// file is newer than empty
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(empty, old, old); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	cases := []struct {
+		args   string
+		expect bool
+		fails  bool
+	}{
+		{args: "", expect: false},
+		{args: "word", expect: true},
+		{args: "-z", expect: true},
+		{args: "! word", expect: false},
+		{args: "-z word", expect: false},
+		{args: "-n word", expect: true},
+		{args: "-x word", expect: false},
+		{args: "word word", fails: true},
+		{args: "a = a", expect: true},
+		{args: "a != a", expect: false},
+		{args: "a < b", expect: true},
+		{args: "10 -lt 9", expect: false},
+		{args: "10 -ge 10", expect: true},
+		{args: "a -eq 1", fails: true},
+		// operands that look like operators
+		{args: "-a -a -a", expect: true},
+		{args: "= = =", expect: true},
+		{args: "! -a", expect: false},
+		{args: "( -z )", expect: true},
+		{args: "a b c", fails: true},
+		{args: "! a = b", expect: true},
+		{args: "( a = b )", expect: false},
+		// longer expressions, ! binds tighter than -a and -a tighter than -o
+		{args: "a = a -a b = c", expect: false},
+		{args: "a = a -o b = c -a x = y", expect: true},
+		{args: "! a = b -a ! -z x", expect: true},
+		{args: "( a = a -o b = b ) -a x = y", expect: false},
+		{args: "( a = a -o b = b", fails: true},
+		{args: "a = a -a", fails: true},
+		{args: "-f FILE", expect: true},
+		{args: "-d FILE", expect: false},
+		{args: "-d DIR", expect: true},
+		{args: "-e MISSING", expect: false},
+		{args: "-s FILE", expect: true},
+		{args: "-s EMPTY", expect: false},
+		{args: "FILE -nt EMPTY", expect: true},
+		{args: "FILE -ot EMPTY", expect: false},
+		{args: "FILE -nt MISSING", expect: true},
+		{args: "MISSING -ot FILE", expect: true},
+		{args: "FILE -ef FILE", expect: true},
+		{args: "FILE -ef EMPTY", expect: false},
+	}
+	replacer := strings.NewReplacer("FILE", file, "EMPTY", empty, "MISSING", missing, "DIR", dir)
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			args := make([]string, 0)
+			for _, arg := range strings.Fields(c.args) {
+				args = append(args, replacer.Replace(arg))
+			}
+			ok, err := evalTest(args)
+			if (err != nil) != c.fails {
+				t.Fatalf("error: %v, expected failure: %v", err, c.fails)
+			}
+			if err == nil && ok != c.expect {
+				t.Errorf("result: %v, expected: %v", ok, c.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// trap is a command that runs when the shell receives a signal, exits (EXIT) or a command fails (ERR).
+type trap struct {
+	command string
+	iop     *IoProvider
+}
+
+var (
+	trapsMutex sync.Mutex
+	traps      = map[string]trap{}
+	// signalChannel receives the signals that have a trap
+	signalChannel chan os.Signal
+)
+
+// trapName returns the canonical name of a signal specification like INT, SIGINT, int or 2.
+func trapName(spec string) (string, bool) {
+	upper := strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	switch upper {
+	case "EXIT", "0":
+		return "EXIT", true
+	case "ERR":
+		return "ERR", true
+	}
+	if n, err := strconv.Atoi(spec); err == nil {
+		for _, s := range signals {
+			if int(s.signal) == n {
+				return s.name, true
+			}
+		}
+		return "", false
+	}
+	for _, s := range signals {
+		if s.name == upper {
+			return s.name, true
+		}
+	}
+	return "", false
+}
+
+// SetTrap sets the command that runs for the trap name, an empty command ignores the signal.
+func SetTrap(name string, command string, iop *IoProvider) {
+	trapsMutex.Lock()
+	defer trapsMutex.Unlock()
+	traps[name] = trap{command, iop}
+	updateSignals()
+}
+
+// ResetTrap removes the trap name, the signal gets its default behavior back.
+func ResetTrap(name string) {
+	trapsMutex.Lock()
+	defer trapsMutex.Unlock()
+	delete(traps, name)
+	updateSignals()
+}
+
+// signalAction is how the shell handles a signal.
+type signalAction int
+
+const (
+	signalDefault signalAction = iota
+	signalIgnore
+	signalNotify
+)
+
+// signalActions holds the action that is set up for each signal.
+var signalActions = map[syscall.Signal]signalAction{}
+
+// wantedSignalAction returns the action the traps need for the signal s with the trap name.
+// While there is an EXIT trap, INT, TERM and HUP are caught too, so the EXIT trap runs if they end the shell.
+func wantedSignalAction(name string, s syscall.Signal) signalAction {
+	t, ok := traps[name]
+	switch {
+	case ok && t.command == "":
+		return signalIgnore
+	case ok:
+		return signalNotify
+	case traps["EXIT"].command != "" && (s == syscall.SIGINT || s == syscall.SIGTERM || s == syscall.SIGHUP):
+		return signalNotify
+	}
+	return signalDefault
+}
+
+// updateSignals subscribes to the signals that have a trap and ignores the ones with an empty trap.
+// Only the signals whose action changed are touched, so the others don't lose signals in between.
+func updateSignals() {
+	if signalChannel == nil {
+		signalChannel = make(chan os.Signal, 1)
+		go handleSignals(signalChannel)
+	}
+	for _, s := range signals {
+		action := wantedSignalAction(s.name, s.signal)
+		if action == signalActions[s.signal] {
+			continue
+		}
+		switch action {
+		case signalDefault:
+			signal.Reset(s.signal)
+		case signalIgnore:
+			signal.Ignore(s.signal)
+		case signalNotify:
+			signal.Notify(signalChannel, s.signal)
+		}
+		signalActions[s.signal] = action
+	}
+}
+
+func handleSignals(ch chan os.Signal) {
+	for sig := range ch {
+		for _, s := range signals {
+			if s.signal != sig {
+				continue
+			}
+			if !RunTrap(s.name) {
+				// the signal ends the shell, with the exit status of a process killed by the signal
+				Exit(128 + int(s.signal))
+			}
+		}
+	}
+}
+
+// RunTrap runs the command of the trap name and reports whether there is one.
+func RunTrap(name string) bool {
+	trapsMutex.Lock()
+	t, ok := traps[name]
+	trapsMutex.Unlock()
+	if !ok {
+		return false
+	}
+	if t.command != "" && Interpret != nil {
+		// the trap does not change $?
+		code := Status()
+		_ = Interpret(t.command, t.iop)
+		setStatus(code)
+	}
+	return true
+}
+
+// RunExitTrap runs the EXIT trap once, it is removed before it runs.
+func RunExitTrap() {
+	trapsMutex.Lock()
+	t, ok := traps["EXIT"]
+	delete(traps, "EXIT")
+	trapsMutex.Unlock()
+	if ok && t.command != "" && Interpret != nil {
+		_ = Interpret(t.command, t.iop)
+	}
+}
+
+// Exit runs the EXIT trap and ends the process with the given exit status.
+func Exit(code int) {
+	setStatus(code)
+	RunExitTrap()
+	if err := SaveHistory(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: %s\n", ScriptName(), err)
+	}
+	os.Exit(code)
+}
+
+// printTraps prints the traps with the given names (all if there are none) as trap commands.
+func printTraps(c *Command, names []string) {
+	trapsMutex.Lock()
+	defer trapsMutex.Unlock()
+	if len(names) == 0 {
+		names = append(names, "EXIT")
+		for _, s := range signals {
+			names = append(names, s.name)
+		}
+		names = append(names, "ERR")
+	}
+	for _, name := range names {
+		if t, ok := traps[name]; ok {
+			_, _ = fmt.Fprintf(c.Stdout(), "trap -- '%s' %s\n", strings.ReplaceAll(t.command, "'", `'\''`), name)
+		}
+	}
+}
+
+func execute_trap(c *Command, iop *IoProvider) error {
+	args := c.Arguments
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		printTraps(c, nil)
+		return nil
+	}
+	switch args[0] {
+	case "-l":
+		for _, s := range signals {
+			_, _ = fmt.Fprintf(c.Stdout(), "%2d) SIG%s\n", s.signal, s.name)
+		}
+		return nil
+	case "-p":
+		names := make([]string, 0, len(args)-1)
+		for _, spec := range args[1:] {
+			name, ok := trapName(spec)
+			if !ok {
+				_, _ = fmt.Fprintf(c.Stderr(), "trap: %s: invalid signal specification\n", spec)
+				return fmt.Errorf("trap: %s: invalid signal specification", spec)
+			}
+			names = append(names, name)
+		}
+		printTraps(c, names)
+		return nil
+	}
+
+	command, specs := args[0], args[1:]
+	reset := command == "-"
+	if len(specs) == 0 {
+		// trap SIGNAL resets the signal
+		command, specs, reset = "", args, true
+	}
+	for _, spec := range specs {
+		name, ok := trapName(spec)
+		if !ok {
+			_, _ = fmt.Fprintf(c.Stderr(), "trap: %s: invalid signal specification\n", spec)
+			return fmt.Errorf("trap: %s: invalid signal specification", spec)
+		}
+		if reset {
+			ResetTrap(name)
+		} else {
+			SetTrap(name, command, iop)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package runtime
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestUpdateSignals(t *testing.T) {
+	defer func() {
+		ResetTrap("EXIT")
+		ResetTrap("INT")
+		ResetTrap("ALRM")
+	}()
+	cases := []struct {
+		name   string
+		change func()
+		expect map[syscall.Signal]signalAction
+	}{
+		{
+			name:   "trap ALRM",
+			change: func() { SetTrap("ALRM", "echo alarm", nil) },
+			expect: map[syscall.Signal]signalAction{syscall.SIGALRM: signalNotify, syscall.SIGINT: signalDefault},
+		},
+		{
+			name:   "EXIT catches INT and TERM",
+			change: func() { SetTrap("EXIT", "echo exit", nil) },
+			expect: map[syscall.Signal]signalAction{syscall.SIGALRM: signalNotify, syscall.SIGINT: signalNotify, syscall.SIGTERM: signalNotify},
+		},
+		{
+			name:   "empty trap ignores INT",
+			change: func() { SetTrap("INT", "", nil) },
+			expect: map[syscall.Signal]signalAction{syscall.SIGINT: signalIgnore, syscall.SIGTERM: signalNotify},
+		},
+		{
+			name:   "reset INT while EXIT is set",
+			change: func() { ResetTrap("INT") },
+			expect: map[syscall.Signal]signalAction{syscall.SIGINT: signalNotify},
+		},
+		{
+			name:   "reset EXIT",
+			change: func() { ResetTrap("EXIT") },
+			expect: map[syscall.Signal]signalAction{syscall.SIGALRM: signalNotify, syscall.SIGINT: signalDefault, syscall.SIGTERM: signalDefault},
+		},
+		{
+			name:   "reset ALRM",
+			change: func() { ResetTrap("ALRM") },
+			expect: map[syscall.Signal]signalAction{syscall.SIGALRM: signalDefault},
+		},
+	}
+	// the cases build on each other
+	for _, c := range cases {
+		c.change()
+		trapsMutex.Lock()
+		for sig, action := range c.expect {
+			if signalActions[sig] != action {
+				t.Errorf("%s: action of %v: %d, expected: %d", c.name, sig, signalActions[sig], action)
+			}
+		}
+		trapsMutex.Unlock()
+	}
+}
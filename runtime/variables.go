@@ -0,0 +1,640 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type variableKind uint8
+
+const (
+	variableScalar variableKind = iota
+	variableIndexed
+	variableAssociative
+)
+
+// variable is a shell variable that is not exported.
+// Exported scalar variables live in the environment of the process, so child processes inherit them.
+type variable struct {
+	kind    variableKind
+	value   string
+	indexed map[int]string
+	assoc   map[string]string
+}
+
+// Assignment is a variable assignment like NAME=value, NAME+=value, NAME[key]=value or NAME=(a b c).
+type Assignment struct {
+	Name string
+	// Key is the subscript of NAME[key]=value, Subscript reports whether there is one.
+	Key       string
+	Subscript bool
+	// Append is set for +=
+	Append bool
+	Value  string
+	// Array holds the elements of NAME=(...), it is nil for other assignments.
+	Array []string
+}
+
+// subscript is an evaluated array subscript.
+// Subscripts of indexed arrays are arithmetic expressions, they are evaluated before the variables get locked
+// because the expression can refer to other variables.
+type subscript struct {
+	key   string
+	index int64
+}
+
+// variableAttributes are the attributes set with declare, they apply to shell and environment variables.
+type variableAttributes uint8
+
+const (
+	attributeInteger variableAttributes = 1 << iota
+	attributeLower
+	attributeNameref
+	attributeReadonly
+	attributeUpper
+)
+
+// ErrReadonly is the error of an assignment to a readonly variable.
+var ErrReadonly = errors.New("readonly variable")
+
+var (
+	variablesMutex sync.RWMutex
+	variables      = map[string]*variable{}
+	attributes     = map[string]variableAttributes{}
+)
+
+// resolve follows the name references (declare -n) starting at name and returns the name they refer to.
+// variablesMutex must be held.
+func resolve(name string) string {
+	// a limit protects against reference loops
+	for i := 0; i < 10 && attributes[name]&attributeNameref != 0; i++ {
+		v := variables[name]
+		if v == nil || v.kind != variableScalar || !IsName(v.value) {
+			break
+		}
+		name = v.value
+	}
+	return name
+}
+
+func resolveName(name string) string {
+	variablesMutex.RLock()
+	defer variablesMutex.RUnlock()
+	return resolve(name)
+}
+
+func attributesOf(name string) variableAttributes {
+	variablesMutex.RLock()
+	defer variablesMutex.RUnlock()
+	return attributes[name]
+}
+
+// setAttributes adds the attributes set to the variable name and removes the attributes unset.
+func setAttributes(name string, set, unset variableAttributes) {
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	// lower and upper case exclude each other
+	if set&attributeLower != 0 {
+		unset |= attributeUpper
+	}
+	if set&attributeUpper != 0 {
+		unset |= attributeLower
+	}
+	if attrs := attributes[name]&^unset | set; attrs != 0 {
+		attributes[name] = attrs
+	} else {
+		delete(attributes, name)
+	}
+}
+
+// convert applies the integer and case attributes to a value that gets assigned.
+func (attrs variableAttributes) convert(value string) (string, error) {
+	if attrs&attributeInteger != 0 {
+		n, err := Arithmetic(value)
+		if err != nil {
+			return "", err
+		}
+		value = strconv.FormatInt(n, 10)
+	}
+	switch {
+	case attrs&attributeLower != 0:
+		value = strings.ToLower(value)
+	case attrs&attributeUpper != 0:
+		value = strings.ToUpper(value)
+	}
+	return value, nil
+}
+
+// ParseAssignment parses an assignment word like NAME=value, NAME+=value or NAME[key]=value.
+// It reports false if word is not an assignment.
+func ParseAssignment(word string) (Assignment, bool) {
+	a := Assignment{}
+	i := 0
+	for i < len(word) && isNameChar(word[i]) {
+		i++
+	}
+	a.Name = word[:i]
+	if !IsName(a.Name) {
+		return a, false
+	}
+	if i < len(word) && word[i] == '[' {
+		end := strings.Index(word[i:], "]")
+		if end == -1 {
+			return a, false
+		}
+		end += i
+		a.Key = word[i+1 : end]
+		a.Subscript = true
+		i = end + 1
+	}
+	if strings.HasPrefix(word[i:], "+=") {
+		a.Append = true
+		i++
+	}
+	if i >= len(word) || word[i] != '=' {
+		return a, false
+	}
+	a.Value = word[i+1:]
+	return a, true
+}
+
+func kindOf(name string) variableKind {
+	variablesMutex.RLock()
+	defer variablesMutex.RUnlock()
+	if v := variables[resolve(name)]; v != nil {
+		return v.kind
+	}
+	return variableScalar
+}
+
+// evalSubscript evaluates key for an array of the given kind.
+func evalSubscript(kind variableKind, key string) (subscript, error) {
+	if kind == variableAssociative {
+		return subscript{key: key}, nil
+	}
+	i, err := Arithmetic(key)
+	if err != nil {
+		return subscript{}, err
+	}
+	return subscript{key: key, index: i}, nil
+}
+
+// Assign performs the assignment on the shell variables.
+// Assignments to name references assign the variable they refer to,
+// the value is converted according to the attributes of the variable.
+func Assign(a Assignment) error {
+	a.Name = resolveName(a.Name)
+	attrs := attributesOf(a.Name)
+	if attrs&attributeReadonly != 0 {
+		return ErrReadonly
+	}
+	if attrs&attributeInteger != 0 && a.Append && a.Array == nil {
+		// += adds to an integer variable
+		old, _ := Variable(a.Name)
+		if a.Subscript {
+			old, _, _ = VariableElement(a.Name, a.Key)
+		}
+		if old != "" {
+			a.Value = "(" + old + ")+(" + a.Value + ")"
+		}
+		a.Append = false
+	}
+	if a.Array == nil {
+		value, err := attrs.convert(a.Value)
+		if err != nil {
+			return err
+		}
+		a.Value = value
+	}
+
+	kind := kindOf(a.Name)
+	switch {
+	case a.Array != nil:
+		if kind == variableScalar {
+			kind = variableIndexed
+		}
+		// evaluate the subscripts of [key]=value elements first
+		elements := make([]arrayElement, len(a.Array))
+		for i, element := range a.Array {
+			elements[i].value = element
+			if strings.HasPrefix(element, "[") {
+				if end := strings.Index(element, "]="); end != -1 {
+					s, err := evalSubscript(kind, element[1:end])
+					if err != nil {
+						return err
+					}
+					elements[i].subscript = &s
+					elements[i].value = element[end+2:]
+				}
+			}
+			value, err := attrs.convert(elements[i].value)
+			if err != nil {
+				return err
+			}
+			elements[i].value = value
+		}
+		variablesMutex.Lock()
+		defer variablesMutex.Unlock()
+		v := variables[a.Name]
+		if v == nil || v.kind == variableScalar || !a.Append {
+			old := v
+			v = newArray(kind)
+			if a.Append {
+				// appending to a scalar keeps its value as the first element
+				if value, ok := lookupScalar(a.Name, old); ok {
+					v.indexed[0] = value
+				}
+			}
+			variables[a.Name] = v
+			_ = os.Unsetenv(a.Name)
+		}
+		return v.assignArray(elements)
+
+	case a.Subscript:
+		s, err := evalSubscript(kind, a.Key)
+		if err != nil {
+			return err
+		}
+		variablesMutex.Lock()
+		defer variablesMutex.Unlock()
+		v := variables[a.Name]
+		if v == nil || v.kind == variableScalar {
+			value, ok := lookupScalar(a.Name, v)
+			v = newArray(variableIndexed)
+			if ok {
+				v.indexed[0] = value
+			}
+			variables[a.Name] = v
+			_ = os.Unsetenv(a.Name)
+		}
+		return v.set(s, a.Value, a.Append)
+
+	default:
+		variablesMutex.Lock()
+		defer variablesMutex.Unlock()
+		v := variables[a.Name]
+		if v != nil && v.kind != variableScalar {
+			// assigning to an array without a subscript assigns to element 0
+			return v.set(subscript{key: "0"}, a.Value, a.Append)
+		}
+		value := a.Value
+		if a.Append {
+			old, _ := lookupScalar(a.Name, v)
+			value = old + value
+		}
+		if v == nil {
+			if _, exported := os.LookupEnv(a.Name); exported {
+				return os.Setenv(a.Name, value)
+			}
+			variables[a.Name] = &variable{kind: variableScalar, value: value}
+			return nil
+		}
+		v.value = value
+		return nil
+	}
+}
+
+func newArray(kind variableKind) *variable {
+	return &variable{kind: kind, indexed: map[int]string{}, assoc: map[string]string{}}
+}
+
+// lookupScalar returns the value of the scalar variable v or the environment variable name if v is nil.
+func lookupScalar(name string, v *variable) (string, bool) {
+	if v == nil {
+		return os.LookupEnv(name)
+	}
+	return v.value, v.kind == variableScalar
+}
+
+// arrayElement is an element of name=(...), subscript is set for [key]=value elements.
+type arrayElement struct {
+	subscript *subscript
+	value     string
+}
+
+func (v *variable) assignArray(elements []arrayElement) error {
+	next := v.last() + 1
+	for _, element := range elements {
+		if element.subscript != nil {
+			if err := v.set(*element.subscript, element.value, false); err != nil {
+				return err
+			}
+			if v.kind == variableIndexed {
+				next, _ = v.index(element.subscript.index)
+				next++
+			}
+			continue
+		}
+		if v.kind == variableAssociative {
+			return fmt.Errorf("%s: must use subscript when assigning associative array", element.value)
+		}
+		v.indexed[next] = element.value
+		next++
+	}
+	return nil
+}
+
+// last returns the highest index of an indexed array or -1 if it is empty.
+func (v *variable) last() int {
+	last := -1
+	for k := range v.indexed {
+		last = max(last, k)
+	}
+	return last
+}
+
+// index resolves negative indices, which count from the end of the array.
+func (v *variable) index(i int64) (int, error) {
+	if i < 0 {
+		i += int64(v.last()) + 1
+		if i < 0 {
+			return 0, fmt.Errorf("%d: bad array subscript", i)
+		}
+	}
+	return int(i), nil
+}
+
+func (v *variable) set(s subscript, value string, appendValue bool) error {
+	if v.kind == variableAssociative {
+		if appendValue {
+			value = v.assoc[s.key] + value
+		}
+		v.assoc[s.key] = value
+		return nil
+	}
+	i, err := v.index(s.index)
+	if err != nil {
+		return err
+	}
+	if appendValue {
+		value = v.indexed[i] + value
+	}
+	v.indexed[i] = value
+	return nil
+}
+
+func (v *variable) get(s subscript) (string, bool) {
+	switch v.kind {
+	case variableAssociative:
+		value, ok := v.assoc[s.key]
+		return value, ok
+	case variableIndexed:
+		i, err := v.index(s.index)
+		if err != nil {
+			return "", false
+		}
+		value, ok := v.indexed[i]
+		return value, ok
+	default:
+		return v.value, s.index == 0
+	}
+}
+
+// keys returns the indices or keys of the elements in ascending order.
+func (v *variable) keys() []subscript {
+	switch v.kind {
+	case variableAssociative:
+		keys := make([]subscript, 0, len(v.assoc))
+		for k := range v.assoc {
+			keys = append(keys, subscript{key: k})
+		}
+		slices.SortFunc(keys, func(a, b subscript) int {
+			return strings.Compare(a.key, b.key)
+		})
+		return keys
+	case variableIndexed:
+		indices := make([]int, 0, len(v.indexed))
+		for k := range v.indexed {
+			indices = append(indices, k)
+		}
+		slices.Sort(indices)
+		keys := make([]subscript, len(indices))
+		for i, k := range indices {
+			keys[i] = subscript{key: strconv.Itoa(k), index: int64(k)}
+		}
+		return keys
+	default:
+		return []subscript{{key: "0"}}
+	}
+}
+
+// Variable returns the value of the shell or environment variable name.
+// For arrays this is the element with index (or key) 0.
+func Variable(name string) (string, bool) {
+	variablesMutex.RLock()
+	defer variablesMutex.RUnlock()
+	name = resolve(name)
+	v := variables[name]
+	if v == nil {
+		return os.LookupEnv(name)
+	}
+	return v.get(subscript{key: "0"})
+}
+
+// VariableElement returns the element key of the array name (${name[key]}).
+// The key of an indexed array is an arithmetic expression.
+func VariableElement(name string, key string) (string, bool, error) {
+	name = resolveName(name)
+	s, err := evalSubscript(kindOf(name), key)
+	if err != nil {
+		return "", false, err
+	}
+	variablesMutex.RLock()
+	defer variablesMutex.RUnlock()
+	v := variables[name]
+	if v == nil {
+		value, ok := os.LookupEnv(name)
+		return value, ok && s.index == 0, nil
+	}
+	value, ok := v.get(s)
+	return value, ok, nil
+}
+
+// VariableValues returns all elements of the array name (${name[@]}) or the value of a scalar variable.
+func VariableValues(name string) []string {
+	variablesMutex.RLock()
+	defer variablesMutex.RUnlock()
+	name = resolve(name)
+	v := variables[name]
+	if v == nil {
+		if value, ok := os.LookupEnv(name); ok {
+			return []string{value}
+		}
+		return nil
+	}
+	keys := v.keys()
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		values[i], _ = v.get(k)
+	}
+	return values
+}
+
+// VariableKeys returns the indices or keys of the array name (${!name[@]}).
+func VariableKeys(name string) []string {
+	variablesMutex.RLock()
+	defer variablesMutex.RUnlock()
+	name = resolve(name)
+	v := variables[name]
+	if v == nil {
+		if _, ok := os.LookupEnv(name); ok {
+			return []string{"0"}
+		}
+		return nil
+	}
+	keys := v.keys()
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.key
+	}
+	return names
+}
+
+// DeclareArray turns name into an indexed or associative array, an existing value is kept as element 0.
+func DeclareArray(name string, associative bool) error {
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	kind := variableIndexed
+	if associative {
+		kind = variableAssociative
+	}
+	name = resolve(name)
+	v := variables[name]
+	switch {
+	case v == nil || v.kind == variableScalar:
+		value, ok := lookupScalar(name, v)
+		v = newArray(kind)
+		if ok {
+			_ = v.set(subscript{key: "0"}, value, false)
+		}
+		variables[name] = v
+		_ = os.Unsetenv(name)
+	case v.kind != kind:
+		if associative {
+			return fmt.Errorf("%s: cannot convert indexed to associative array", name)
+		}
+		return fmt.Errorf("%s: cannot convert associative to indexed array", name)
+	}
+	return nil
+}
+
+// SetArray replaces the variable name with an indexed array of values.
+// Unlike Assign with an array literal, values like [key]=value are not subscripts.
+func SetArray(name string, values []string) error {
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	name = resolve(name)
+	if attributes[name]&attributeReadonly != 0 {
+		return ErrReadonly
+	}
+	v := newArray(variableIndexed)
+	for i, value := range values {
+		v.indexed[i] = value
+	}
+	variables[name] = v
+	return os.Unsetenv(name)
+}
+
+// ExportVariable moves the shell variable name into the environment, so child processes inherit it.
+// Arrays can't be exported.
+func ExportVariable(name string) error {
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	name = resolve(name)
+	v := variables[name]
+	if v == nil {
+		if _, ok := os.LookupEnv(name); !ok {
+			return os.Setenv(name, "")
+		}
+		return nil
+	}
+	if v.kind != variableScalar {
+		return nil
+	}
+	delete(variables, name)
+	return os.Setenv(name, v.value)
+}
+
+// UnexportVariable moves the environment variable name back into the shell variables.
+func UnexportVariable(name string) error {
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	name = resolve(name)
+	value, ok := os.LookupEnv(name)
+	if !ok || variables[name] != nil {
+		return nil
+	}
+	variables[name] = &variable{kind: variableScalar, value: value}
+	return os.Unsetenv(name)
+}
+
+// UnsetVariable removes the shell or environment variable name, a name reference unsets the variable it refers to.
+// Readonly variables can't be unset.
+func UnsetVariable(name string) error {
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	return unset(resolve(name))
+}
+
+// UnsetNameref removes the variable name itself, even if it is a name reference (unset -n).
+func UnsetNameref(name string) error {
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	return unset(name)
+}
+
+// ResetVariable removes the variable name with all of its attributes, even if it is readonly or a name reference.
+// Scripts can't do that, it is meant for tests that have to clean up after themselves.
+func ResetVariable(name string) {
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	delete(variables, name)
+	delete(attributes, name)
+	_ = os.Unsetenv(name)
+}
+
+// unset removes the variable name, variablesMutex must be held.
+func unset(name string) error {
+	if attributes[name]&attributeReadonly != 0 {
+		return fmt.Errorf("%s: cannot unset: readonly variable", name)
+	}
+	delete(variables, name)
+	delete(attributes, name)
+	return os.Unsetenv(name)
+}
+
+// UnsetVariableElement removes the element key of the array name (unset 'name[key]').
+func UnsetVariableElement(name string, key string) error {
+	name = resolveName(name)
+	if attributesOf(name)&attributeReadonly != 0 {
+		return fmt.Errorf("%s: cannot unset: readonly variable", name)
+	}
+	s, err := evalSubscript(kindOf(name), key)
+	if err != nil {
+		return err
+	}
+	variablesMutex.Lock()
+	defer variablesMutex.Unlock()
+	v := variables[name]
+	switch {
+	case v == nil || v.kind == variableScalar:
+		if s.index == 0 {
+			delete(variables, name)
+			return os.Unsetenv(name)
+		}
+	case v.kind == variableAssociative:
+		delete(v.assoc, s.key)
+	default:
+		i, err := v.index(s.index)
+		if err != nil {
+			return err
+		}
+		delete(v.indexed, i)
+	}
+	return nil
+}